@@ -0,0 +1,119 @@
+package qos
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"sync"
+)
+
+// Access control errors
+var (
+	ErrAuthRequired     = errors.New("authentication required")
+	ErrBadCredentials   = errors.New("invalid user name or password")
+	ErrPermissionDenied = errors.New("permission denied")
+)
+
+// Commands allowed for predefined roles
+var (
+	viewerCommands   = []string{"SLIST", "SGET", "CLIST", "CGET", "VERSIONS", "VDIFF"}
+	operatorCommands = append([]string{"CLIMIT", "KILL", "THROTTLE"}, viewerCommands...)
+	adminCommands    = append([]string{"SLIMIT", "HISTORY", "ROLLBACK", "RELOAD", "SHUTDOWN"}, operatorCommands...)
+)
+
+// Role is a named set of admin commands an operator is allowed to run.
+// Role can be optionally restricted to a set of servers.
+type Role struct {
+	Name     string
+	commands map[string]bool
+	servers  map[string]bool // empty means all servers
+}
+
+// NewRole Role ctor. If no servers are given, role applies to all servers.
+func NewRole(name string, commands []string, servers ...string) *Role {
+	r := &Role{
+		Name:     name,
+		commands: make(map[string]bool),
+		servers:  make(map[string]bool),
+	}
+	for _, c := range commands {
+		r.commands[c] = true
+	}
+	for _, s := range servers {
+		r.servers[s] = true
+	}
+	return r
+}
+
+// NewViewerRole role that can only inspect servers and connections.
+func NewViewerRole(servers ...string) *Role {
+	return NewRole("viewer", viewerCommands, servers...)
+}
+
+// NewOperatorRole role that can inspect, tune and kill connections and toggle throttling.
+func NewOperatorRole(servers ...string) *Role {
+	return NewRole("operator", operatorCommands, servers...)
+}
+
+// NewAdminRole role that can run every admin command.
+func NewAdminRole(servers ...string) *Role {
+	return NewRole("admin", adminCommands, servers...)
+}
+
+// CanRun is role allowed to run a command?
+func (r *Role) CanRun(action string) bool {
+	return r.commands[action]
+}
+
+// CanAccessServer is role allowed to operate on a server?
+func (r *Role) CanAccessServer(serverName string) bool {
+	if len(r.servers) == 0 {
+		return true
+	}
+	return r.servers[serverName]
+}
+
+type accessUser struct {
+	passwordHash [sha256.Size]byte
+	role         *Role
+}
+
+// AccessControl keeps admin users and their roles.
+type AccessControl struct {
+	users map[string]*accessUser
+	mu    *sync.RWMutex
+}
+
+// NewAccessControl AccessControl ctor
+func NewAccessControl() *AccessControl {
+	return &AccessControl{
+		users: make(map[string]*accessUser),
+		mu:    new(sync.RWMutex),
+	}
+}
+
+// AddUser add a user or replace an existing one.
+func (a *AccessControl) AddUser(name, password string, role *Role) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.users[name] = &accessUser{
+		passwordHash: sha256.Sum256([]byte(password)),
+		role:         role,
+	}
+}
+
+// Authenticate check user credentials and return user's role.
+func (a *AccessControl) Authenticate(name, password string) (*Role, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	u, ok := a.users[name]
+	if !ok {
+		return nil, ErrBadCredentials
+	}
+	hash := sha256.Sum256([]byte(password))
+	if subtle.ConstantTimeCompare(hash[:], u.passwordHash[:]) != 1 {
+		return nil, ErrBadCredentials
+	}
+	return u.role, nil
+}
@@ -0,0 +1,59 @@
+package qos_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kolotaev/qos"
+)
+
+func TestRole_CanRun(t *testing.T) {
+	r := qos.NewRole("support", []string{"CLIST", "CLIMIT"})
+	assert.True(t, r.CanRun("CLIST"))
+	assert.True(t, r.CanRun("CLIMIT"))
+	assert.False(t, r.CanRun("SLIMIT"))
+	assert.False(t, r.CanRun("THROTTLE"))
+}
+
+func TestRole_CanAccessServer(t *testing.T) {
+	all := qos.NewAdminRole()
+	assert.True(t, all.CanAccessServer("srv1"))
+	assert.True(t, all.CanAccessServer("srv2"))
+
+	restricted := qos.NewOperatorRole("srv1")
+	assert.True(t, restricted.CanAccessServer("srv1"))
+	assert.False(t, restricted.CanAccessServer("srv2"))
+}
+
+func TestRole_Predefined(t *testing.T) {
+	viewer := qos.NewViewerRole()
+	assert.True(t, viewer.CanRun("CLIST"))
+	assert.False(t, viewer.CanRun("CLIMIT"))
+
+	operator := qos.NewOperatorRole()
+	assert.True(t, operator.CanRun("CLIMIT"))
+	assert.True(t, operator.CanRun("THROTTLE"))
+	assert.False(t, operator.CanRun("SLIMIT"))
+
+	admin := qos.NewAdminRole()
+	assert.True(t, admin.CanRun("SLIMIT"))
+}
+
+func TestAccessControl_Authenticate(t *testing.T) {
+	ac := qos.NewAccessControl()
+	role := qos.NewViewerRole()
+	ac.AddUser("bob", "secret", role)
+
+	r, err := ac.Authenticate("bob", "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, role, r)
+
+	r, err = ac.Authenticate("bob", "wrong")
+	assert.ErrorIs(t, err, qos.ErrBadCredentials)
+	assert.Nil(t, r)
+
+	r, err = ac.Authenticate("alice", "secret")
+	assert.ErrorIs(t, err, qos.ErrBadCredentials)
+	assert.Nil(t, r)
+}
@@ -0,0 +1,568 @@
+package qos
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Admin command errors
+var (
+	ErrUnknownServer      = errors.New("unknown server")
+	ErrUnknownConnection  = errors.New("unknown connection")
+	ErrBadNumber          = errors.New("failed to parse limit number")
+	ErrUnsupportedCommand = errors.New("command is not supported by admin server")
+	ErrBadArgument        = errors.New("bad argument")
+)
+
+// errorCode get a stable machine readable code of an admin command error.
+func errorCode(err error) string {
+	codes := []struct {
+		err  error
+		code string
+	}{
+		{ErrEmptyCommand, "unknown_command"},
+		{ErrUnknownCommand, "unknown_command"},
+		{ErrArgsCountMismatch, "bad_arguments_count"},
+		{ErrBadArgument, "bad_argument"},
+		{ErrBadNumber, "bad_number"},
+		{ErrUnsupportedCommand, "unsupported_command"},
+		{ErrUnknownServer, "unknown_server"},
+		{ErrUnknownConnection, "unknown_connection"},
+		{ErrAuthRequired, "auth_required"},
+		{ErrBadCredentials, "bad_credentials"},
+		{ErrPermissionDenied, "permission_denied"},
+		{ErrRESPProtocol, "protocol_error"},
+		{ErrUnknownVersion, "unknown_version"},
+		{ErrTransaction, "transaction_error"},
+		{ErrTransactionAborted, "transaction_aborted"},
+		{ErrInvalidConfig, "invalid_config"},
+	}
+	for _, c := range codes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return "internal_error"
+}
+
+// ServerInfo describes a server's Throttler.
+type ServerInfo struct {
+	Name        string `json:"name"`
+	Limit       int64  `json:"limit"`
+	Enabled     bool   `json:"enabled"`
+	Connections int    `json:"connections"`
+}
+
+// ServerConnectionInfo describes a connection of a particular server.
+type ServerConnectionInfo struct {
+	Server string `json:"server"`
+	ConnectionInfo
+}
+
+// ReloadFunc reloads configuration of running servers and reports the applied changes.
+type ReloadFunc func() ([]ConfigChange, error)
+
+// ShutdownFunc starts a graceful shutdown that lets transfers in progress finish within drainTimeout.
+// Zero drainTimeout means a default one. It must not wait for the shutdown to complete.
+type ShutdownFunc func(drainTimeout time.Duration)
+
+// ChangeResult describes a change that was applied differently than requested.
+type ChangeResult struct {
+	Warnings []string `json:"warnings"`
+}
+
+// ServerAllocationChange describes a would-be change of a connection's limit on a particular server.
+type ServerAllocationChange struct {
+	Server string `json:"server"`
+	AllocationChange
+}
+
+// Commands that control a session and are allowed for every authenticated role
+var sessionCommands = map[string]bool{
+	"MULTI":   true,
+	"EXEC":    true,
+	"DISCARD": true,
+}
+
+// adminSession state of a single admin client session
+type adminSession struct {
+	id          uint64
+	remoteAddr  string
+	user        string
+	role        *Role
+	transaction *transaction
+}
+
+// canAccessServer is session allowed to operate on a server?
+func (s *adminSession) canAccessServer(serverName string) bool {
+	return s.role == nil || s.role.CanAccessServer(serverName)
+}
+
+// adminCore executes admin commands against Throttlers.
+// It is shared by all admin servers regardless of their protocol, so they can't drift apart.
+type adminCore struct {
+	throttlers    map[string]*Throttler
+	accessControl *AccessControl
+	auditLog      *AuditLog
+	reload        ReloadFunc
+	shutdown      ShutdownFunc
+	lastSessionID uint64
+	logger        *log.Logger
+	mu            *sync.RWMutex // guards throttlers and accessControl that can be changed by reload
+}
+
+func newAdminCore(throttlers map[string]*Throttler, logger *log.Logger) *adminCore {
+	c := &adminCore{
+		throttlers: make(map[string]*Throttler),
+		logger:     logger,
+		mu:         new(sync.RWMutex),
+	}
+	for name, t := range throttlers {
+		c.throttlers[name] = t
+	}
+	return c
+}
+
+func (c *adminCore) addServer(name string, throttler *Throttler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.throttlers[name] = throttler
+}
+
+func (c *adminCore) removeServer(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.throttlers, name)
+}
+
+func (c *adminCore) setAccessControl(accessControl *AccessControl) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.accessControl = accessControl
+}
+
+func (c *adminCore) getAccessControl() *AccessControl {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.accessControl
+}
+
+// newSession start a new client session.
+func (c *adminCore) newSession(remoteAddr string) *adminSession {
+	return &adminSession{
+		id:         atomic.AddUint64(&c.lastSessionID, 1),
+		remoteAddr: remoteAddr,
+	}
+}
+
+// execute run a command on behalf of a session.
+// Result is nil for commands that don't return data, otherwise it's one of the *Info types or a slice of them.
+func (c *adminCore) execute(session *adminSession, cmd *Command) (interface{}, error) {
+	if session.transaction != nil && !sessionCommands[cmd.Action] {
+		return c.queue(session, cmd)
+	}
+	if err := c.authorize(session, cmd); err != nil {
+		c.logger.Printf("Client %s: %s", session.remoteAddr, err)
+		return nil, err
+	}
+
+	var res interface{}
+	var err error
+	switch cmd.Action {
+	case "AUTH":
+		err = c.authenticate(session, cmd.GetArg(0), cmd.GetArg(1))
+		if err != nil {
+			c.logger.Printf("Client %s failed to authenticate as `%s`", session.remoteAddr, cmd.GetArg(0))
+			return nil, err
+		}
+		c.logger.Printf("Client %s authenticated as `%s` with role `%s`", session.remoteAddr, session.user, session.role.Name)
+		return nil, nil
+	case "MULTI":
+		err = c.multi(session)
+	case "EXEC":
+		res, err = c.exec(session)
+	case "DISCARD":
+		err = c.discard(session)
+	case "SLIST":
+		res = c.listServers(session)
+	case "SGET":
+		res, err = c.getServer(cmd.GetArg(0))
+	case "THROTTLE", "SLIMIT", "CLIMIT", "ROLLBACK":
+		var warnings []string
+		warnings, err = c.applyOne(session, cmd)
+		if len(warnings) > 0 {
+			res = &ChangeResult{Warnings: warnings}
+		}
+	case "DRYRUN":
+		res, err = c.dryRun(session, cmd)
+	case "VERSIONS":
+		res, err = c.listVersions(cmd.GetArg(0))
+	case "VDIFF":
+		res, err = c.diffVersions(cmd.GetArg(0), cmd.GetArg(1), cmd.GetArg(2))
+	case "CLIST":
+		res, err = c.listConnections(cmd.GetArg(0))
+	case "CGET":
+		res, err = c.getConnection(session, cmd.GetArg(0))
+	case "KILL":
+		err = c.killConnection(session, cmd)
+		if err == nil {
+			c.logger.Printf("Connection `%s` was killed", cmd.GetArg(0))
+		}
+	case "HISTORY":
+		res, err = c.history(session, cmd.GetArg(0))
+	case "RELOAD":
+		res, err = c.reloadConfig(session, cmd)
+	case "SHUTDOWN":
+		err = c.requestShutdown(session, cmd)
+	default:
+		err = fmt.Errorf("%w: %s", ErrUnsupportedCommand, cmd.Action)
+	}
+	if err != nil {
+		c.logger.Println(err)
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *adminCore) authorize(session *adminSession, cmd *Command) error {
+	if c.getAccessControl() == nil || cmd.Action == "AUTH" {
+		return nil
+	}
+	if session.role == nil {
+		return ErrAuthRequired
+	}
+	if sessionCommands[cmd.Action] {
+		return nil
+	}
+	if cmd.Action == "DRYRUN" {
+		// Dry-run is allowed to those who can run the command itself
+		planned, err := dryRunCommand(cmd)
+		if err != nil {
+			return err
+		}
+		return c.authorize(session, planned)
+	}
+	if !session.role.CanRun(cmd.Action) {
+		return fmt.Errorf("%w: role `%s` can't run %s", ErrPermissionDenied, session.role.Name, cmd.Action)
+	}
+	switch cmd.Action {
+	case "SGET", "THROTTLE", "SLIMIT", "CLIST", "VERSIONS", "VDIFF", "ROLLBACK":
+		if !session.role.CanAccessServer(cmd.GetArg(0)) {
+			return fmt.Errorf("%w: role `%s` can't access server %s", ErrPermissionDenied, session.role.Name, cmd.GetArg(0))
+		}
+	case "RELOAD", "SHUTDOWN":
+		// Reload and shutdown change every server, so it's not allowed to roles restricted to some of them
+		if len(session.role.servers) > 0 {
+			return fmt.Errorf("%w: role `%s` is restricted to some servers and can't run %s on all servers",
+				ErrPermissionDenied, session.role.Name, cmd.Action)
+		}
+	}
+	return nil
+}
+
+func (c *adminCore) authenticate(session *adminSession, user, password string) error {
+	accessControl := c.getAccessControl()
+	if accessControl == nil {
+		return errors.New("authentication is not configured")
+	}
+	role, err := accessControl.Authenticate(user, password)
+	if err != nil {
+		return err
+	}
+	session.user = user
+	session.role = role
+	return nil
+}
+
+func (c *adminCore) throttler(serverName string) (*Throttler, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	throttler, ok := c.throttlers[serverName]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownServer, serverName)
+	}
+	return throttler, nil
+}
+
+// accessibleServers get sorted names of servers the session can operate on.
+func (c *adminCore) accessibleServers(session *adminSession) []string {
+	names, _ := c.accessibleThrottlers(session)
+	return names
+}
+
+// accessibleThrottlers get sorted names of servers the session can operate on and their throttlers.
+// Throttlers are a snapshot, so they can be used while servers are added or removed.
+func (c *adminCore) accessibleThrottlers(session *adminSession) ([]string, map[string]*Throttler) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	names := []string{}
+	throttlers := make(map[string]*Throttler)
+	for name, t := range c.throttlers {
+		if session.canAccessServer(name) {
+			names = append(names, name)
+			throttlers[name] = t
+		}
+	}
+	sort.Strings(names)
+	return names, throttlers
+}
+
+func (c *adminCore) listServers(session *adminSession) []ServerInfo {
+	res := []ServerInfo{}
+	for _, name := range c.accessibleServers(session) {
+		info, _ := c.getServer(name)
+		res = append(res, *info)
+	}
+	return res
+}
+
+func (c *adminCore) getServer(serverName string) (*ServerInfo, error) {
+	throttler, err := c.throttler(serverName)
+	if err != nil {
+		return nil, err
+	}
+	return &ServerInfo{
+		Name:        serverName,
+		Limit:       throttler.GetBandwidthLimit(),
+		Enabled:     throttler.IsEnabled(),
+		Connections: len(throttler.Connections()),
+	}, nil
+}
+
+func (c *adminCore) listConnections(serverName string) ([]ConnectionInfo, error) {
+	throttler, err := c.throttler(serverName)
+	if err != nil {
+		return nil, err
+	}
+	return throttler.Connections(), nil
+}
+
+// dryRun compute connection limits that SLIMIT or CLIMIT would result in without applying it.
+func (c *adminCore) dryRun(session *adminSession, cmd *Command) ([]ServerAllocationChange, error) {
+	planned, err := dryRunCommand(cmd)
+	if err != nil {
+		return nil, err
+	}
+	lim, err := parseLimit(planned.GetArg(1))
+	if err != nil {
+		return nil, err
+	}
+
+	res := []ServerAllocationChange{}
+	if planned.Action == "SLIMIT" {
+		throttler, err := c.throttler(planned.GetArg(0))
+		if err != nil {
+			return nil, err
+		}
+		for _, change := range throttler.PlanBandwidthLimit(lim) {
+			res = append(res, ServerAllocationChange{Server: planned.GetArg(0), AllocationChange: change})
+		}
+		return res, nil
+	}
+	names, throttlers := c.accessibleThrottlers(session)
+	for _, name := range names {
+		for _, change := range throttlers[name].PlanBandwidthLimitForConnection(lim, planned.GetArg(0)) {
+			res = append(res, ServerAllocationChange{Server: name, AllocationChange: change})
+		}
+	}
+	return res, nil
+}
+
+// dryRunCommand get a command planned by DRYRUN.
+func dryRunCommand(cmd *Command) (*Command, error) {
+	// Commands are case-insensitive for RESP clients, so is the planned one
+	action := strings.ToUpper(cmd.GetArg(0))
+	if action != "SLIMIT" && action != "CLIMIT" {
+		return nil, fmt.Errorf("%w: only SLIMIT and CLIMIT can be dry-run, got %s", ErrBadArgument, cmd.GetArg(0))
+	}
+	planned, err := ParseArgs(append([]string{action}, cmd.Args[1:]...))
+	if err != nil {
+		return nil, err
+	}
+	return planned, nil
+}
+
+func (c *adminCore) listVersions(serverName string) ([]ThrottlerConfig, error) {
+	throttler, err := c.throttler(serverName)
+	if err != nil {
+		return nil, err
+	}
+	return throttler.Versions(), nil
+}
+
+func (c *adminCore) diffVersions(serverName, from, to string) ([]ConfigChange, error) {
+	throttler, err := c.throttler(serverName)
+	if err != nil {
+		return nil, err
+	}
+	fromVersion, err := parseVersion(from)
+	if err != nil {
+		return nil, err
+	}
+	toVersion, err := parseVersion(to)
+	if err != nil {
+		return nil, err
+	}
+	return throttler.DiffVersions(fromVersion, toVersion)
+}
+
+func (c *adminCore) getConnection(session *adminSession, connectionAddress string) ([]ServerConnectionInfo, error) {
+	res := []ServerConnectionInfo{}
+	names, throttlers := c.accessibleThrottlers(session)
+	for _, name := range names {
+		for _, conn := range throttlers[name].Connections() {
+			if conn.Key == connectionAddress {
+				res = append(res, ServerConnectionInfo{Server: name, ConnectionInfo: conn})
+			}
+		}
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("%w %s", ErrUnknownConnection, connectionAddress)
+	}
+	return res, nil
+}
+
+func (c *adminCore) killConnection(session *adminSession, cmd *Command) error {
+	connectionAddress := cmd.GetArg(0)
+	changes := []auditChange{}
+	names, throttlers := c.accessibleThrottlers(session)
+	for _, name := range names {
+		if throttlers[name].KillConnection(connectionAddress) {
+			changes = append(changes, auditChange{
+				server:     name,
+				connection: connectionAddress,
+				oldValue:   "connected",
+				newValue:   "killed",
+			})
+		}
+	}
+	if len(changes) == 0 {
+		return fmt.Errorf("%w %s", ErrUnknownConnection, connectionAddress)
+	}
+	c.audit(session, cmd, changes)
+	return nil
+}
+
+// auditChange old and new values of a server or a connection setting changed by a command
+type auditChange struct {
+	server     string
+	connection string
+	setting    string
+	oldValue   interface{}
+	newValue   interface{}
+}
+
+// audit record changes made by a session's command to the audit log.
+func (c *adminCore) audit(session *adminSession, cmd *Command, changes []auditChange) {
+	if c.auditLog == nil {
+		return
+	}
+	for _, change := range changes {
+		err := c.auditLog.Record(AuditRecord{
+			Time:       time.Now().UTC(),
+			Session:    session.id,
+			User:       session.user,
+			RemoteAddr: session.remoteAddr,
+			Command:    cmd.String(),
+			Server:     change.server,
+			Connection: change.connection,
+			Setting:    change.setting,
+			OldValue:   fmt.Sprint(change.oldValue),
+			NewValue:   fmt.Sprint(change.newValue),
+		})
+		if err != nil {
+			c.logger.Println(err)
+		}
+	}
+}
+
+// reloadConfig reload configuration with the reload handler and audit the applied changes.
+func (c *adminCore) reloadConfig(session *adminSession, cmd *Command) ([]ConfigChange, error) {
+	if c.reload == nil {
+		return nil, fmt.Errorf("%w: RELOAD, configuration reload is not set up", ErrUnsupportedCommand)
+	}
+	changes, err := c.reload()
+	if err != nil {
+		return nil, err
+	}
+	auditChanges := []auditChange{}
+	for _, change := range changes {
+		auditChanges = append(auditChanges, auditChange{
+			server:   change.Server,
+			setting:  change.Setting,
+			oldValue: change.OldValue,
+			newValue: change.NewValue,
+		})
+	}
+	c.logger.Printf("Configuration was reloaded with %d changes", len(changes))
+	c.audit(session, cmd, auditChanges)
+	return changes, nil
+}
+
+// requestShutdown start a graceful shutdown with the shutdown handler.
+func (c *adminCore) requestShutdown(session *adminSession, cmd *Command) error {
+	if c.shutdown == nil {
+		return fmt.Errorf("%w: SHUTDOWN, graceful shutdown is not set up", ErrUnsupportedCommand)
+	}
+	drainTimeout := time.Duration(0)
+	if timeout := cmd.GetArg(0); timeout != "" {
+		var err error
+		drainTimeout, err = time.ParseDuration(timeout)
+		if err != nil || drainTimeout <= 0 {
+			return fmt.Errorf("%w: drain timeout must be a positive duration, e.g. 30s, got `%s`", ErrBadArgument, timeout)
+		}
+	}
+	c.logger.Printf("Shutdown was requested by %s", session.remoteAddr)
+	c.audit(session, cmd, []auditChange{{setting: "state", oldValue: "running", newValue: "shutting down"}})
+	c.shutdown(drainTimeout)
+	return nil
+}
+
+// history get up to count of the latest audit records of servers the session can operate on.
+func (c *adminCore) history(session *adminSession, count string) ([]AuditRecord, error) {
+	if c.auditLog == nil {
+		return nil, errors.New("audit log is not configured")
+	}
+	n := 0
+	if count != "" {
+		var err error
+		n, err = strconv.Atoi(count)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("%w: records count must be a positive number, got `%s`", ErrBadArgument, count)
+		}
+	}
+	records := []AuditRecord{}
+	for _, record := range c.auditLog.Records(0) {
+		if session.canAccessServer(record.Server) {
+			records = append(records, record)
+		}
+	}
+	if n > 0 && n < len(records) {
+		records = records[len(records)-n:]
+	}
+	return records, nil
+}
+
+func parseLimit(limit string) (int64, error) {
+	lim, err := strconv.ParseInt(limit, 10, 64)
+	if err != nil || lim < 0 {
+		return 0, fmt.Errorf("%w `%s`", ErrBadNumber, limit)
+	}
+	return lim, nil
+}
+
+func parseVersion(version string) (int, error) {
+	v, err := strconv.Atoi(version)
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to parse version number `%s`", ErrBadArgument, version)
+	}
+	return v, nil
+}
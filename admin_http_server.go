@@ -0,0 +1,291 @@
+package qos
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// HTTPAdminServer control plane server for TCPFileServers with an HTTP/JSON interface.
+// It runs the same commands as TCPAdminServer:
+//
+//	GET    /servers                                  - SLIST
+//	GET    /servers/{srv_name}                       - SGET
+//	PUT    /servers/{srv_name}/limit                 - SLIMIT, body: {"limit": 10}, DRYRUN with ?dry_run=true
+//	PUT    /servers/{srv_name}/throttling            - THROTTLE, body: {"enabled": true}
+//	GET    /servers/{srv_name}/connections           - CLIST
+//	GET    /servers/{srv_name}/versions              - VERSIONS
+//	GET    /servers/{srv_name}/versions/diff?from=1&to=2 - VDIFF
+//	POST   /servers/{srv_name}/rollback              - ROLLBACK, body: {"version": 1}
+//	GET    /connections/{conn_address}               - CGET
+//	PUT    /connections/{conn_address}/limit         - CLIMIT, body: {"limit": 10}, DRYRUN with ?dry_run=true
+//	DELETE /connections/{conn_address}               - KILL
+//	GET    /history?count=10                         - HISTORY
+//	POST   /reload                                   - RELOAD
+//	POST   /shutdown?drain_timeout=30s               - SHUTDOWN
+//
+// With access control clients authenticate with HTTP Basic authentication.
+type HTTPAdminServer struct {
+	core     *adminCore
+	server   *http.Server
+	listener net.Listener
+	logger   *log.Logger
+}
+
+type limitRequest struct {
+	Limit *int64 `json:"limit"`
+}
+
+type throttlingRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+type rollbackRequest struct {
+	Version *int `json:"version"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// NewHTTPAdminServer HTTPAdminServer ctor
+func NewHTTPAdminServer(throttlers map[string]*Throttler, logger *log.Logger) *HTTPAdminServer {
+	s := &HTTPAdminServer{
+		core:   newAdminCore(throttlers, logger),
+		logger: logger,
+	}
+	s.server = &http.Server{Handler: s}
+	return s
+}
+
+// SetAccessControl require clients to authenticate and restrict commands by their roles.
+// Without access control every client can run every command.
+func (s *HTTPAdminServer) SetAccessControl(accessControl *AccessControl) {
+	s.core.setAccessControl(accessControl)
+}
+
+// SetReloadHandler allow clients to reload configuration with `POST /reload`.
+func (s *HTTPAdminServer) SetReloadHandler(reload ReloadFunc) {
+	s.core.reload = reload
+}
+
+// SetShutdownHandler allow clients to gracefully stop all servers with `POST /shutdown`.
+func (s *HTTPAdminServer) SetShutdownHandler(shutdown ShutdownFunc) {
+	s.core.shutdown = shutdown
+}
+
+// AddServer start serving a server added while running.
+func (s *HTTPAdminServer) AddServer(name string, throttler *Throttler) {
+	s.core.addServer(name, throttler)
+}
+
+// RemoveServer stop serving a server removed while running.
+func (s *HTTPAdminServer) RemoveServer(name string) {
+	s.core.removeServer(name)
+}
+
+// SetAuditLog record every change made by clients to the audit log and allow to query it.
+func (s *HTTPAdminServer) SetAuditLog(auditLog *AuditLog) {
+	s.core.auditLog = auditLog
+}
+
+// ServeHTTP handle an HTTP request.
+func (s *HTTPAdminServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session := s.core.newSession(r.RemoteAddr)
+	if user, password, ok := r.BasicAuth(); ok {
+		_, err := s.core.execute(session, &Command{Action: "AUTH", Args: []string{user, password}})
+		if err != nil {
+			s.jsonError(w, err)
+			return
+		}
+	}
+
+	cmd, err := s.route(r)
+	if err != nil {
+		s.jsonError(w, err)
+		return
+	}
+	res, err := s.core.execute(session, cmd)
+	if err != nil {
+		s.jsonError(w, err)
+		return
+	}
+	if res == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.jsonRespond(w, http.StatusOK, res)
+}
+
+// Serve service
+func (s *HTTPAdminServer) Serve(protocol, address string) error {
+	s.logger.Printf("HTTP Admin Server listens on %s %s\n", protocol, address)
+	listener, err := net.Listen(protocol, address)
+	if err != nil {
+		s.logger.Println(err)
+		return err
+	}
+	s.listener = listener
+	return s.server.Serve(listener)
+}
+
+// ServeTLS serve service over HTTPS.
+func (s *HTTPAdminServer) ServeTLS(protocol, address string, config *tls.Config) error {
+	s.logger.Printf("HTTP Admin Server listens with TLS on %s %s\n", protocol, address)
+	listener, err := net.Listen(protocol, address)
+	if err != nil {
+		s.logger.Println(err)
+		return err
+	}
+	s.listener = tls.NewListener(listener, config)
+	return s.server.Serve(s.listener)
+}
+
+// Stop stop listening for incoming connections.
+func (s *HTTPAdminServer) Stop() {
+	s.logger.Println("HTTP Admin Server stops")
+	s.server.Close()
+}
+
+// Shutdown stop listening for incoming connections and wait for requests in progress until ctx is done.
+func (s *HTTPAdminServer) Shutdown(ctx context.Context) error {
+	s.logger.Println("HTTP Admin Server shuts down")
+	return s.server.Shutdown(ctx)
+}
+
+// route translate an HTTP request into an admin command.
+func (s *HTTPAdminServer) route(r *http.Request) (*Command, error) {
+	segments := []string{}
+	for _, segment := range strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/") {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, errNotFound
+		}
+		segments = append(segments, unescaped)
+	}
+
+	route := r.Method + " " + segments[0]
+	if len(segments) > 1 {
+		route += " {}"
+	}
+	if len(segments) > 2 {
+		route += " " + strings.Join(segments[2:], " ")
+	}
+
+	switch route {
+	case "GET servers":
+		return &Command{Action: "SLIST", Args: []string{}}, nil
+	case "GET servers {}":
+		return &Command{Action: "SGET", Args: []string{segments[1]}}, nil
+	case "PUT servers {} limit":
+		limit, err := decodeLimit(r)
+		if err != nil {
+			return nil, err
+		}
+		return dryRunnable(r, &Command{Action: "SLIMIT", Args: []string{segments[1], limit}}), nil
+	case "PUT servers {} throttling":
+		req := &throttlingRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.Enabled == nil {
+			return nil, errBadRequestBody
+		}
+		enabled := "no"
+		if *req.Enabled {
+			enabled = "yes"
+		}
+		return &Command{Action: "THROTTLE", Args: []string{segments[1], enabled}}, nil
+	case "GET servers {} connections":
+		return &Command{Action: "CLIST", Args: []string{segments[1]}}, nil
+	case "GET connections {}":
+		return &Command{Action: "CGET", Args: []string{segments[1]}}, nil
+	case "PUT connections {} limit":
+		limit, err := decodeLimit(r)
+		if err != nil {
+			return nil, err
+		}
+		return dryRunnable(r, &Command{Action: "CLIMIT", Args: []string{segments[1], limit}}), nil
+	case "DELETE connections {}":
+		return &Command{Action: "KILL", Args: []string{segments[1]}}, nil
+	case "GET servers {} versions":
+		return &Command{Action: "VERSIONS", Args: []string{segments[1]}}, nil
+	case "GET servers {} versions diff":
+		q := r.URL.Query()
+		return &Command{Action: "VDIFF", Args: []string{segments[1], q.Get("from"), q.Get("to")}}, nil
+	case "POST servers {} rollback":
+		req := &rollbackRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.Version == nil {
+			return nil, errBadRequestBody
+		}
+		return &Command{Action: "ROLLBACK", Args: []string{segments[1], strconv.Itoa(*req.Version)}}, nil
+	case "POST reload":
+		return &Command{Action: "RELOAD", Args: []string{}}, nil
+	case "POST shutdown":
+		return &Command{Action: "SHUTDOWN", Args: []string{r.URL.Query().Get("drain_timeout")}}, nil
+	case "GET history":
+		return &Command{Action: "HISTORY", Args: []string{r.URL.Query().Get("count")}}, nil
+	}
+	return nil, errNotFound
+}
+
+var (
+	errNotFound       = errors.New("not found")
+	errBadRequestBody = errors.New("malformed request body")
+)
+
+// dryRunnable wrap a command into DRYRUN if the request asks for it with `dry_run=true` query parameter.
+func dryRunnable(r *http.Request, cmd *Command) *Command {
+	if r.URL.Query().Get("dry_run") != "true" {
+		return cmd
+	}
+	return &Command{Action: "DRYRUN", Args: append([]string{cmd.Action}, cmd.Args...)}
+}
+
+func decodeLimit(r *http.Request) (string, error) {
+	req := &limitRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.Limit == nil {
+		return "", errBadRequestBody
+	}
+	return strconv.FormatInt(*req.Limit, 10), nil
+}
+
+func (s *HTTPAdminServer) jsonRespond(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		s.logger.Println(err)
+	}
+}
+
+func (s *HTTPAdminServer) jsonError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errNotFound), errors.Is(err, ErrUnknownServer), errors.Is(err, ErrUnknownConnection),
+		errors.Is(err, ErrUnknownVersion):
+		status = http.StatusNotFound
+	case errors.Is(err, errBadRequestBody), errors.Is(err, ErrBadNumber), errors.Is(err, ErrBadArgument),
+		errors.Is(err, ErrInvalidConfig):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrAuthRequired), errors.Is(err, ErrBadCredentials):
+		status = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", `Basic realm="qos"`)
+	case errors.Is(err, ErrPermissionDenied):
+		status = http.StatusForbidden
+	case errors.Is(err, ErrUnsupportedCommand):
+		status = http.StatusNotImplemented
+	}
+	code := errorCode(err)
+	switch {
+	case errors.Is(err, errNotFound):
+		code = "not_found"
+	case errors.Is(err, errBadRequestBody):
+		code = "bad_request"
+	}
+	s.jsonRespond(w, status, &errorResponse{Error: err.Error(), Code: code})
+}
@@ -0,0 +1,189 @@
+package qos_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kolotaev/qos"
+)
+
+func httpAdminRequest(s *qos.HTTPAdminServer, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func TestHTTPAdminServer_Servers(t *testing.T) {
+	srv1 := qos.NewThrottler(30, true)
+	srv1.RegisterConnection("127.0.0.1:5000")
+	s := qos.NewHTTPAdminServer(map[string]*qos.Throttler{"srv1": srv1, "srv2": qos.NewThrottler(10, false)},
+		log.New(ioutil.Discard, "", 0))
+
+	w := httpAdminRequest(s, "GET", "/servers", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `[
+		{"name": "srv1", "limit": 30, "enabled": true, "connections": 1},
+		{"name": "srv2", "limit": 10, "enabled": false, "connections": 0}
+	]`, w.Body.String())
+
+	w = httpAdminRequest(s, "GET", "/servers/srv2", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"name": "srv2", "limit": 10, "enabled": false, "connections": 0}`, w.Body.String())
+
+	w = httpAdminRequest(s, "PUT", "/servers/srv1/limit", `{"limit": 50}`)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, int64(50), srv1.GetBandwidthLimit())
+
+	w = httpAdminRequest(s, "PUT", "/servers/srv1/throttling", `{"enabled": false}`)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.False(t, srv1.IsEnabled())
+
+	w = httpAdminRequest(s, "GET", "/servers/srv1/connections", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"key": "127.0.0.1:5000", "limit": 50, "individual": false}]`, w.Body.String())
+}
+
+func TestHTTPAdminServer_Connections(t *testing.T) {
+	srv1 := qos.NewThrottler(30, true)
+	require.NoError(t, srv1.Listen("tcp4", "127.0.0.1:0"))
+	defer srv1.Close()
+	client, err := net.Dial("tcp4", srv1.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	conn, err := srv1.Accept()
+	require.NoError(t, err)
+	key := conn.RemoteAddr().String()
+
+	s := qos.NewHTTPAdminServer(map[string]*qos.Throttler{"srv1": srv1}, log.New(ioutil.Discard, "", 0))
+
+	w := httpAdminRequest(s, "PUT", "/connections/"+key+"/limit", `{"limit": 12}`)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = httpAdminRequest(s, "GET", "/connections/"+key, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"server": "srv1", "key": "`+key+`", "limit": 12, "individual": true}]`, w.Body.String())
+
+	w = httpAdminRequest(s, "DELETE", "/connections/"+key, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	_, err = client.Read(make([]byte, 1))
+	assert.Error(t, err)
+
+	w = httpAdminRequest(s, "DELETE", "/connections/"+key, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.JSONEq(t, `{"error": "unknown connection `+key+`", "code": "unknown_connection"}`, w.Body.String())
+}
+
+func TestHTTPAdminServer_Errors(t *testing.T) {
+	s := qos.NewHTTPAdminServer(map[string]*qos.Throttler{"srv1": qos.NewThrottler(30, true)},
+		log.New(ioutil.Discard, "", 0))
+
+	cases := []struct {
+		method, path, body string
+		status             int
+		error, code        string
+	}{
+		{"GET", "/", "", http.StatusNotFound, "not found", "not_found"},
+		{"POST", "/servers", "", http.StatusNotFound, "not found", "not_found"},
+		{"GET", "/servers/srv2", "", http.StatusNotFound, "unknown server srv2", "unknown_server"},
+		{"PUT", "/servers/srv1/limit", `{"limit": -1}`, http.StatusBadRequest, "failed to parse limit number `-1`", "bad_number"},
+		{"PUT", "/servers/srv1/limit", `{"limit": "a lot"}`, http.StatusBadRequest, "malformed request body", "bad_request"},
+		{"PUT", "/servers/srv1/throttling", `{}`, http.StatusBadRequest, "malformed request body", "bad_request"},
+		{"GET", "/connections/127.0.0.1:1", "", http.StatusNotFound, "unknown connection 127.0.0.1:1", "unknown_connection"},
+	}
+	for _, tc := range cases {
+		w := httpAdminRequest(s, tc.method, tc.path, tc.body)
+		assert.Equal(t, tc.status, w.Code, tc.method+" "+tc.path)
+		assert.JSONEq(t, `{"error": "`+tc.error+`", "code": "`+tc.code+`"}`, w.Body.String(), tc.method+" "+tc.path)
+	}
+}
+
+func TestHTTPAdminServer_AccessControl(t *testing.T) {
+	s := qos.NewHTTPAdminServer(map[string]*qos.Throttler{"srv1": qos.NewThrottler(30, true)},
+		log.New(ioutil.Discard, "", 0))
+	ac := qos.NewAccessControl()
+	ac.AddUser("viewer", "pass", qos.NewViewerRole())
+	s.SetAccessControl(ac)
+
+	w := httpAdminRequest(s, "GET", "/servers", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, `Basic realm="qos"`, w.Header().Get("WWW-Authenticate"))
+
+	r := httptest.NewRequest("GET", "/servers", nil)
+	r.SetBasicAuth("viewer", "wrong")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	r = httptest.NewRequest("GET", "/servers", nil)
+	r.SetBasicAuth("viewer", "pass")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	r = httptest.NewRequest("PUT", "/servers/srv1/limit", strings.NewReader(`{"limit": 1}`))
+	r.SetBasicAuth("viewer", "pass")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.JSONEq(t, `{"error": "permission denied: role `+"`viewer`"+` can't run SLIMIT", "code": "permission_denied"}`,
+		w.Body.String())
+}
+
+func TestHTTPAdminServer_DryRun(t *testing.T) {
+	srv1 := qos.NewThrottler(30, true)
+	srv1.RegisterConnection("A")
+	s := qos.NewHTTPAdminServer(map[string]*qos.Throttler{"srv1": srv1}, log.New(ioutil.Discard, "", 0))
+
+	w := httpAdminRequest(s, "PUT", "/connections/B/limit?dry_run=true", `{"limit": 10}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[
+		{"server": "srv1", "key": "A", "old_limit": 30, "new_limit": 20},
+		{"server": "srv1", "key": "B", "old_limit": 0, "new_limit": 10}
+	]`, w.Body.String())
+	assert.Equal(t, []qos.ConnectionInfo{{Key: "A", Limit: 30}}, srv1.Connections())
+}
+
+func TestHTTPAdminServer_Warnings(t *testing.T) {
+	srv1 := qos.NewThrottler(30, true)
+	s := qos.NewHTTPAdminServer(map[string]*qos.Throttler{"srv1": srv1}, log.New(ioutil.Discard, "", 0))
+
+	w := httpAdminRequest(s, "PUT", "/connections/A/limit", `{"limit": 50}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"warnings": [
+		"srv1: limit of connection A was clamped to 30 instead of 50: not enough free bandwidth on the server"
+	]}`, w.Body.String())
+}
+
+func TestHTTPAdminServer_Reload(t *testing.T) {
+	s := qos.NewHTTPAdminServer(map[string]*qos.Throttler{"srv1": qos.NewThrottler(30, true)}, log.New(ioutil.Discard, "", 0))
+
+	w := httpAdminRequest(s, "POST", "/reload", "")
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"unsupported_command"`)
+
+	s.SetReloadHandler(func() ([]qos.ConfigChange, error) {
+		return nil, fmt.Errorf("%w: servers[0] (srv1): limit must be positive, got 0", qos.ErrInvalidConfig)
+	})
+	w = httpAdminRequest(s, "POST", "/reload", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":"invalid_config"`)
+
+	s.SetReloadHandler(func() ([]qos.ConfigChange, error) {
+		return []qos.ConfigChange{{Server: "srv1", Setting: "enabled", OldValue: "true", NewValue: "false"}}, nil
+	})
+	w = httpAdminRequest(s, "POST", "/reload", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[{"server": "srv1", "setting": "enabled", "old_value": "true", "new_value": "false"}]`,
+		w.Body.String())
+}
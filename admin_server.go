@@ -2,89 +2,113 @@ package qos
 
 import (
 	"bufio"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
-	"strconv"
+	"strings"
+	"sync"
 )
 
 // TCPAdminServer control plane server for TCPFileServers
 type TCPAdminServer struct {
-	throttlers map[string]*Throttler
-	listener   net.Listener
-	logger     *log.Logger
+	core     *adminCore
+	listener net.Listener
+	stopped  bool
+	logger   *log.Logger
+	mu       *sync.Mutex // guards listener and stopped
 }
 
 // NewTCPAdminServer TCPAdminServer ctor
 func NewTCPAdminServer(throttlers map[string]*Throttler, logger *log.Logger) *TCPAdminServer {
 	return &TCPAdminServer{
-		throttlers: throttlers,
-		logger:     logger,
+		core:   newAdminCore(throttlers, logger),
+		logger: logger,
+		mu:     new(sync.Mutex),
 	}
 }
 
-// Handle connection
+// SetAccessControl require clients to authenticate and restrict commands by their roles.
+// Without access control every client can run every command.
+func (s *TCPAdminServer) SetAccessControl(accessControl *AccessControl) {
+	s.core.setAccessControl(accessControl)
+}
+
+// SetReloadHandler allow clients to reload configuration with RELOAD command.
+func (s *TCPAdminServer) SetReloadHandler(reload ReloadFunc) {
+	s.core.reload = reload
+}
+
+// SetShutdownHandler allow clients to gracefully stop all servers with SHUTDOWN command.
+func (s *TCPAdminServer) SetShutdownHandler(shutdown ShutdownFunc) {
+	s.core.shutdown = shutdown
+}
+
+// AddServer start serving a server added while running.
+func (s *TCPAdminServer) AddServer(name string, throttler *Throttler) {
+	s.core.addServer(name, throttler)
+}
+
+// RemoveServer stop serving a server removed while running.
+func (s *TCPAdminServer) RemoveServer(name string) {
+	s.core.removeServer(name)
+}
+
+// SetAuditLog record every change made by clients to the audit log and allow to query it with HISTORY command.
+func (s *TCPAdminServer) SetAuditLog(auditLog *AuditLog) {
+	s.core.auditLog = auditLog
+}
+
+// Handle connection.
+// Clients can send plain text lines or RESP2 arrays, e.g. with redis-cli.
+// Once a RESP2 array is received, the rest of the session is replied in RESP2 format.
+// Responses format can be also switched explicitly with PROTO command.
 func (s *TCPAdminServer) Handle(conn net.Conn) {
 	defer conn.Close()
+	session := s.core.newSession(conn.RemoteAddr().String())
+	reader := bufio.NewReader(conn)
+	var responder adminResponder = textResponder{}
 	for {
-		netData, err := bufio.NewReader(conn).ReadString('\n')
+		inputs, err := readAdminInput(reader, &responder)
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
 			s.logger.Println(fmt.Errorf("failed to read net data: %s", err))
-			continue
+			if errors.Is(err, ErrRESPProtocol) {
+				responder.respondError(conn, err)
+			}
+			break
 		}
 
-		cmd, err := ParseInput(string(netData))
+		cmd, err := ParseArgs(inputs)
 		if err != nil {
-			errorRespond(conn, err)
+			responder.respondError(conn, err)
 			continue
 		}
 
 		if cmd.IsHalt {
-			textRespond(conn, "BYE!")
+			responder.respondText(conn, "BYE!")
 			break
 		}
-		switch cmd.Action {
-		case "THROTTLE":
-			err := s.enableThrottling(cmd.GetArg(0), cmd.GetArg(1))
-			if err != nil {
-				s.logger.Println(err)
-				errorRespond(conn, err)
-			} else {
-				s.logger.Printf("Throttling for server enabled? `%s`", cmd.GetArg(1))
-				okRespond(conn)
-			}
-		case "SLIMIT":
-			err := s.setServerLimit(cmd.GetArg(0), cmd.GetArg(1))
-			if err != nil {
-				s.logger.Println(err)
-				errorRespond(conn, err)
-			} else {
-				s.logger.Printf("Limit `%s` for server `%s` was set", cmd.GetArg(1), cmd.GetArg(0))
-				okRespond(conn)
-			}
-		case "CLIMIT":
-			err := s.setConnectionLimit(cmd.GetArg(0), cmd.GetArg(1))
-			if err != nil {
-				s.logger.Println(err)
-				errorRespond(conn, err)
-			} else {
-				s.logger.Printf("Limit `%s` for connection `%s` was set", cmd.GetArg(1), cmd.GetArg(0))
-				okRespond(conn)
-			}
-		case "CLIST":
-			err := s.setConnectionLimit(cmd.GetArg(0), cmd.GetArg(1))
+		if cmd.Action == "PROTO" {
+			r, err := newAdminResponder(cmd.GetArg(0))
 			if err != nil {
-				s.logger.Println(err)
-				errorRespond(conn, err)
-			} else {
-				s.logger.Printf("Limit `%s` for connection `%s` was set", cmd.GetArg(1), cmd.GetArg(0))
-				okRespond(conn)
+				responder.respondError(conn, err)
+				continue
 			}
+			responder = r
+			responder.respondResult(conn, nil)
+			continue
+		}
+		res, err := s.core.execute(session, cmd)
+		if err != nil {
+			responder.respondError(conn, err)
+			continue
 		}
+		responder.respondResult(conn, res)
 	}
 }
 
@@ -96,8 +120,31 @@ func (s *TCPAdminServer) Serve(protocol, address string) error {
 		s.logger.Println(err)
 		return err
 	}
+	return s.acceptLoop(listener)
+}
+
+// ServeTLS serve service over TLS.
+// Use NewTLSConfig with a client CA file to require and verify client certificates.
+func (s *TCPAdminServer) ServeTLS(protocol, address string, config *tls.Config) error {
+	s.logger.Printf("TCP Admin Server listens with TLS on %s %s\n", protocol, address)
+	listener, err := net.Listen(protocol, address)
+	if err != nil {
+		s.logger.Println(err)
+		return err
+	}
+	return s.acceptLoop(tls.NewListener(listener, config))
+}
+
+func (s *TCPAdminServer) acceptLoop(listener net.Listener) error {
 	defer listener.Close()
+	s.mu.Lock()
 	s.listener = listener
+	stopped := s.stopped
+	s.mu.Unlock()
+	// Stop could have happened before listening was started
+	if stopped {
+		return ErrShuttingDown
+	}
 
 	for {
 		c, err := listener.Accept()
@@ -110,48 +157,40 @@ func (s *TCPAdminServer) Serve(protocol, address string) error {
 	}
 }
 
-// Stop stop listening for incoming connections.
-func (s *TCPAdminServer) Stop() {
-	s.logger.Println("TCP File Server stops")
-	if s.listener != nil {
-		s.listener.Close()
-	}
-}
-
-func (s *TCPAdminServer) enableThrottling(serverName, doEnable string) error {
-	if _, ok := s.throttlers[serverName]; !ok {
-		return fmt.Errorf("unknown server %s", serverName)
-	}
-
-	throttler := s.throttlers[serverName]
-	if doEnable == "yes" {
-		throttler.Enable()
-	} else {
-		throttler.Disable()
+// readAdminInput read either a text line or a RESP2 array and split it into command tokens.
+// Responder is switched to RESP2 when a RESP2 array is read.
+func readAdminInput(reader *bufio.Reader, responder *adminResponder) ([]string, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, err
 	}
-	return nil
-}
-
-func (s *TCPAdminServer) setServerLimit(serverName, limit string) error {
-	if _, ok := s.throttlers[serverName]; !ok {
-		return fmt.Errorf("unknown server %s", serverName)
+	if first[0] == '*' {
+		*responder = respResponder{}
+		inputs, err := readRESPArray(reader)
+		if err != nil {
+			return nil, err
+		}
+		// Redis commands are case insensitive
+		if len(inputs) > 0 {
+			inputs[0] = strings.ToUpper(inputs[0])
+		}
+		return inputs, nil
 	}
 
-	lim, err := strconv.ParseInt(limit, 10, 64)
+	netData, err := reader.ReadString('\n')
 	if err != nil {
-		return fmt.Errorf("failed to parse limit number `%s`", limit)
+		return nil, err
 	}
-	s.throttlers[serverName].SetBandwidthLimit(lim)
-	return nil
+	return strings.Split(strings.TrimSpace(netData), " "), nil
 }
 
-func (s *TCPAdminServer) setConnectionLimit(connectionAddress, limit string) error {
-	lim, err := strconv.ParseInt(limit, 10, 64)
-	if err != nil {
-		return fmt.Errorf("failed to parse limit number `%s`", limit)
-	}
-	for _, throttler := range s.throttlers {
-		throttler.SetBandwidthLimitForConnection(lim, connectionAddress)
+// Stop stop listening for incoming connections.
+func (s *TCPAdminServer) Stop() {
+	s.logger.Println("TCP Admin Server stops")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = true
+	if s.listener != nil {
+		s.listener.Close()
 	}
-	return nil
 }
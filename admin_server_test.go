@@ -0,0 +1,309 @@
+package qos_test
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kolotaev/qos"
+)
+
+// testClient a client of an admin or file server connection, replies are read line by line
+type testClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newAdminTestClient(s *qos.TCPAdminServer) *testClient {
+	client, server := net.Pipe()
+	go s.Handle(server)
+	return &testClient{conn: client, reader: bufio.NewReader(client)}
+}
+
+func (c *testClient) send(t *testing.T, command string) string {
+	return c.sendRaw(t, command+"\n")
+}
+
+func (c *testClient) sendRaw(t *testing.T, data string) string {
+	_, err := c.conn.Write([]byte(data))
+	assert.NoError(t, err)
+	return c.read(t)
+}
+
+func (c *testClient) read(t *testing.T) string {
+	res, err := c.reader.ReadString('\n')
+	assert.NoError(t, err)
+	return res
+}
+
+func newTestAdminServer(throttlers map[string]*qos.Throttler) *qos.TCPAdminServer {
+	return qos.NewTCPAdminServer(throttlers, log.New(ioutil.Discard, "", 0))
+}
+
+func TestTCPAdminServer_ListConnections(t *testing.T) {
+	th := qos.NewThrottler(30, true)
+	th.RegisterConnection("A")
+	th.SetBandwidthLimitForConnection(10, "B")
+	s := newTestAdminServer(map[string]*qos.Throttler{"srv1": th})
+	c := newAdminTestClient(s)
+	defer c.conn.Close()
+
+	assert.Equal(t, "A limit=20 individual=false\n", c.send(t, "CLIST srv1"))
+	res, _ := c.reader.ReadString('\n')
+	assert.Equal(t, "B limit=10 individual=true\n", res)
+	res, _ = c.reader.ReadString('\n')
+	assert.Equal(t, "OK\n", res)
+
+	assert.Equal(t, "Error: unknown server srv2\n", c.send(t, "CLIST srv2"))
+}
+
+func TestTCPAdminServer_AuthenticationRequired(t *testing.T) {
+	s := newTestAdminServer(map[string]*qos.Throttler{"srv1": qos.NewThrottler(30, true)})
+	ac := qos.NewAccessControl()
+	ac.AddUser("root", "pass", qos.NewAdminRole())
+	s.SetAccessControl(ac)
+	c := newAdminTestClient(s)
+	defer c.conn.Close()
+
+	assert.Equal(t, "Error: authentication required\n", c.send(t, "SLIMIT srv1 10"))
+	assert.Equal(t, "Error: invalid user name or password\n", c.send(t, "AUTH root wrong"))
+	assert.Equal(t, "OK\n", c.send(t, "AUTH root pass"))
+	assert.Equal(t, "OK\n", c.send(t, "SLIMIT srv1 10"))
+}
+
+func TestTCPAdminServer_RoleBasedAccess(t *testing.T) {
+	srv1 := qos.NewThrottler(30, true)
+	srv2 := qos.NewThrottler(30, true)
+	s := newTestAdminServer(map[string]*qos.Throttler{"srv1": srv1, "srv2": srv2})
+	ac := qos.NewAccessControl()
+	ac.AddUser("support", "pass", qos.NewRole("support", []string{"CLIST", "CLIMIT"}, "srv1"))
+	s.SetAccessControl(ac)
+	c := newAdminTestClient(s)
+	defer c.conn.Close()
+
+	assert.Equal(t, "OK\n", c.send(t, "AUTH support pass"))
+	assert.Equal(t, "OK\n", c.send(t, "CLIST srv1"))
+	assert.Equal(t, "Error: permission denied: role `support` can't access server srv2\n", c.send(t, "CLIST srv2"))
+	assert.Equal(t, "Error: permission denied: role `support` can't run SLIMIT\n", c.send(t, "SLIMIT srv1 10"))
+	assert.Equal(t, "Error: permission denied: role `support` can't run THROTTLE\n", c.send(t, "THROTTLE srv2 no"))
+	ac.AddUser("srv1admin", "pass", qos.NewAdminRole("srv1"))
+	assert.Equal(t, "OK\n", c.send(t, "AUTH srv1admin pass"))
+	assert.Equal(t, "Error: permission denied: role `admin` is restricted to some servers and can't run RELOAD on all servers\n",
+		c.send(t, "RELOAD"))
+	assert.Equal(t, "Error: permission denied: role `admin` is restricted to some servers and can't run SHUTDOWN on all servers\n",
+		c.send(t, "SHUTDOWN"))
+	assert.Equal(t, "OK\n", c.send(t, "AUTH support pass"))
+
+	// Connection limit is applied only to the allowed server
+	assert.Equal(t, "OK\n", c.send(t, "CLIMIT 127.0.0.1:5000 10"))
+	assert.Equal(t, int64(10), srv1.GetBandwidthLimitForConnection("127.0.0.1:5000"))
+	assert.Equal(t, []qos.ConnectionInfo{}, srv2.Connections())
+}
+
+func TestTCPAdminServer_Servers(t *testing.T) {
+	s := newTestAdminServer(map[string]*qos.Throttler{"srv1": qos.NewThrottler(30, true), "srv2": qos.NewThrottler(5, false)})
+	c := newAdminTestClient(s)
+	defer c.conn.Close()
+
+	assert.Equal(t, "srv1 limit=30 enabled=true connections=0\n", c.send(t, "SLIST"))
+	res, _ := c.reader.ReadString('\n')
+	assert.Equal(t, "srv2 limit=5 enabled=false connections=0\n", res)
+	res, _ = c.reader.ReadString('\n')
+	assert.Equal(t, "OK\n", res)
+
+	assert.Equal(t, "srv2 limit=5 enabled=false connections=0\n", c.send(t, "SGET srv2"))
+	res, _ = c.reader.ReadString('\n')
+	assert.Equal(t, "OK\n", res)
+	assert.Equal(t, "Error: failed to parse limit number `-3`\n", c.send(t, "SLIMIT srv1 -3"))
+	assert.Equal(t, "Error: command is not supported by admin server: FILE\n", c.send(t, "FILE a.txt"))
+}
+
+func TestTCPAdminServer_Connections(t *testing.T) {
+	th := qos.NewThrottler(30, true)
+	s := newTestAdminServer(map[string]*qos.Throttler{"srv1": th})
+	c := newAdminTestClient(s)
+	defer c.conn.Close()
+
+	assert.Equal(t, "OK\n", c.send(t, "CLIMIT A 10"))
+	assert.Equal(t, "srv1 A limit=10 individual=true\n", c.send(t, "CGET A"))
+	res, _ := c.reader.ReadString('\n')
+	assert.Equal(t, "OK\n", res)
+	assert.Equal(t, "Error: unknown connection B\n", c.send(t, "CGET B"))
+	assert.Equal(t, "Error: unknown connection A\n", c.send(t, "KILL A"))
+}
+
+func TestTCPAdminServer_RESP(t *testing.T) {
+	th := qos.NewThrottler(30, true)
+	s := newTestAdminServer(map[string]*qos.Throttler{"srv1": th})
+	c := newAdminTestClient(s)
+	defer c.conn.Close()
+
+	assert.Equal(t, "+OK\r\n", c.sendRaw(t, "*3\r\n$6\r\nslimit\r\n$4\r\nsrv1\r\n$2\r\n40\r\n"))
+	assert.Equal(t, int64(40), th.GetBandwidthLimit())
+
+	assert.Equal(t, "-ERR unknown server srv2\r\n", c.sendRaw(t, "*2\r\n$4\r\nSGET\r\n$4\r\nsrv2\r\n"))
+
+	// Inline commands are replied in RESP too once the session switched to it
+	assert.Equal(t, "*8\r\n", c.send(t, "SGET srv1"))
+	expected := "$4\r\nname\r\n$4\r\nsrv1\r\n$5\r\nlimit\r\n:40\r\n$7\r\nenabled\r\n:1\r\n$11\r\nconnections\r\n:0\r\n"
+	res := make([]byte, len(expected))
+	_, err := io.ReadFull(c.reader, res)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(res))
+
+	assert.Equal(t, "-ERR protocol error: invalid length `foo`\r\n", c.sendRaw(t, "*foo\r\n"))
+	_, err = c.reader.ReadString('\n')
+	assert.Error(t, err, "connection is closed after a protocol error")
+}
+
+func TestTCPAdminServer_JSONProtocol(t *testing.T) {
+	srv1 := qos.NewThrottler(30, true)
+	s := newTestAdminServer(map[string]*qos.Throttler{"srv1": srv1, "srv2": qos.NewThrottler(30, true)})
+	ac := qos.NewAccessControl()
+	ac.AddUser("support", "pass", qos.NewRole("support", []string{"SGET", "SLIMIT"}, "srv1"))
+	s.SetAccessControl(ac)
+	c := newAdminTestClient(s)
+	defer c.conn.Close()
+
+	assert.Equal(t, "Error: bad argument: unknown protocol `xml`\n", c.send(t, "PROTO xml"))
+	assert.Equal(t, `{"status":"ok"}`+"\n", c.send(t, "PROTO json"))
+
+	cases := []struct {
+		command  string
+		response string
+	}{
+		{"SGET srv1", `{"status":"error","code":"auth_required","message":"authentication required"}`},
+		{"AUTH support foo", `{"status":"error","code":"bad_credentials","message":"invalid user name or password"}`},
+		{"AUTH support pass", `{"status":"ok"}`},
+		{"SGET srv1", `{"status":"ok","data":{"name":"srv1","limit":30,"enabled":true,"connections":0}}`},
+		{"SGET srv3", `{"status":"error","code":"permission_denied","message":"permission denied: role ` +
+			"`support`" + ` can't access server srv3"}`},
+		{"SLIMIT srv1 ten", `{"status":"error","code":"bad_number","message":"failed to parse limit number ` + "`ten`" + `"}`},
+		{"CLIST srv1", `{"status":"error","code":"permission_denied","message":"permission denied: role ` +
+			"`support`" + ` can't run CLIST"}`},
+		{"SLIMIT srv1", `{"status":"error","code":"bad_arguments_count","message":"command arguments count mismatch. Got: 1. Want: 2"}`},
+		{"foo", `{"status":"error","code":"unknown_command","message":"received unknown command: ` + "`foo`" + `"}`},
+	}
+	for _, tc := range cases {
+		assert.Equal(t, tc.response+"\n", c.send(t, tc.command), tc.command)
+	}
+
+	assert.Equal(t, "OK\n", c.send(t, "PROTO text"))
+}
+
+func TestTCPAdminServer_Versions(t *testing.T) {
+	th := qos.NewThrottler(30, true)
+	s := newTestAdminServer(map[string]*qos.Throttler{"srv1": th})
+	c := newAdminTestClient(s)
+	defer c.conn.Close()
+
+	assert.Equal(t, "OK\n", c.send(t, "CLIMIT A 10"))
+	assert.Equal(t, "Warning: srv1: individual limits exceed the server limit and were rescaled: A 10 -> 8\n",
+		c.send(t, "SLIMIT srv1 8"))
+	res, _ := c.reader.ReadString('\n')
+	assert.Equal(t, "OK\n", res)
+	assert.Equal(t, "limit: 30 -> 8\n", c.send(t, "VDIFF srv1 1 3"))
+	res, _ = c.reader.ReadString('\n')
+	assert.Equal(t, "connection A: - -> 8\n", res)
+	res, _ = c.reader.ReadString('\n')
+	assert.Equal(t, "OK\n", res)
+
+	assert.Equal(t, "OK\n", c.send(t, "ROLLBACK srv1 2"))
+	assert.Equal(t, int64(30), th.GetBandwidthLimit())
+	assert.Equal(t, int64(10), th.GetBandwidthLimitForConnection("A"))
+
+	assert.Equal(t, "Error: unknown configuration version 9\n", c.send(t, "ROLLBACK srv1 9"))
+	assert.Equal(t, "Error: bad argument: failed to parse version number `x`\n", c.send(t, "VDIFF srv1 x 2"))
+}
+
+func TestTCPAdminServer_DryRun(t *testing.T) {
+	th := qos.NewThrottler(30, true)
+	th.SetBandwidthLimitForConnection(20, "A")
+	th.RegisterConnection("B")
+	s := newTestAdminServer(map[string]*qos.Throttler{"srv1": th})
+	c := newAdminTestClient(s)
+	defer c.conn.Close()
+
+	assert.Equal(t, "srv1 A limit=20 -> 10\n", c.send(t, "DRYRUN SLIMIT srv1 10"))
+	res, _ := c.reader.ReadString('\n')
+	assert.Equal(t, "srv1 B limit=10 -> 0\n", res)
+	res, _ = c.reader.ReadString('\n')
+	assert.Equal(t, "OK\n", res)
+	assert.Equal(t, int64(30), th.GetBandwidthLimit())
+
+	assert.Equal(t, "Error: bad argument: only SLIMIT and CLIMIT can be dry-run, got KILL\n", c.send(t, "DRYRUN KILL A B"))
+	assert.Equal(t, "Error: command arguments count mismatch. Got: 2. Want: 3\n", c.send(t, "DRYRUN CLIMIT A"))
+}
+
+func TestTCPAdminServer_ClampedLimitWarnings(t *testing.T) {
+	th := qos.NewThrottler(30, true)
+	th.SetBandwidthLimitForConnection(20, "A")
+	s := newTestAdminServer(map[string]*qos.Throttler{"srv1": th})
+	c := newAdminTestClient(s)
+	defer c.conn.Close()
+
+	assert.Equal(t, "Warning: srv1: limit of connection B was clamped to 10 instead of 50: "+
+		"not enough free bandwidth on the server\n", c.send(t, "CLIMIT B 50"))
+	res, _ := c.reader.ReadString('\n')
+	assert.Equal(t, "OK\n", res)
+	assert.Equal(t, int64(10), th.GetBandwidthLimitForConnection("B"))
+
+	assert.Equal(t, `{"status":"ok"}`+"\n", c.send(t, "PROTO json"))
+	assert.Equal(t, `{"status":"ok","data":{"warnings":["srv1: individual limits exceed the server limit `+
+		`and were rescaled: A 20 -> 5, B 10 -> 5"]}}`+"\n", c.send(t, "SLIMIT srv1 10"))
+}
+
+func TestTCPAdminServer_Reload(t *testing.T) {
+	srv1 := qos.NewThrottler(30, true)
+	s := newTestAdminServer(map[string]*qos.Throttler{"srv1": srv1})
+	c := newAdminTestClient(s)
+	defer c.conn.Close()
+
+	assert.Equal(t, "Error: command is not supported by admin server: RELOAD, configuration reload is not set up\n", c.send(t, "RELOAD"))
+
+	s.SetReloadHandler(func() ([]qos.ConfigChange, error) {
+		srv1.SetBandwidthLimit(20)
+		s.AddServer("srv2", qos.NewThrottler(10, true))
+		return []qos.ConfigChange{
+			{Server: "srv1", Setting: "limit", OldValue: "30", NewValue: "20"},
+			{Server: "srv2", Setting: "server", NewValue: "added"},
+		}, nil
+	})
+	assert.Equal(t, "srv1 limit: 30 -> 20\n", c.send(t, "RELOAD"))
+	res, _ := c.reader.ReadString('\n')
+	assert.Equal(t, "srv2 server: - -> added\n", res)
+	res, _ = c.reader.ReadString('\n')
+	assert.Equal(t, "OK\n", res)
+	assert.Equal(t, "srv2 limit=10 enabled=true connections=0\n", c.send(t, "SGET srv2"))
+	res, _ = c.reader.ReadString('\n')
+	assert.Equal(t, "OK\n", res)
+
+	s.RemoveServer("srv2")
+	assert.Equal(t, "Error: unknown server srv2\n", c.send(t, "SGET srv2"))
+}
+
+func TestTCPAdminServer_Shutdown(t *testing.T) {
+	s := newTestAdminServer(map[string]*qos.Throttler{"srv1": qos.NewThrottler(30, true)})
+	c := newAdminTestClient(s)
+	defer c.conn.Close()
+
+	assert.Equal(t, "Error: command is not supported by admin server: SHUTDOWN, graceful shutdown is not set up\n",
+		c.send(t, "SHUTDOWN"))
+
+	requested := make(chan time.Duration, 1)
+	s.SetShutdownHandler(func(drainTimeout time.Duration) {
+		requested <- drainTimeout
+	})
+	assert.Equal(t, "Error: bad argument: drain timeout must be a positive duration, e.g. 30s, got `10`\n",
+		c.send(t, "SHUTDOWN 10"))
+	assert.Equal(t, "OK\n", c.send(t, "SHUTDOWN 10s"))
+	assert.Equal(t, 10*time.Second, <-requested)
+	assert.Equal(t, "OK\n", c.send(t, "SHUTDOWN"))
+	assert.Equal(t, time.Duration(0), <-requested)
+}
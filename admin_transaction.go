@@ -0,0 +1,273 @@
+package qos
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Max number of commands in a transaction
+const maxTransactionCommands = 100
+
+// Transaction errors
+var (
+	ErrTransaction        = errors.New("transaction error")
+	ErrTransactionAborted = errors.New("transaction aborted, nothing was applied")
+)
+
+// Commands that can be queued in a transaction
+var transactionCommands = map[string]bool{
+	"SLIMIT":   true,
+	"CLIMIT":   true,
+	"THROTTLE": true,
+	"ROLLBACK": true,
+}
+
+// statusResult a simple status reply, e.g. QUEUED
+type statusResult string
+
+// TransactionReport result of a command applied by a transaction
+type TransactionReport struct {
+	Command  string   `json:"command"`
+	Status   string   `json:"status"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// transaction commands queued by a session between MULTI and EXEC
+type transaction struct {
+	commands []*Command
+}
+
+func (c *adminCore) multi(session *adminSession) error {
+	if session.transaction != nil {
+		return fmt.Errorf("%w: MULTI calls can not be nested", ErrTransaction)
+	}
+	session.transaction = &transaction{commands: []*Command{}}
+	return nil
+}
+
+func (c *adminCore) discard(session *adminSession) error {
+	if session.transaction == nil {
+		return fmt.Errorf("%w: DISCARD without MULTI", ErrTransaction)
+	}
+	session.transaction = nil
+	return nil
+}
+
+func (c *adminCore) queue(session *adminSession, cmd *Command) (statusResult, error) {
+	if !transactionCommands[cmd.Action] {
+		return "", fmt.Errorf("%w: %s can't be used in a transaction", ErrTransaction, cmd.Action)
+	}
+	if len(session.transaction.commands) >= maxTransactionCommands {
+		return "", fmt.Errorf("%w: transaction can't have more than %d commands", ErrTransaction, maxTransactionCommands)
+	}
+	session.transaction.commands = append(session.transaction.commands, cmd)
+	return "QUEUED", nil
+}
+
+// exec validate all queued commands and apply them atomically.
+// If any command is invalid, none of them is applied and all the failures are reported.
+func (c *adminCore) exec(session *adminSession) ([]TransactionReport, error) {
+	if session.transaction == nil {
+		return nil, fmt.Errorf("%w: EXEC without MULTI", ErrTransaction)
+	}
+	commands := session.transaction.commands
+	session.transaction = nil
+
+	warnings, err := c.apply(session, commands)
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]TransactionReport, 0, len(commands))
+	for i, cmd := range commands {
+		reports = append(reports, TransactionReport{Command: cmd.String(), Status: "OK", Warnings: warnings[i]})
+	}
+	return reports, nil
+}
+
+// apply validate mutation commands and apply them atomically with all the affected throttlers locked.
+// If any command is invalid, none of them is applied and all the failures are reported.
+// Otherwise warnings of each command are returned.
+func (c *adminCore) apply(session *adminSession, commands []*Command) ([][]string, error) {
+	mutations := make([]mutation, 0, len(commands))
+	failures := []string{}
+	for i, cmd := range commands {
+		m, err := c.prepare(session, cmd)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("#%d `%s`: %s", i+1, cmd, err))
+			continue
+		}
+		mutations = append(mutations, m)
+	}
+	if len(failures) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrTransactionAborted, strings.Join(failures, "; "))
+	}
+	return c.commit(session, commands, mutations), nil
+}
+
+// applyOne validate a mutation command and apply it.
+// Returns warnings if the command was applied differently than requested.
+func (c *adminCore) applyOne(session *adminSession, cmd *Command) ([]string, error) {
+	m, err := c.prepare(session, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return c.commit(session, []*Command{cmd}, []mutation{m})[0], nil
+}
+
+// commit run prepared mutations of commands with all the affected throttlers locked and audit them.
+// Returns warnings of each command.
+func (c *adminCore) commit(session *adminSession, commands []*Command, mutations []mutation) [][]string {
+	names, locked := c.accessibleThrottlers(session)
+	throttlers := []*Throttler{}
+	for _, name := range names {
+		throttlers = append(throttlers, locked[name])
+	}
+	changes := make([][]auditChange, len(mutations))
+	warnings := make([][]string, len(mutations))
+	updateThrottlers(throttlers, func() {
+		for i, m := range mutations {
+			changes[i], warnings[i] = m(locked)
+		}
+	})
+
+	for i, cmd := range commands {
+		c.logger.Printf("Command `%s` was applied", cmd)
+		for _, warning := range warnings[i] {
+			c.logger.Printf("Command `%s`: %s", cmd, warning)
+		}
+		c.audit(session, cmd, changes[i])
+	}
+	return warnings
+}
+
+// mutation a prepared change of throttlers that reports old and new values of what it changed
+// and warnings if it was applied differently than requested.
+// It's given the throttlers whose locks are held, servers removed since the mutation was prepared are skipped.
+type mutation func(locked map[string]*Throttler) ([]auditChange, []string)
+
+// prepare validate a mutation command and return a function that applies it.
+func (c *adminCore) prepare(session *adminSession, cmd *Command) (mutation, error) {
+	if err := c.authorize(session, cmd); err != nil {
+		return nil, err
+	}
+
+	switch cmd.Action {
+	case "SLIMIT":
+		serverName := cmd.GetArg(0)
+		if _, err := c.throttler(serverName); err != nil {
+			return nil, err
+		}
+		lim, err := parseLimit(cmd.GetArg(1))
+		if err != nil {
+			return nil, err
+		}
+		return func(locked map[string]*Throttler) ([]auditChange, []string) {
+			throttler, ok := locked[serverName]
+			if !ok {
+				return nil, []string{removedWarning(serverName)}
+			}
+			old := throttler.totalLimit
+			res := throttler.setBandwidthLimit(lim)
+			changes := []auditChange{{server: serverName, oldValue: old, newValue: lim}}
+			// Individual limits exceeding the new server limit are changed too
+			for _, r := range res.Rescaled {
+				changes = append(changes, auditChange{server: serverName, connection: r.Key, oldValue: r.OldLimit, newValue: r.NewLimit})
+			}
+			return changes, limitWarnings(serverName, "", res)
+		}, nil
+	case "THROTTLE":
+		serverName := cmd.GetArg(0)
+		if _, err := c.throttler(serverName); err != nil {
+			return nil, err
+		}
+		enabled := cmd.GetArg(1) == "yes"
+		return func(locked map[string]*Throttler) ([]auditChange, []string) {
+			throttler, ok := locked[serverName]
+			if !ok {
+				return nil, []string{removedWarning(serverName)}
+			}
+			old := throttler.enabled
+			throttler.enabled = enabled
+			return []auditChange{{server: serverName, oldValue: old, newValue: enabled}}, nil
+		}, nil
+	case "ROLLBACK":
+		serverName := cmd.GetArg(0)
+		throttler, err := c.throttler(serverName)
+		if err != nil {
+			return nil, err
+		}
+		version, err := parseVersion(cmd.GetArg(1))
+		if err != nil {
+			return nil, err
+		}
+		throttler.mu.Lock()
+		_, err = throttler.version(version)
+		throttler.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		return func(locked map[string]*Throttler) ([]auditChange, []string) {
+			throttler, ok := locked[serverName]
+			if !ok {
+				return nil, []string{removedWarning(serverName)}
+			}
+			old := throttler.currentVersion()
+			throttler.rollback(version)
+			return []auditChange{{server: serverName, oldValue: old, newValue: throttler.currentVersion()}}, nil
+		}, nil
+	case "CLIMIT":
+		lim, err := parseLimit(cmd.GetArg(1))
+		if err != nil {
+			return nil, err
+		}
+		servers := c.accessibleServers(session)
+		if len(servers) == 0 && session.role != nil {
+			return nil, fmt.Errorf("%w: role `%s` can't access any server", ErrPermissionDenied, session.role.Name)
+		}
+		connectionAddress := cmd.GetArg(0)
+		return func(locked map[string]*Throttler) ([]auditChange, []string) {
+			changes := []auditChange{}
+			warnings := []string{}
+			for _, name := range servers {
+				throttler, ok := locked[name]
+				if !ok {
+					warnings = append(warnings, removedWarning(name))
+					continue
+				}
+				old := throttler.connectionLimit(throttler.db.Get(connectionAddress))
+				res := throttler.setBandwidthLimitForConnection(lim, connectionAddress)
+				changes = append(changes, auditChange{
+					server:     name,
+					connection: connectionAddress,
+					oldValue:   old,
+					newValue:   throttler.connectionLimit(throttler.db.Get(connectionAddress)),
+				})
+				warnings = append(warnings, limitWarnings(name, connectionAddress, res)...)
+			}
+			return changes, warnings
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: %s can't be used in a transaction", ErrTransaction, cmd.Action)
+}
+
+func removedWarning(serverName string) string {
+	return fmt.Sprintf("%s: server was removed, nothing was applied to it", serverName)
+}
+
+// limitWarnings describe how a limit change was applied differently than requested, if it was.
+func limitWarnings(serverName, connectionAddress string, res LimitResult) []string {
+	warnings := []string{}
+	if res.Applied != res.Requested {
+		warnings = append(warnings, fmt.Sprintf("%s: limit of connection %s was clamped to %d instead of %d: %s",
+			serverName, connectionAddress, res.Applied, res.Requested, res.Reason))
+	}
+	if len(res.Rescaled) > 0 {
+		rescaled := []string{}
+		for _, change := range res.Rescaled {
+			rescaled = append(rescaled, fmt.Sprintf("%s %d -> %d", change.Key, change.OldLimit, change.NewLimit))
+		}
+		warnings = append(warnings, fmt.Sprintf("%s: %s: %s", serverName, res.Reason, strings.Join(rescaled, ", ")))
+	}
+	return warnings
+}
@@ -0,0 +1,118 @@
+package qos_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kolotaev/qos"
+)
+
+func (c *testClient) readLines(t *testing.T, count int) []string {
+	lines := []string{}
+	for i := 0; i < count; i++ {
+		res, err := c.reader.ReadString('\n')
+		assert.NoError(t, err)
+		lines = append(lines, res)
+	}
+	return lines
+}
+
+func TestTCPAdminServer_TransactionExec(t *testing.T) {
+	srv1 := qos.NewThrottler(60, true)
+	srv1.RegisterConnection("A")
+	s := newTestAdminServer(map[string]*qos.Throttler{"srv1": srv1})
+	c := newAdminTestClient(s)
+	defer c.conn.Close()
+
+	assert.Equal(t, "OK\n", c.send(t, "MULTI"))
+	assert.Equal(t, "QUEUED\n", c.send(t, "SLIMIT srv1 100"))
+	assert.Equal(t, "QUEUED\n", c.send(t, "CLIMIT B 70"))
+	assert.Equal(t, "QUEUED\n", c.send(t, "THROTTLE srv1 no"))
+	// Nothing is applied before EXEC
+	assert.Equal(t, int64(60), srv1.GetBandwidthLimit())
+	assert.True(t, srv1.IsEnabled())
+
+	assert.Equal(t, "SLIMIT srv1 100: OK\n", c.send(t, "EXEC"))
+	assert.Equal(t, []string{"CLIMIT B 70: OK\n", "THROTTLE srv1 no: OK\n", "OK\n"}, c.readLines(t, 3))
+	assert.Equal(t, int64(100), srv1.GetBandwidthLimit())
+	assert.Equal(t, int64(70), srv1.GetBandwidthLimitForConnection("B"))
+	assert.Equal(t, int64(30), srv1.GetBandwidthLimitForConnection("A"))
+	assert.False(t, srv1.IsEnabled())
+}
+
+func TestTCPAdminServer_TransactionAbort(t *testing.T) {
+	srv1 := qos.NewThrottler(60, true)
+	s := newTestAdminServer(map[string]*qos.Throttler{"srv1": srv1})
+	c := newAdminTestClient(s)
+	defer c.conn.Close()
+
+	assert.Equal(t, "OK\n", c.send(t, "MULTI"))
+	assert.Equal(t, "QUEUED\n", c.send(t, "SLIMIT srv1 100"))
+	assert.Equal(t, "QUEUED\n", c.send(t, "SLIMIT srv2 100"))
+	assert.Equal(t, "QUEUED\n", c.send(t, "CLIMIT A many"))
+	assert.Equal(t, "Error: transaction aborted, nothing was applied: #2 `SLIMIT srv2 100`: unknown server srv2; "+
+		"#3 `CLIMIT A many`: failed to parse limit number `many`\n", c.send(t, "EXEC"))
+	assert.Equal(t, int64(60), srv1.GetBandwidthLimit())
+	assert.Equal(t, []qos.ConnectionInfo{}, srv1.Connections())
+}
+
+func TestTCPAdminServer_TransactionDiscard(t *testing.T) {
+	srv1 := qos.NewThrottler(60, true)
+	s := newTestAdminServer(map[string]*qos.Throttler{"srv1": srv1})
+	c := newAdminTestClient(s)
+	defer c.conn.Close()
+
+	assert.Equal(t, "Error: transaction error: EXEC without MULTI\n", c.send(t, "EXEC"))
+	assert.Equal(t, "Error: transaction error: DISCARD without MULTI\n", c.send(t, "DISCARD"))
+	assert.Equal(t, "OK\n", c.send(t, "MULTI"))
+	assert.Equal(t, "Error: transaction error: MULTI calls can not be nested\n", c.send(t, "MULTI"))
+	assert.Equal(t, "Error: transaction error: SGET can't be used in a transaction\n", c.send(t, "SGET srv1"))
+	assert.Equal(t, "QUEUED\n", c.send(t, "SLIMIT srv1 100"))
+	assert.Equal(t, "OK\n", c.send(t, "DISCARD"))
+	assert.Equal(t, int64(60), srv1.GetBandwidthLimit())
+	assert.Equal(t, "Error: transaction error: EXEC without MULTI\n", c.send(t, "EXEC"))
+}
+
+func TestTCPAdminServer_TransactionAccessControl(t *testing.T) {
+	s := newTestAdminServer(map[string]*qos.Throttler{"srv1": qos.NewThrottler(60, true)})
+	ac := qos.NewAccessControl()
+	ac.AddUser("viewer", "pass", qos.NewViewerRole())
+	s.SetAccessControl(ac)
+	c := newAdminTestClient(s)
+	defer c.conn.Close()
+
+	assert.Equal(t, "Error: authentication required\n", c.send(t, "MULTI"))
+	assert.Equal(t, "OK\n", c.send(t, "AUTH viewer pass"))
+	assert.Equal(t, "OK\n", c.send(t, "MULTI"))
+	assert.Equal(t, "QUEUED\n", c.send(t, "SLIMIT srv1 100"))
+	assert.Equal(t, "Error: transaction aborted, nothing was applied: #1 `SLIMIT srv1 100`: "+
+		"permission denied: role `viewer` can't run SLIMIT\n", c.send(t, "EXEC"))
+}
+
+func TestTCPAdminServer_TransactionRESP(t *testing.T) {
+	s := newTestAdminServer(map[string]*qos.Throttler{"srv1": qos.NewThrottler(60, true)})
+	c := newAdminTestClient(s)
+	defer c.conn.Close()
+
+	assert.Equal(t, "+OK\r\n", c.sendRaw(t, "*1\r\n$5\r\nmulti\r\n"))
+	assert.Equal(t, "+QUEUED\r\n", c.sendRaw(t, "*3\r\n$6\r\nSLIMIT\r\n$4\r\nsrv1\r\n$2\r\n10\r\n"))
+	assert.Equal(t, "*1\r\n", c.sendRaw(t, "*1\r\n$4\r\nEXEC\r\n"))
+	assert.Equal(t, []string{"+OK\r\n"}, c.readLines(t, 1))
+}
+
+func TestTCPAdminServer_TransactionWarnings(t *testing.T) {
+	s := newTestAdminServer(map[string]*qos.Throttler{"srv1": qos.NewThrottler(60, true)})
+	c := newAdminTestClient(s)
+	defer c.conn.Close()
+
+	assert.Equal(t, "OK\n", c.send(t, "MULTI"))
+	assert.Equal(t, "QUEUED\n", c.send(t, "SLIMIT srv1 10"))
+	assert.Equal(t, "QUEUED\n", c.send(t, "CLIMIT A 20"))
+	assert.Equal(t, "SLIMIT srv1 10: OK\n", c.send(t, "EXEC"))
+	assert.Equal(t, []string{
+		"CLIMIT A 20: OK (warning: srv1: limit of connection A was clamped to 10 instead of 20: " +
+			"not enough free bandwidth on the server)\n",
+		"OK\n",
+	}, c.readLines(t, 2))
+}
@@ -0,0 +1,115 @@
+package qos
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Max number of the latest audit records kept in memory for querying
+const auditLogMemorySize = 1000
+
+// AuditRecord a record of a single admin change
+type AuditRecord struct {
+	Time       time.Time `json:"time"`
+	Session    uint64    `json:"session"`
+	User       string    `json:"user,omitempty"`
+	RemoteAddr string    `json:"remote_addr"`
+	Command    string    `json:"command"`
+	Server     string    `json:"server,omitempty"`
+	Connection string    `json:"connection,omitempty"`
+	Setting    string    `json:"setting,omitempty"`
+	OldValue   string    `json:"old_value"`
+	NewValue   string    `json:"new_value"`
+}
+
+// AuditLog append-only trail of admin changes written as JSON lines.
+type AuditLog struct {
+	out     io.Writer
+	closer  io.Closer
+	records []AuditRecord
+	mu      *sync.Mutex
+}
+
+// NewAuditLog AuditLog ctor that writes records to an output writer.
+func NewAuditLog(out io.Writer) *AuditLog {
+	return &AuditLog{
+		out:     out,
+		records: []AuditRecord{},
+		mu:      new(sync.Mutex),
+	}
+}
+
+// OpenAuditLog open an audit log file for appending.
+// Records already present in the file are loaded, so they can be queried.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %s", err)
+	}
+
+	a := NewAuditLog(f)
+	a.closer = f
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		record := AuditRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to read audit log record at line %d: %s", line, err)
+		}
+		a.remember(record)
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read audit log: %s", err)
+	}
+	return a, nil
+}
+
+// Record append a record to the log.
+func (a *AuditLog) Record(record AuditRecord) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err := a.out.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %s", err)
+	}
+	a.remember(record)
+	return nil
+}
+
+// Records get up to count of the latest records in chronological order.
+func (a *AuditLog) Records(count int) []AuditRecord {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if count > len(a.records) || count <= 0 {
+		count = len(a.records)
+	}
+	res := make([]AuditRecord, count)
+	copy(res, a.records[len(a.records)-count:])
+	return res
+}
+
+// Close close the underlying file if the log was opened with OpenAuditLog.
+func (a *AuditLog) Close() error {
+	if a.closer == nil {
+		return nil
+	}
+	return a.closer.Close()
+}
+
+func (a *AuditLog) remember(record AuditRecord) {
+	a.records = append(a.records, record)
+	if len(a.records) > auditLogMemorySize {
+		a.records = a.records[len(a.records)-auditLogMemorySize:]
+	}
+}
@@ -0,0 +1,152 @@
+package qos_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kolotaev/qos"
+)
+
+func TestAuditLog_Record(t *testing.T) {
+	out := bytes.NewBufferString("")
+	a := qos.NewAuditLog(out)
+	record := qos.AuditRecord{
+		Time:       time.Date(2022, 4, 1, 10, 0, 0, 0, time.UTC),
+		Session:    3,
+		User:       "bob",
+		RemoteAddr: "127.0.0.1:5000",
+		Command:    "SLIMIT srv1 10",
+		Server:     "srv1",
+		OldValue:   "20",
+		NewValue:   "10",
+	}
+	assert.NoError(t, a.Record(record))
+	assert.Equal(t, `{"time":"2022-04-01T10:00:00Z","session":3,"user":"bob","remote_addr":"127.0.0.1:5000",`+
+		`"command":"SLIMIT srv1 10","server":"srv1","old_value":"20","new_value":"10"}`+"\n", out.String())
+	assert.Equal(t, []qos.AuditRecord{record}, a.Records(0))
+}
+
+func TestAuditLog_Records(t *testing.T) {
+	a := qos.NewAuditLog(ioutil.Discard)
+	for _, cmd := range []string{"SLIMIT srv1 1", "SLIMIT srv1 2", "SLIMIT srv1 3"} {
+		a.Record(qos.AuditRecord{Command: cmd})
+	}
+	assert.Len(t, a.Records(0), 3)
+	assert.Len(t, a.Records(10), 3)
+	records := a.Records(2)
+	assert.Equal(t, "SLIMIT srv1 2", records[0].Command)
+	assert.Equal(t, "SLIMIT srv1 3", records[1].Command)
+}
+
+func TestOpenAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	a, err := qos.OpenAuditLog(path)
+	require.NoError(t, err)
+	assert.Empty(t, a.Records(0))
+	a.Record(qos.AuditRecord{Command: "SLIMIT srv1 1"})
+	require.NoError(t, a.Close())
+
+	// Records are appended and loaded on reopening
+	a, err = qos.OpenAuditLog(path)
+	require.NoError(t, err)
+	a.Record(qos.AuditRecord{Command: "SLIMIT srv1 2"})
+	assert.Len(t, a.Records(0), 2)
+	require.NoError(t, a.Close())
+
+	contents, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	assert.Len(t, lines, 2)
+	record := qos.AuditRecord{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &record))
+	assert.Equal(t, "SLIMIT srv1 2", record.Command)
+}
+
+func TestOpenAuditLog_Malformed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	require.NoError(t, ioutil.WriteFile(path, []byte("{}\nfoo\n"), 0600))
+	_, err := qos.OpenAuditLog(path)
+	assert.ErrorContains(t, err, "failed to read audit log record at line 2")
+}
+
+func TestTCPAdminServer_History(t *testing.T) {
+	srv1 := qos.NewThrottler(30, true)
+	s := newTestAdminServer(map[string]*qos.Throttler{"srv1": srv1})
+	c := newAdminTestClient(s)
+	defer c.conn.Close()
+	assert.Equal(t, "Error: audit log is not configured\n", c.send(t, "HISTORY"))
+
+	auditLog := qos.NewAuditLog(ioutil.Discard)
+	s.SetAuditLog(auditLog)
+	ac := qos.NewAccessControl()
+	ac.AddUser("root", "pass", qos.NewAdminRole())
+	s.SetAccessControl(ac)
+	assert.Equal(t, "OK\n", c.send(t, "AUTH root pass"))
+	assert.Equal(t, "OK\n", c.send(t, "SLIMIT srv1 20"))
+	assert.Equal(t, "OK\n", c.send(t, "THROTTLE srv1 no"))
+	assert.Equal(t, "OK\n", c.send(t, "CLIMIT A 5"))
+	assert.Equal(t, "Error: unknown server srv2\n", c.send(t, "SLIMIT srv2 20"))
+
+	records := auditLog.Records(0)
+	require.Len(t, records, 3)
+	for _, r := range records {
+		assert.Equal(t, "root", r.User)
+		assert.Equal(t, "pipe", r.RemoteAddr)
+		assert.Equal(t, "srv1", r.Server)
+		assert.NotZero(t, r.Session)
+		assert.WithinDuration(t, time.Now(), r.Time, time.Minute)
+	}
+	assert.Equal(t, []string{"SLIMIT srv1 20", "30", "20"}, []string{records[0].Command, records[0].OldValue, records[0].NewValue})
+	assert.Equal(t, []string{"THROTTLE srv1 no", "true", "false"}, []string{records[1].Command, records[1].OldValue, records[1].NewValue})
+	assert.Equal(t, []string{"CLIMIT A 5", "A", "0", "5"},
+		[]string{records[2].Command, records[2].Connection, records[2].OldValue, records[2].NewValue})
+
+	res := c.send(t, "HISTORY 1")
+	record := qos.AuditRecord{}
+	assert.NoError(t, json.Unmarshal([]byte(res), &record))
+	assert.Equal(t, "CLIMIT A 5", record.Command)
+	assert.Equal(t, []string{"OK\n"}, c.readLines(t, 1))
+	assert.Equal(t, "Error: bad argument: records count must be a positive number, got `-1`\n", c.send(t, "HISTORY -1"))
+
+	// Individual limits rescaled by a server limit are recorded too
+	assert.Equal(t, "Warning: srv1: individual limits exceed the server limit and were rescaled: A 5 -> 3\n",
+		c.send(t, "SLIMIT srv1 3"))
+	assert.Equal(t, []string{"OK\n"}, c.readLines(t, 1))
+	records = auditLog.Records(2)
+	require.Len(t, records, 2)
+	assert.Equal(t, []string{"SLIMIT srv1 3", "", "20", "3"},
+		[]string{records[0].Command, records[0].Connection, records[0].OldValue, records[0].NewValue})
+	assert.Equal(t, []string{"SLIMIT srv1 3", "A", "5", "3"},
+		[]string{records[1].Command, records[1].Connection, records[1].OldValue, records[1].NewValue})
+}
+
+func TestTCPAdminServer_HistoryOfAccessibleServers(t *testing.T) {
+	s := newTestAdminServer(map[string]*qos.Throttler{"srv1": qos.NewThrottler(30, true), "srv2": qos.NewThrottler(30, true)})
+	auditLog := qos.NewAuditLog(ioutil.Discard)
+	s.SetAuditLog(auditLog)
+	ac := qos.NewAccessControl()
+	ac.AddUser("root", "pass", qos.NewAdminRole())
+	ac.AddUser("srv1admin", "pass", qos.NewAdminRole("srv1"))
+	s.SetAccessControl(ac)
+	c := newAdminTestClient(s)
+	defer c.conn.Close()
+
+	assert.Equal(t, "OK\n", c.send(t, "AUTH root pass"))
+	assert.Equal(t, "OK\n", c.send(t, "SLIMIT srv1 20"))
+	assert.Equal(t, "OK\n", c.send(t, "SLIMIT srv2 20"))
+	assert.Equal(t, "OK\n", c.send(t, "AUTH srv1admin pass"))
+
+	res := c.send(t, "HISTORY")
+	record := qos.AuditRecord{}
+	assert.NoError(t, json.Unmarshal([]byte(res), &record))
+	assert.Equal(t, "SLIMIT srv1 20", record.Command)
+	assert.Equal(t, []string{"OK\n"}, c.readLines(t, 1))
+}
@@ -0,0 +1,218 @@
+package qos
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Limits of BATCH command
+const (
+	maxBatchArgs  = 100
+	maxBatchFiles = 1000
+)
+
+// batchFile a file of a batch download
+type batchFile struct {
+	name string // name in the archive and in the sandbox's file system
+	size int64
+}
+
+// resolveBatch get files requested with `BATCH name_or_glob ...`. Names must be of served files, while globs
+// select served files of a directory matching them, see path.Match. Files are sent once, in the order of the arguments,
+// and files matching a glob are sorted by name.
+func resolveBatch(sandbox *Sandbox, cmd *Command) ([]batchFile, error) {
+	files := []batchFile{}
+	seen := make(map[string]bool)
+	add := func(name string, info fs.FileInfo) error {
+		if seen[name] {
+			return nil
+		}
+		if len(files) == maxBatchFiles {
+			return fmt.Errorf("%w: more than %d files are requested", ErrBadArgument, maxBatchFiles)
+		}
+		seen[name] = true
+		files = append(files, batchFile{name: name, size: info.Size()})
+		return nil
+	}
+
+	for _, arg := range cmd.Args {
+		if !isGlob(arg) {
+			name, _, err := sandbox.resolve(arg, true, false)
+			if err != nil {
+				return nil, err
+			}
+			info, err := fs.Stat(sandbox.fsys, name)
+			if err != nil {
+				return nil, err
+			}
+			if !info.Mode().IsRegular() {
+				return nil, fmt.Errorf("%w: `%s` is not a regular file", ErrBadArgument, arg)
+			}
+			if err := add(name, info); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		pattern := path.Clean(filepath.ToSlash(arg))
+		if !fs.ValidPath(pattern) {
+			return nil, fmt.Errorf("%w: `%s` escapes the base directory", ErrForbidden, arg)
+		}
+		if err := validatePatterns([]string{pattern}); err != nil {
+			return nil, err
+		}
+		matches, err := fs.Glob(sandbox.fsys, pattern)
+		if err != nil {
+			return nil, err
+		}
+		matched := 0
+		for _, name := range matches {
+			// Files the sandbox refuses to serve are left out as in listings
+			if _, err := sandbox.Resolve(name); err != nil {
+				continue
+			}
+			info, err := fs.Stat(sandbox.fsys, name)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			matched++
+			if err := add(name, info); err != nil {
+				return nil, err
+			}
+		}
+		if matched == 0 {
+			return nil, fmt.Errorf("%w: no files match `%s`", ErrBadArgument, arg)
+		}
+	}
+	return files, nil
+}
+
+func isGlob(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// writeBatch send files requested with BATCH command as a tar archive. The archive is written through a single
+// throttled session, so the connection's bandwidth is used continuously rather than restarting for every file.
+// In framed protocol the archive is preceded by a FileHeader of it, and an error after it is wrapped
+// with errIncompleteFrame.
+func (s *TCPFileServer) writeBatch(ctx *reasonContext, cmd *Command, conn net.Conn, connectionKey string, framed bool) error {
+	sandbox := s.getSandbox()
+	files, err := resolveBatch(sandbox, cmd)
+	if err != nil {
+		return err
+	}
+	var header *FileHeader
+	var archive io.Reader
+	stop := func() {}
+	if framed {
+		buffered, bufferedHeader, err := bufferArchive(s.compression, sandbox, files, s.getChecksumAlgorithm())
+		if err != nil {
+			return err
+		}
+		defer buffered.remove()
+		header, archive = bufferedHeader, io.NewSectionReader(buffered, 0, buffered.size)
+		textRespond(conn, header.String())
+	} else {
+		// Raw archives end by themselves, so they are written while they are sent
+		pipe, pipeWriter := io.Pipe()
+		written := make(chan struct{})
+		go func() {
+			defer close(written)
+			pipeWriter.CloseWithError(writeArchive(pipeWriter, sandbox, files))
+		}()
+		archive, stop = pipe, func() {
+			// Stop the archive writer if the transfer has failed
+			pipe.CloseWithError(errors.New("transfer has stopped"))
+			<-written
+		}
+	}
+
+	transferCtx, cancel := s.transferContext(ctx, conn)
+	defer cancel()
+	n, err := s.throttler.Write(transferCtx, conn, connectionKey, archive)
+	stop()
+
+	if err != nil && err != io.EOF {
+		err = transferError(ctx, "transfer", n, err)
+		if framed {
+			err = fmt.Errorf("%w: %s", errIncompleteFrame, err)
+		}
+		return err
+	}
+	if framed && n != header.Size {
+		return fmt.Errorf("%w: sent %d of %d bytes of the archive", errIncompleteFrame, n, header.Size)
+	}
+
+	s.logger.Printf("%d bytes of %d files sent", n, len(files))
+	return nil
+}
+
+// bufferArchive write the archive of files to a temporary file taking the space, so it's built once and framed
+// with its exact size and checksum, e.g. `OK 3072 application/x-tar sha256:...`.
+func bufferArchive(space *tempSpace, sandbox *Sandbox, files []batchFile,
+	algorithm ChecksumAlgorithm) (*encodedFile, *FileHeader, error) {
+
+	hash, err := algorithm.newHash()
+	if err != nil {
+		return nil, nil, err
+	}
+	temp, err := ioutil.TempFile("", "qos-archive-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	w := &spaceWriter{w: temp, space: space}
+	if err := writeArchive(io.MultiWriter(w, hash), sandbox, files); err != nil {
+		removeTemp(temp)
+		space.release(w.n)
+		if errors.Is(err, errNoTempSpace) {
+			err = fmt.Errorf("%w: the archive doesn't fit in the temporary space", ErrInsufficientSpace)
+		}
+		return nil, nil, err
+	}
+	archive := &encodedFile{File: temp, size: w.n, space: space, reserved: w.n}
+	return archive, &FileHeader{
+		Size:        w.n,
+		ContentType: "application/x-tar",
+		Checksum:    formatChecksum(algorithm, hash),
+		FileSize:    w.n,
+	}, nil
+}
+
+// writeArchive write files to a tar archive. A file is written with the size it had when the batch was requested,
+// so the archive stays valid if it changes meanwhile.
+func writeArchive(w io.Writer, sandbox *Sandbox, files []batchFile) error {
+	tw := tar.NewWriter(w)
+	for _, f := range files {
+		file, err := sandbox.open(f.name)
+		if err != nil {
+			return err
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     f.name,
+			Size:     f.size,
+			Mode:     int64(file.info.Mode().Perm()),
+			ModTime:  file.info.ModTime(),
+		})
+		if err == nil {
+			var n int64
+			n, err = io.Copy(tw, io.NewSectionReader(file, 0, f.size))
+			if err == nil && n < f.size {
+				err = fmt.Errorf("`%s` has shrunk to %d bytes while it was sent", f.name, n)
+			}
+		}
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
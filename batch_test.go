@@ -0,0 +1,167 @@
+package qos_test
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kolotaev/qos"
+)
+
+// readArchive read names and contents of files of a tar archive.
+func readArchive(t *testing.T, r io.Reader) map[string]string {
+	files := map[string]string{}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		require.NoError(t, err)
+		contents, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		files[header.Name] = string(contents)
+	}
+}
+
+func TestTCPFileServer_Batch(t *testing.T) {
+	s := qos.NewTCPFileServerFS(qos.NewThrottler(10240, true), newFileServerTestFS(), log.New(ioutil.Discard, "", 0))
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+
+	// Globs don't match hidden files and don't descend into directories, files are sent once
+	client.Write([]byte("BATCH *.txt c.md small.txt docs/d.txt\n"))
+	assert.Equal(t, map[string]string{
+		"small.txt":  "Go is awesome.",
+		"b.txt":      "bb",
+		"c.md":       "ccc",
+		"docs/d.txt": "dddd",
+	}, readArchive(t, reader))
+
+	// The next command follows the archive
+	client.Write([]byte("PROTO framed\n"))
+	res, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "OK\n", res)
+
+	// The archive is framed as a file of its exact size
+	client.Write([]byte("BATCH docs/*/*.txt b.txt\n"))
+	archive := new(bytes.Buffer)
+	fileHeader, err := qos.ReadFramedFile(reader, archive)
+	require.NoError(t, err)
+	assert.Equal(t, "application/x-tar", fileHeader.ContentType)
+	assert.Equal(t, int64(archive.Len()), fileHeader.Size)
+	tr := tar.NewReader(archive)
+	header, err := tr.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "docs/sub/e.txt", header.Name)
+	assert.Equal(t, int64(5), header.Size)
+	header, err = tr.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "b.txt", header.Name)
+	_, err = tr.Next()
+	assert.Equal(t, io.EOF, err)
+	client.Write([]byte("STAT b.txt\n"))
+	res, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(res, "OK "), res)
+
+	errorCases := map[string]string{
+		"BATCH small.txt missing.txt": "open missing.txt: file does not exist",
+		"BATCH *.go":                  "bad argument: no files match `*.go`",
+		"BATCH ../*.txt":              "forbidden: `../*.txt` escapes the base directory",
+		"BATCH [a-":                   "bad argument: bad pattern `[a-`: syntax error in pattern",
+		"BATCH .hidden":               "forbidden: `.hidden` is a hidden file",
+		"BATCH docs":                  "bad argument: `docs` is not a regular file",
+	}
+	for request, message := range errorCases {
+		client.Write([]byte(request + "\n"))
+		res, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "Error: "+message+"\n", res, request)
+	}
+}
+
+func TestTCPFileServer_BatchTempSpace(t *testing.T) {
+	s := qos.NewTCPFileServerFS(qos.NewThrottler(10240, true), newFileServerTestFS(), log.New(ioutil.Discard, "", 0))
+	s.SetCompressionSpace(1024)
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+	client.Write([]byte("PROTO framed\n"))
+	_, err := reader.ReadString('\n')
+	require.NoError(t, err)
+
+	// A header and contents of a file and the end of the archive take 2 KB
+	client.Write([]byte("BATCH small.txt\n"))
+	res, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "Error: not enough disk space: the archive doesn't fit in the temporary space\n", res)
+
+	// The space taken by the failed archive is released
+	s.SetCompressionSpace(2048)
+	for i := 0; i < 2; i++ {
+		client.Write([]byte("BATCH small.txt\n"))
+		archive := new(bytes.Buffer)
+		header, err := qos.ReadFramedFile(reader, archive)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2048), header.Size)
+		assert.Equal(t, map[string]string{"small.txt": "Go is awesome."}, readArchive(t, archive))
+	}
+}
+
+func TestTCPFileServer_BatchSingleSession(t *testing.T) {
+	fsys := fstest.MapFS{}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		fsys[name+".txt"] = &fstest.MapFile{Data: []byte(strings.Repeat(name, 1000))}
+	}
+	th := qos.NewThrottler(10240, true)
+	s := qos.NewTCPFileServerFS(th, fsys, log.New(ioutil.Discard, "", 0))
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+
+	// Separate FILE requests would wait for the bandwidth 4 times, while the archive of 7 KB fits in a single turn
+	start := time.Now()
+	client.Write([]byte("BATCH *.txt\n"))
+	files := readArchive(t, bufio.NewReader(reader))
+	assert.Len(t, files, 4)
+	assert.Equal(t, strings.Repeat("d", 1000), files["d.txt"])
+	assert.Less(t, time.Since(start), 1500*time.Millisecond)
+}
+
+func TestTCPFileServer_BatchCancelled(t *testing.T) {
+	fsys := fstest.MapFS{"a.txt": {Data: []byte(strings.Repeat("a", 4096))}}
+	th := qos.NewThrottler(1024, true)
+	s := qos.NewTCPFileServerFS(th, fsys, log.New(ioutil.Discard, "", 0))
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+	client.Write([]byte("PROTO framed\n"))
+	_, err := reader.ReadString('\n')
+	require.NoError(t, err)
+
+	client.Write([]byte("BATCH a.txt\n"))
+	res, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	header, err := qos.ParseFileHeader(res)
+	require.NoError(t, err)
+	assert.Equal(t, int64(512+4096+1024), header.Size)
+	_, err = io.ReadFull(reader, make([]byte, 1024))
+	require.NoError(t, err)
+
+	// The client can't tell an error from the archive, so the connection is closed
+	assert.True(t, th.KillConnection("pipe"))
+	_, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return len(th.Connections()) == 0
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,122 @@
+package qos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Reasons of a cancelled transfer or a closed connection reported to clients
+var (
+	ErrConnectionKilled = errors.New("connection was killed by an admin")
+	ErrIdleTimeout      = errors.New("connection was idle for too long")
+	ErrTransferTimeout  = errors.New("transfer took too long")
+)
+
+// reasonContext a context that remembers why it was cancelled.
+// If its parent is a reasonContext, the parent's reason is inherited.
+type reasonContext struct {
+	context.Context
+	parent *reasonContext
+	cancel context.CancelFunc
+	mu     *sync.Mutex
+	reason error
+}
+
+// withReason create a cancelable context that remembers the reason of cancellation.
+func withReason(parent context.Context) *reasonContext {
+	ctx, cancel := context.WithCancel(parent)
+	c := &reasonContext{
+		Context: ctx,
+		cancel:  cancel,
+		mu:      new(sync.Mutex),
+	}
+	if p, ok := parent.(*reasonContext); ok {
+		c.parent = p
+	}
+	return c
+}
+
+// cancelWith cancel the context for a reason. Only the first reason is kept.
+func (c *reasonContext) cancelWith(reason error) {
+	c.mu.Lock()
+	if c.reason == nil && c.Err() == nil {
+		c.reason = reason
+	}
+	c.mu.Unlock()
+	c.cancel()
+}
+
+// Reason get the reason the context was cancelled for, nil if it's not cancelled.
+func (c *reasonContext) Reason() error {
+	if c.Err() == nil {
+		return nil
+	}
+	c.mu.Lock()
+	reason := c.reason
+	c.mu.Unlock()
+	if reason != nil {
+		return reason
+	}
+	if c.parent != nil {
+		if reason := c.parent.Reason(); reason != nil {
+			return reason
+		}
+	}
+	return c.Err()
+}
+
+// transferError get an error of a transfer stopped after n bytes, telling why it was cancelled if it was,
+// e.g. `transfer was cancelled after 1024 bytes: connection was killed by an admin`.
+func transferError(ctx *reasonContext, what string, n int64, err error) error {
+	switch reason := ctx.Reason(); {
+	case reason != nil:
+		return fmt.Errorf("%s was cancelled after %d bytes: %w", what, n, reason)
+	case errors.Is(err, context.DeadlineExceeded) || isTimeout(err):
+		return fmt.Errorf("%s was cancelled after %d bytes: %w", what, n, ErrTransferTimeout)
+	}
+	return err
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// expireWrites interrupt writes to conn once ctx is done, so a client that stops reading
+// can't block a transfer past its timeout or a shutdown. stop resets the write deadline.
+func expireWrites(ctx context.Context, conn net.Conn) (stop func()) {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			conn.SetWriteDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+		conn.SetWriteDeadline(time.Time{})
+	}
+}
+
+// contextWriter a writer that stops writing once its context is done,
+// so long copies are interrupted promptly.
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w *contextWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
@@ -0,0 +1,134 @@
+package qos
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChecksumAlgorithm an algorithm of checksums of served files
+type ChecksumAlgorithm string
+
+// Checksum algorithms
+const (
+	ChecksumSHA256 ChecksumAlgorithm = "sha256"
+	ChecksumCRC32C ChecksumAlgorithm = "crc32c" // much faster, but only detects accidental corruption
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// newHash get a hash of the algorithm.
+func (a ChecksumAlgorithm) newHash() (hash.Hash, error) {
+	switch a {
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumCRC32C:
+		return crc32.New(crc32cTable), nil
+	}
+	return nil, fmt.Errorf("%w: unknown checksum algorithm `%s`, want sha256 or crc32c", ErrBadArgument, a)
+}
+
+// Validate is the algorithm supported?
+func (a ChecksumAlgorithm) Validate() error {
+	_, err := a.newHash()
+	return err
+}
+
+// Checksum get a checksum of all data of r in the form of `<algorithm>:<hex>`, e.g. `crc32c:e3069283`,
+// as it's reported by file servers.
+func Checksum(r io.Reader, algorithm ChecksumAlgorithm) (string, error) {
+	h, err := algorithm.newHash()
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return formatChecksum(algorithm, h), nil
+}
+
+func formatChecksum(algorithm ChecksumAlgorithm, h hash.Hash) string {
+	return string(algorithm) + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// checksumAlgorithmOf get the algorithm of a checksum in the form of `<algorithm>:<hex>`.
+func checksumAlgorithmOf(checksum string) (ChecksumAlgorithm, error) {
+	i := strings.Index(checksum, ":")
+	if i < 0 {
+		return "", fmt.Errorf("%w: checksum `%s` has no algorithm", ErrBadArgument, checksum)
+	}
+	algorithm := ChecksumAlgorithm(checksum[:i])
+	return algorithm, algorithm.Validate()
+}
+
+// How many file checksums a server keeps
+const checksumCacheSize = 1024
+
+// checksumCache checksums of whole files. A checksum is recomputed once the file's size or modification time changes.
+// Files without a modification time, e.g. of fstest.MapFS, are not cached, as their changes can't be noticed.
+type checksumCache struct {
+	mu      *sync.Mutex
+	entries map[checksumKey]checksumEntry
+}
+
+type checksumKey struct {
+	path      string
+	algorithm ChecksumAlgorithm
+}
+
+type checksumEntry struct {
+	size     int64
+	modTime  time.Time
+	checksum string
+}
+
+// newChecksumCache checksumCache ctor
+func newChecksumCache() *checksumCache {
+	return &checksumCache{
+		mu:      new(sync.Mutex),
+		entries: make(map[checksumKey]checksumEntry),
+	}
+}
+
+// get get a checksum of an open file, it's computed if it's not cached or the file has changed.
+func (c *checksumCache) get(file *servedFile, algorithm ChecksumAlgorithm) (string, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	key := checksumKey{path: file.key, algorithm: algorithm}
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+		return entry.checksum, nil
+	}
+
+	checksum, err := Checksum(io.NewSectionReader(file, 0, info.Size()), algorithm)
+	if err != nil {
+		return "", err
+	}
+	// A file changed while it was read must not be cached with a checksum of mixed contents
+	after, err := file.Stat()
+	if err != nil || after.Size() != info.Size() || !after.ModTime().Equal(info.ModTime()) || info.ModTime().IsZero() {
+		return checksum, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= checksumCacheSize {
+		// Evict an arbitrary entry, hot files get back soon
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[key] = checksumEntry{size: info.Size(), modTime: info.ModTime(), checksum: checksum}
+	return checksum, nil
+}
@@ -0,0 +1,23 @@
+package qos_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kolotaev/qos"
+)
+
+func TestChecksum(t *testing.T) {
+	sum, err := qos.Checksum(strings.NewReader("Go is awesome."), qos.ChecksumSHA256)
+	assert.NoError(t, err)
+	assert.Equal(t, smallChecksum, sum)
+
+	sum, err = qos.Checksum(strings.NewReader("Go is awesome."), qos.ChecksumCRC32C)
+	assert.NoError(t, err)
+	assert.Equal(t, "crc32c:16a8989e", sum)
+
+	_, err = qos.Checksum(strings.NewReader("Go is awesome."), "md5")
+	assert.EqualError(t, err, "bad argument: unknown checksum algorithm `md5`, want sha256 or crc32c")
+}
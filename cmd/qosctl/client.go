@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// client admin server client that talks JSON protocol, so replies can be decoded and rendered.
+type client struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// reply a single JSON reply of the admin server
+type reply struct {
+	Status  string          `json:"status"`
+	Data    json.RawMessage `json:"data"`
+	Code    string          `json:"code"`
+	Message string          `json:"message"`
+	raw     string
+}
+
+// Err get an error of a failed command, if it failed.
+func (r *reply) Err() error {
+	if r.Status == "ok" {
+		return nil
+	}
+	return fmt.Errorf("%s (%s)", r.Message, r.Code)
+}
+
+func dial(network, address string, timeout time.Duration) (*client, error) {
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to admin server: %s", err)
+	}
+	c := newClient(conn)
+	r, err := c.run("PROTO json")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := r.Err(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to switch to JSON protocol: %s", err)
+	}
+	return c, nil
+}
+
+func newClient(conn net.Conn) *client {
+	return &client{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// run send a command line and wait for its reply.
+func (c *client) run(line string) (*reply, error) {
+	if _, err := c.conn.Write([]byte(line + "\n")); err != nil {
+		return nil, fmt.Errorf("failed to send command: %s", err)
+	}
+	raw, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reply: %s", err)
+	}
+	r := &reply{raw: strings.TrimSpace(raw)}
+	if err := json.Unmarshal([]byte(raw), r); err != nil {
+		return nil, fmt.Errorf("failed to decode reply `%s`: %s", r.raw, err)
+	}
+	return r, nil
+}
+
+func (c *client) close() error {
+	return c.conn.Close()
+}
+
+// normalizeCommand join command tokens with single spaces as the admin server expects.
+// Command name is upper-cased, so it can be typed in any case.
+func normalizeCommand(line string) (string, error) {
+	tokens := strings.Fields(line)
+	if len(tokens) == 0 {
+		return "", errors.New("empty command")
+	}
+	tokens[0] = strings.ToUpper(tokens[0])
+	if tokens[0] == "PROTO" {
+		return "", errors.New("PROTO can't be used with qosctl, use -json flag for raw JSON replies")
+	}
+	return strings.Join(tokens, " "), nil
+}
@@ -0,0 +1,103 @@
+// Command qosctl is a command-line client of the qos TCPAdminServer.
+//
+// Without a command it starts an interactive session with command history and completion:
+//
+//	qosctl -addr 127.0.0.1:5000
+//
+// With a command it runs just it and exits with a non-zero status if the command failed:
+//
+//	qosctl -addr 127.0.0.1:5000 CLIST srv1
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kolotaev/qos"
+)
+
+func main() {
+	network := flag.String("network", "tcp", "network of the admin server: tcp or unix")
+	addr := flag.String("addr", "127.0.0.1:5000", "address of the admin server, a socket path for unix network")
+	user := flag.String("user", "", "user name to authenticate with, password is read from QOSCTL_PASSWORD")
+	jsonOutput := flag.Bool("json", false, "print raw JSON replies instead of tables")
+	timeout := flag.Duration("timeout", 5*time.Second, "connection timeout")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [COMMAND [ARGS...]]\n\nFlags:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	c, err := dial(*network, *addr, *timeout)
+	if err != nil {
+		fail(err)
+	}
+	defer c.close()
+
+	if *user != "" {
+		r, err := c.run(fmt.Sprintf("AUTH %s %s", *user, os.Getenv("QOSCTL_PASSWORD")))
+		if err == nil {
+			err = r.Err()
+		}
+		if err != nil {
+			fail(fmt.Errorf("failed to authenticate: %s", err))
+		}
+	}
+
+	s := &session{
+		client:     c,
+		commands:   adminCommands(),
+		jsonOutput: *jsonOutput,
+	}
+
+	if flag.NArg() > 0 {
+		if err := s.run(os.Stdout, strings.Join(flag.Args(), " ")); err != nil {
+			fail(err)
+		}
+		return
+	}
+
+	s.servers = s.serverNames()
+	if err := s.repl(os.Stdin, os.Stdout); err != nil {
+		fail(err)
+	}
+}
+
+// adminCommands get commands supported by the admin server.
+func adminCommands() []qos.CommandInfo {
+	res := []qos.CommandInfo{}
+	for _, c := range qos.Commands() {
+		// Some commands are served by file servers only and qosctl always talks JSON
+		if !c.HandledBy(qos.AdminCommand) || c.Name == "PROTO" {
+			continue
+		}
+		res = append(res, c)
+	}
+	return res
+}
+
+// serverNames get names of servers for completion, if the user can list them.
+func (s *session) serverNames() []string {
+	r, err := s.client.run("SLIST")
+	if err != nil || r.Err() != nil {
+		return []string{}
+	}
+	servers := []qos.ServerInfo{}
+	if err := json.Unmarshal(r.Data, &servers); err != nil {
+		return []string{}
+	}
+	names := []string{}
+	for _, srv := range servers {
+		names = append(names, srv.Name)
+	}
+	return names
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kolotaev/qos"
+)
+
+func newTestSession(t *testing.T, throttlers map[string]*qos.Throttler) *session {
+	s := qos.NewTCPAdminServer(throttlers, log.New(ioutil.Discard, "", 0))
+	conn, server := net.Pipe()
+	go s.Handle(server)
+	c := newClient(conn)
+	r, err := c.run("PROTO json")
+	require.NoError(t, err)
+	require.NoError(t, r.Err())
+	return &session{client: c, commands: adminCommands()}
+}
+
+func TestSession_Run(t *testing.T) {
+	th := qos.NewThrottler(30, true)
+	th.RegisterConnection("127.0.0.1:5000")
+	th.SetBandwidthLimitForConnection(10, "127.0.0.1:5001")
+	s := newTestSession(t, map[string]*qos.Throttler{"srv1": th})
+	defer s.client.close()
+
+	out := &bytes.Buffer{}
+	require.NoError(t, s.run(out, "clist   srv1"))
+	assert.Equal(t, ""+
+		"CONNECTION      LIMIT  INDIVIDUAL\n"+
+		"127.0.0.1:5000  20     false\n"+
+		"127.0.0.1:5001  10     true\n", out.String())
+
+	out.Reset()
+	require.NoError(t, s.run(out, "CLIMIT 127.0.0.1:5002 50"))
+	assert.Equal(t, "Warning: srv1: limit of connection 127.0.0.1:5002 was clamped to 20 instead of 50: "+
+		"not enough free bandwidth on the server\nOK\n", out.String())
+
+	assert.EqualError(t, s.run(out, "SGET srv2"), "unknown server srv2 (unknown_server)")
+	assert.EqualError(t, s.run(out, "PROTO text"), "PROTO can't be used with qosctl, use -json flag for raw JSON replies")
+
+	out.Reset()
+	s.jsonOutput = true
+	require.NoError(t, s.run(out, "SGET srv1"))
+	assert.Equal(t, `{"status":"ok","data":{"name":"srv1","limit":30,"enabled":true,"connections":3}}`+"\n", out.String())
+}
+
+func TestSession_Complete(t *testing.T) {
+	s := &session{commands: adminCommands(), servers: []string{"srv1", "srv2", "backup"}}
+
+	cases := []struct {
+		line     string
+		pos      int
+		expected string
+		ok       bool
+	}{
+		{"sl", 2, "SLI", true},
+		{"SLIM", 4, "SLIMIT ", true},
+		{"SLIMIT s", 8, "SLIMIT srv", true},
+		{"SLIMIT b 10", 8, "SLIMIT backup  10", true},
+		{"CGET s", 6, "", false},
+		{"CLIMIT s", 8, "", false},
+		{"THROTTLE s", 10, "THROTTLE srv", true},
+		{"DRYRUN s", 8, "DRYRUN SLIMIT ", true},
+		{"DRYRUN SLIMIT b", 15, "DRYRUN SLIMIT backup ", true},
+		{"FIL", 3, "", false},
+	}
+	for _, tc := range cases {
+		line, pos, ok := s.complete(tc.line, tc.pos)
+		assert.Equal(t, tc.ok, ok, tc.line)
+		assert.Equal(t, tc.expected, line, tc.line)
+		if ok {
+			assert.Equal(t, len(tc.expected)-len(tc.line)+tc.pos, pos, tc.line)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kolotaev/qos"
+)
+
+// render print a successful reply of a command in a human readable form, lists as tables.
+func render(w io.Writer, action string, r *reply) error {
+	if len(r.Data) == 0 || string(r.Data) == "null" {
+		fmt.Fprintln(w, "OK")
+		return nil
+	}
+
+	switch action {
+	case "SLIST", "SGET":
+		servers := []qos.ServerInfo{}
+		if action == "SGET" {
+			servers = append(servers, qos.ServerInfo{})
+			if err := json.Unmarshal(r.Data, &servers[0]); err != nil {
+				return err
+			}
+		} else if err := json.Unmarshal(r.Data, &servers); err != nil {
+			return err
+		}
+		rows := [][]interface{}{}
+		for _, s := range servers {
+			rows = append(rows, []interface{}{s.Name, s.Limit, s.Enabled, s.Connections})
+		}
+		table(w, []string{"SERVER", "LIMIT", "ENABLED", "CONNECTIONS"}, rows)
+	case "CLIST":
+		conns := []qos.ConnectionInfo{}
+		if err := json.Unmarshal(r.Data, &conns); err != nil {
+			return err
+		}
+		rows := [][]interface{}{}
+		for _, c := range conns {
+			rows = append(rows, []interface{}{c.Key, c.Limit, c.HasIndividualLimit})
+		}
+		table(w, []string{"CONNECTION", "LIMIT", "INDIVIDUAL"}, rows)
+	case "CGET":
+		conns := []qos.ServerConnectionInfo{}
+		if err := json.Unmarshal(r.Data, &conns); err != nil {
+			return err
+		}
+		rows := [][]interface{}{}
+		for _, c := range conns {
+			rows = append(rows, []interface{}{c.Server, c.Key, c.Limit, c.HasIndividualLimit})
+		}
+		table(w, []string{"SERVER", "CONNECTION", "LIMIT", "INDIVIDUAL"}, rows)
+	case "DRYRUN":
+		changes := []qos.ServerAllocationChange{}
+		if err := json.Unmarshal(r.Data, &changes); err != nil {
+			return err
+		}
+		rows := [][]interface{}{}
+		for _, c := range changes {
+			rows = append(rows, []interface{}{c.Server, c.Key, c.OldLimit, c.NewLimit})
+		}
+		table(w, []string{"SERVER", "CONNECTION", "LIMIT", "NEW LIMIT"}, rows)
+	case "VERSIONS":
+		versions := []qos.ThrottlerConfig{}
+		if err := json.Unmarshal(r.Data, &versions); err != nil {
+			return err
+		}
+		rows := [][]interface{}{}
+		for _, v := range versions {
+			rows = append(rows, []interface{}{v.Version, v.Time.Format(time.RFC3339), v.Limit, v.Enabled,
+				formatLimits(v.ConnectionLimits)})
+		}
+		table(w, []string{"VERSION", "TIME", "LIMIT", "ENABLED", "CONNECTION LIMITS"}, rows)
+	case "VDIFF":
+		changes := []qos.ConfigChange{}
+		if err := json.Unmarshal(r.Data, &changes); err != nil {
+			return err
+		}
+		rows := [][]interface{}{}
+		for _, c := range changes {
+			rows = append(rows, []interface{}{c.Setting, qos.OrDash(c.OldValue), qos.OrDash(c.NewValue)})
+		}
+		table(w, []string{"SETTING", "OLD", "NEW"}, rows)
+	case "RELOAD":
+		changes := []qos.ConfigChange{}
+		if err := json.Unmarshal(r.Data, &changes); err != nil {
+			return err
+		}
+		if len(changes) == 0 {
+			fmt.Fprintln(w, "OK (nothing changed)")
+			return nil
+		}
+		rows := [][]interface{}{}
+		for _, c := range changes {
+			rows = append(rows, []interface{}{qos.OrDash(c.Server), c.Setting, qos.OrDash(c.OldValue), qos.OrDash(c.NewValue)})
+		}
+		table(w, []string{"SERVER", "SETTING", "OLD", "NEW"}, rows)
+	case "HISTORY":
+		records := []qos.AuditRecord{}
+		if err := json.Unmarshal(r.Data, &records); err != nil {
+			return err
+		}
+		rows := [][]interface{}{}
+		for _, rec := range records {
+			rows = append(rows, []interface{}{rec.Time.Format(time.RFC3339), qos.OrDash(rec.User), rec.RemoteAddr,
+				rec.Command, qos.OrDash(rec.Server), qos.OrDash(rec.Connection), rec.OldValue, rec.NewValue})
+		}
+		table(w, []string{"TIME", "USER", "ADDRESS", "COMMAND", "SERVER", "CONNECTION", "OLD", "NEW"}, rows)
+	case "EXEC":
+		reports := []qos.TransactionReport{}
+		if err := json.Unmarshal(r.Data, &reports); err != nil {
+			return err
+		}
+		rows := [][]interface{}{}
+		for _, report := range reports {
+			rows = append(rows, []interface{}{report.Command, report.Status, qos.OrDash(strings.Join(report.Warnings, "; "))})
+		}
+		table(w, []string{"COMMAND", "STATUS", "WARNINGS"}, rows)
+	case "THROTTLE", "SLIMIT", "CLIMIT", "ROLLBACK":
+		res := qos.ChangeResult{}
+		if err := json.Unmarshal(r.Data, &res); err != nil {
+			return err
+		}
+		for _, warning := range res.Warnings {
+			fmt.Fprintln(w, "Warning: "+warning)
+		}
+		fmt.Fprintln(w, "OK")
+	default:
+		var txt string
+		if err := json.Unmarshal(r.Data, &txt); err != nil {
+			// Unknown data, show it as is
+			fmt.Fprintln(w, string(r.Data))
+			return nil
+		}
+		fmt.Fprintln(w, txt)
+	}
+	return nil
+}
+
+func table(w io.Writer, header []string, rows [][]interface{}) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		cells := make([]string, 0, len(row))
+		for _, cell := range row {
+			cells = append(cells, fmt.Sprint(cell))
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	tw.Flush()
+}
+
+func formatLimits(limits map[string]int64) string {
+	keys := []string{}
+	for k := range limits {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	res := []string{}
+	for _, k := range keys {
+		res = append(res, fmt.Sprintf("%s=%d", k, limits[k]))
+	}
+	return qos.OrDash(strings.Join(res, " "))
+}
+
+// help print commands with their descriptions.
+func help(w io.Writer, commands []qos.CommandInfo) {
+	rows := [][]interface{}{}
+	for _, c := range commands {
+		rows = append(rows, []interface{}{c.Name, c.Description})
+	}
+	rows = append(rows, []interface{}{"HELP", "Show this help"}, []interface{}{"QUIT", "Exit qosctl"})
+	table(w, []string{"COMMAND", "DESCRIPTION"}, rows)
+}
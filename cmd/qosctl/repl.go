@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/kolotaev/qos"
+)
+
+const prompt = "qos> "
+
+// session interactive or scripted run of commands over a single connection
+type session struct {
+	client     *client
+	commands   []qos.CommandInfo
+	servers    []string
+	jsonOutput bool
+}
+
+// repl run commands typed in a terminal with line editing, history and completion.
+// If input is not a terminal, commands are read line by line, so they can be piped in.
+func (s *session) repl(in *os.File, out io.Writer) error {
+	fd := int(in.Fd())
+	if !term.IsTerminal(fd) {
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			if quit := s.execute(out, scanner.Text()); quit {
+				return nil
+			}
+		}
+		return scanner.Err()
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("failed to set up terminal: %s", err)
+	}
+	defer term.Restore(fd, state)
+
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{in, out}, prompt)
+	t.AutoCompleteCallback = func(line string, pos int, key rune) (string, int, bool) {
+		if key != '\t' {
+			return "", 0, false
+		}
+		return s.complete(line, pos)
+	}
+	fmt.Fprintln(t, "Type HELP to list commands, QUIT or Ctrl-D to exit.")
+	for {
+		line, err := t.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if quit := s.execute(t, line); quit {
+			return nil
+		}
+	}
+}
+
+// execute run a single command line and print its result.
+// Returns true if the session is over.
+func (s *session) execute(out io.Writer, line string) bool {
+	if strings.TrimSpace(line) == "" {
+		return false
+	}
+	switch strings.ToUpper(strings.TrimSpace(line)) {
+	case "QUIT", "EXIT":
+		return true
+	case "HELP":
+		help(out, s.commands)
+		return false
+	}
+
+	if err := s.run(out, line); err != nil {
+		fmt.Fprintf(out, "Error: %s\n", err)
+	}
+	return strings.ToUpper(strings.TrimSpace(line)) == "STOP"
+}
+
+// run send a command line to the admin server and print its reply.
+func (s *session) run(out io.Writer, line string) error {
+	cmd, err := normalizeCommand(line)
+	if err != nil {
+		return err
+	}
+	r, err := s.client.run(cmd)
+	if err != nil {
+		return err
+	}
+	if s.jsonOutput {
+		fmt.Fprintln(out, r.raw)
+		return r.Err()
+	}
+	if err := r.Err(); err != nil {
+		return err
+	}
+	return render(out, strings.Fields(cmd)[0], r)
+}
+
+// complete complete a command name or a server name argument at the cursor position.
+// Ambiguous words are completed up to the longest common prefix of the candidates.
+func (s *session) complete(line string, pos int) (string, int, bool) {
+	head, tail := line[:pos], line[pos:]
+	tokens := strings.Split(head, " ")
+	word := tokens[len(tokens)-1]
+
+	candidates := []string{}
+	action := strings.ToUpper(tokens[0])
+	switch {
+	case len(tokens) == 1:
+		word = strings.ToUpper(word)
+		for _, c := range s.commands {
+			candidates = append(candidates, c.Name)
+		}
+	case len(tokens) == 2 && action == "DRYRUN":
+		word = strings.ToUpper(word)
+		candidates = []string{"CLIMIT", "SLIMIT"}
+	case len(tokens) == 2 && s.takesServer(action),
+		len(tokens) == 3 && action == "DRYRUN" && strings.ToUpper(tokens[1]) == "SLIMIT":
+		candidates = s.servers
+	}
+
+	matches := []string{}
+	for _, c := range candidates {
+		if strings.HasPrefix(c, word) {
+			matches = append(matches, c)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+	completion := commonPrefix(matches)
+	if len(matches) == 1 {
+		completion += " "
+	}
+	if len(completion) <= len(word) {
+		return "", 0, false
+	}
+
+	tokens[len(tokens)-1] = completion
+	head = strings.Join(tokens, " ")
+	return head + tail, len(head), true
+}
+
+// takesServer is the first argument of a command a server name?
+func (s *session) takesServer(action string) bool {
+	for _, c := range s.commands {
+		if c.Name == action {
+			return c.FirstArg == qos.ArgServerName
+		}
+	}
+	return false
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
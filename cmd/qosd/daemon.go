@@ -0,0 +1,544 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kolotaev/qos"
+)
+
+// How often schedules are checked
+const scheduleInterval = 30 * time.Second
+
+// Suffix of reported changes that are not applied until the daemon is restarted
+const restartRequired = " (restart required)"
+
+// adminServer common interface of TCP and HTTP admin servers
+type adminServer interface {
+	SetAccessControl(accessControl *qos.AccessControl)
+	SetAuditLog(auditLog *qos.AuditLog)
+	SetReloadHandler(reload qos.ReloadFunc)
+	SetShutdownHandler(shutdown qos.ShutdownFunc)
+	AddServer(name string, throttler *qos.Throttler)
+	RemoveServer(name string)
+	Serve(protocol, address string) error
+	ServeTLS(protocol, address string, config *tls.Config) error
+	Stop()
+}
+
+// gracefulServer a server that can wait for requests in progress when it stops
+type gracefulServer interface {
+	Shutdown(ctx context.Context) error
+}
+
+// listener a server with its listening settings
+type listener struct {
+	name     string
+	network  string
+	address  string
+	tls      *tls.Config
+	serve    func(protocol, address string) error
+	serveTLS func(protocol, address string, config *tls.Config) error
+	fatal    bool // whether the daemon stops when the server fails
+	removed  bool // server was removed by a reload, guarded by daemon mu
+}
+
+// fileServer a running file server with its Throttler and schedules
+type fileServer struct {
+	config    qos.ServerConfig
+	throttler *qos.Throttler
+	server    *qos.TCPFileServer
+	scheduler *qos.Scheduler
+	listener  *listener
+}
+
+// daemon all the servers of a configuration
+type daemon struct {
+	configPath   string
+	config       *qos.Config
+	drainTimeout time.Duration
+	logOutput    io.Writer
+	logger       *log.Logger
+	servers      map[string]*fileServer
+	draining     []*fileServer // servers removed by a reload, their connections are still served
+	admins       []adminServer
+	listeners    []*listener
+	auditLog     *qos.AuditLog
+	errs         chan error
+	stopped      chan struct{} // closed when shutdown is complete
+	shuttingDown bool
+	shutdownOnce *sync.Once
+	mu           *sync.Mutex
+}
+
+func newDaemon(configPath string, config *qos.Config, drainTimeout time.Duration) (*daemon, error) {
+	d := &daemon{
+		configPath:   configPath,
+		config:       config,
+		drainTimeout: drainTimeout,
+		logOutput:    os.Stdout,
+		servers:      make(map[string]*fileServer),
+		errs:         make(chan error, 1),
+		stopped:      make(chan struct{}),
+		shutdownOnce: new(sync.Once),
+		mu:           new(sync.Mutex),
+	}
+	if config.LogFile != "" {
+		f, err := os.OpenFile(config.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %s", err)
+		}
+		d.logOutput = f
+	}
+	d.logger = d.newLogger("QOSD ")
+	if config.AuditLog != "" {
+		auditLog, err := qos.OpenAuditLog(config.AuditLog)
+		if err != nil {
+			return nil, err
+		}
+		d.auditLog = auditLog
+	}
+
+	throttlers := make(map[string]*qos.Throttler)
+	for _, s := range config.Servers {
+		fs, err := d.newFileServer(s)
+		if err != nil {
+			return nil, err
+		}
+		fs.listener.fatal = true
+		d.servers[s.Name] = fs
+		d.listeners = append(d.listeners, fs.listener)
+		throttlers[s.Name] = fs.throttler
+	}
+
+	accessControl := config.AccessControl()
+	for i, a := range config.Admin {
+		var server adminServer
+		if a.Protocol == "http" {
+			server = qos.NewHTTPAdminServer(throttlers, d.newLogger("HTTP ADMIN SRV "))
+		} else {
+			server = qos.NewTCPAdminServer(throttlers, d.newLogger("ADMIN SRV "))
+		}
+		if accessControl != nil {
+			server.SetAccessControl(accessControl)
+		}
+		if d.auditLog != nil {
+			server.SetAuditLog(d.auditLog)
+		}
+		server.SetReloadHandler(d.reload)
+		server.SetShutdownHandler(d.requestShutdown)
+		l, err := newListener(fmt.Sprintf("admin server #%d", i), a.Network, a.Address, a.TLS)
+		if err != nil {
+			return nil, err
+		}
+		l.serve, l.serveTLS = server.Serve, server.ServeTLS
+		l.fatal = true
+		d.admins = append(d.admins, server)
+		d.listeners = append(d.listeners, l)
+	}
+	return d, nil
+}
+
+// newFileServer create a file server of a configuration, it isn't started yet.
+func (d *daemon) newFileServer(config qos.ServerConfig) (*fileServer, error) {
+	fs := &fileServer{
+		config:    config,
+		throttler: qos.NewThrottler(config.Limit, config.ThrottlingEnabled()),
+	}
+	if len(config.Schedules) > 0 {
+		fs.scheduler = qos.NewScheduler(fs.throttler, config.Schedules, d.newLogger("SCHEDULE "+config.Name+" "))
+	}
+	fs.server = qos.NewTCPFileServer(fs.throttler, config.BaseDir, d.newLogger("FILE SRV "+config.Name+" "))
+	idleTimeout, transferTimeout := config.Timeouts()
+	fs.server.SetIdleTimeout(idleTimeout)
+	fs.server.SetTransferTimeout(transferTimeout)
+	fs.server.SetKeyByHost(config.KeyByHost)
+	// Configuration is validated, so the algorithm is supported
+	fs.server.SetChecksumAlgorithm(config.ChecksumAlgorithm())
+	fs.server.SetUploadPolicy(config.Uploads)
+	sandbox, err := config.NewSandbox()
+	if err != nil {
+		return nil, err
+	}
+	fs.server.SetSandbox(sandbox)
+	l, err := newListener("file server "+config.Name, config.Network, config.Address, config.TLS)
+	if err != nil {
+		return nil, err
+	}
+	l.serve, l.serveTLS = fs.server.Serve, fs.server.ServeTLS
+	fs.listener = l
+	return fs, nil
+}
+
+func newListener(name, network, address string, files *qos.TLSFiles) (*listener, error) {
+	l := &listener{name: name, network: network, address: address}
+	if files != nil {
+		config, err := files.Config()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		l.tls = config
+	}
+	return l, nil
+}
+
+func (d *daemon) newLogger(prefix string) *log.Logger {
+	return log.New(d.logOutput, prefix, log.LstdFlags)
+}
+
+// run start all the servers and schedulers. Returns when any of the servers fails or after shutdown.
+func (d *daemon) run() error {
+	d.mu.Lock()
+	for _, fs := range d.servers {
+		if fs.scheduler != nil {
+			go fs.scheduler.Run(scheduleInterval)
+		}
+	}
+	for _, l := range d.listeners {
+		go d.serve(l)
+	}
+	d.mu.Unlock()
+
+	select {
+	case err := <-d.errs:
+		return err
+	case <-d.stopped:
+		return nil
+	}
+}
+
+// serve run a server until it fails or is stopped.
+// Failures of servers removed or added by a reload are only logged.
+func (d *daemon) serve(l *listener) {
+	var err error
+	if l.tls != nil {
+		err = l.serveTLS(l.network, l.address, l.tls)
+	} else {
+		err = l.serve(l.network, l.address)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case l.removed, d.shuttingDown:
+		d.logger.Printf("%s stopped", l.name)
+	case !l.fatal:
+		d.logger.Printf("%s stopped: %s", l.name, err)
+	default:
+		select {
+		case d.errs <- fmt.Errorf("%s stopped: %s", l.name, err):
+		default:
+		}
+	}
+}
+
+// reload re-read the configuration file and apply the difference to the running servers.
+// Limits, throttling, schedules and users are changed in place, added servers are started and removed servers
+// stop accepting connections, while transfers in progress are not interrupted.
+// Changes of listening settings, admin servers and log files are reported, but need a restart.
+// If the new configuration is invalid nothing is changed.
+func (d *daemon) reload() ([]qos.ConfigChange, error) {
+	config, err := qos.LoadConfig(d.configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.shuttingDown {
+		return nil, qos.ErrShuttingDown
+	}
+
+	// Create added servers first, so a failure doesn't leave the configuration half applied
+	added := make(map[string]*fileServer)
+	for _, s := range config.Servers {
+		if _, ok := d.servers[s.Name]; ok {
+			continue
+		}
+		fs, err := d.newFileServer(s)
+		if err != nil {
+			return nil, err
+		}
+		added[s.Name] = fs
+	}
+
+	changes := []qos.ConfigChange{}
+	kept := make(map[string]bool)
+	for _, s := range config.Servers {
+		kept[s.Name] = true
+		if fs, ok := added[s.Name]; ok {
+			d.startServer(fs)
+			changes = append(changes, qos.ConfigChange{Server: s.Name, Setting: "server", NewValue: "added"})
+			continue
+		}
+		changes = append(changes, d.updateServer(d.servers[s.Name], s)...)
+	}
+	for _, s := range d.config.Servers {
+		if !kept[s.Name] {
+			d.stopServer(d.servers[s.Name])
+			changes = append(changes, qos.ConfigChange{Server: s.Name, Setting: "server", OldValue: "running", NewValue: "removed"})
+		}
+	}
+
+	if !reflect.DeepEqual(d.config.Users, config.Users) {
+		for _, server := range d.admins {
+			server.SetAccessControl(config.AccessControl())
+		}
+		changes = append(changes, qos.ConfigChange{
+			Setting:  "users",
+			OldValue: userNames(d.config.Users),
+			NewValue: userNames(config.Users),
+		})
+	}
+	if d.config.LogFile != config.LogFile {
+		changes = append(changes, qos.ConfigChange{
+			Setting: "log_file", OldValue: d.config.LogFile, NewValue: config.LogFile + restartRequired,
+		})
+	}
+	if d.config.AuditLog != config.AuditLog {
+		changes = append(changes, qos.ConfigChange{
+			Setting: "audit_log", OldValue: d.config.AuditLog, NewValue: config.AuditLog + restartRequired,
+		})
+	}
+	if !reflect.DeepEqual(d.config.Admin, config.Admin) {
+		changes = append(changes, qos.ConfigChange{Setting: "admin", NewValue: "changed" + restartRequired})
+	}
+
+	// Keep settings that need a restart as they are running, so they are reported again until then
+	running := *config
+	running.Servers = []qos.ServerConfig{}
+	for _, s := range config.Servers {
+		running.Servers = append(running.Servers, d.servers[s.Name].config)
+	}
+	running.LogFile, running.AuditLog, running.Admin = d.config.LogFile, d.config.AuditLog, d.config.Admin
+	d.config = &running
+
+	for _, change := range changes {
+		d.logger.Printf("Reload: %s %s: %s -> %s", change.Server, change.Setting, change.OldValue, change.NewValue)
+	}
+	return changes, nil
+}
+
+// requestShutdown start a graceful shutdown in background.
+func (d *daemon) requestShutdown(drainTimeout time.Duration) {
+	go d.shutdown(drainTimeout)
+}
+
+// shutdown gracefully stop all the servers. File servers stop accepting connections and transfers
+// in progress are given drainTimeout to finish, then they are interrupted.
+// Zero drainTimeout means the daemon's default one.
+func (d *daemon) shutdown(drainTimeout time.Duration) {
+	d.shutdownOnce.Do(func() {
+		if drainTimeout == 0 {
+			drainTimeout = d.drainTimeout
+		}
+		d.mu.Lock()
+		d.shuttingDown = true
+		servers := []*fileServer{}
+		for _, fs := range d.servers {
+			servers = append(servers, fs)
+		}
+		// Transfers of removed servers are drained and reported as well
+		servers = append(servers, d.draining...)
+		d.mu.Unlock()
+
+		d.logger.Printf("Shutting down, transfers in progress are given %s to finish", drainTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+
+		reports := make([]qos.ShutdownReport, len(servers))
+		wg := new(sync.WaitGroup)
+		for i, fs := range servers {
+			if fs.scheduler != nil {
+				fs.scheduler.Stop()
+			}
+			wg.Add(1)
+			go func(i int, fs *fileServer) {
+				defer wg.Done()
+				reports[i] = fs.server.Shutdown(ctx)
+				d.logger.Printf("%s: %s", fs.listener.name, reports[i])
+			}(i, fs)
+		}
+		wg.Wait()
+
+		for _, server := range d.admins {
+			if graceful, ok := server.(gracefulServer); ok {
+				graceful.Shutdown(ctx)
+			} else {
+				server.Stop()
+			}
+		}
+
+		total := qos.ShutdownReport{}
+		for _, r := range reports {
+			total.IdleClosed += r.IdleClosed
+			total.Drained += r.Drained
+			total.Interrupted += r.Interrupted
+		}
+		d.logger.Printf("Shutdown complete: %s", total)
+		close(d.stopped)
+	})
+}
+
+// startServer start a server added by a reload.
+func (d *daemon) startServer(fs *fileServer) {
+	d.servers[fs.config.Name] = fs
+	if fs.scheduler != nil {
+		go fs.scheduler.Run(scheduleInterval)
+	}
+	go d.serve(fs.listener)
+	for _, server := range d.admins {
+		server.AddServer(fs.config.Name, fs.throttler)
+	}
+}
+
+// stopServer stop accepting connections by a server removed by a reload.
+// Connections that were already accepted are served until they are closed, or drained on shutdown.
+func (d *daemon) stopServer(fs *fileServer) {
+	for _, server := range d.admins {
+		server.RemoveServer(fs.config.Name)
+	}
+	if fs.scheduler != nil {
+		fs.scheduler.Stop()
+	}
+	fs.listener.removed = true
+	if err := fs.server.Stop(); err != nil {
+		d.logger.Printf("%s: %s", fs.listener.name, err)
+	}
+	delete(d.servers, fs.config.Name)
+	d.draining = append(d.draining, fs)
+}
+
+// updateServer apply a new configuration to a running server and report what has changed.
+func (d *daemon) updateServer(fs *fileServer, config qos.ServerConfig) []qos.ConfigChange {
+	old := fs.config
+	changes := []qos.ConfigChange{}
+	change := func(setting string, oldValue, newValue interface{}, suffix string) {
+		changes = append(changes, qos.ConfigChange{
+			Server:   config.Name,
+			Setting:  setting,
+			OldValue: fmt.Sprint(oldValue),
+			NewValue: fmt.Sprint(newValue) + suffix,
+		})
+	}
+
+	if old.Network != config.Network || old.Address != config.Address {
+		change("address", old.Network+" "+old.Address, config.Network+" "+config.Address, restartRequired)
+	}
+	if old.BaseDir != config.BaseDir {
+		change("base_dir", old.BaseDir, config.BaseDir, restartRequired)
+	}
+	if !reflect.DeepEqual(old.Sandbox, config.Sandbox) && old.BaseDir == config.BaseDir {
+		// Configuration is validated, so the sandbox can be created
+		sandbox, _ := config.NewSandbox()
+		fs.server.SetSandbox(sandbox)
+		change("sandbox", sandboxSummary(old.Sandbox), sandboxSummary(config.Sandbox), "")
+		fs.config.Sandbox = config.Sandbox
+	}
+	if !reflect.DeepEqual(old.TLS, config.TLS) {
+		change("tls", old.TLS != nil, config.TLS != nil, restartRequired)
+	}
+
+	limitChanged := old.Limit != config.Limit
+	enabledChanged := old.ThrottlingEnabled() != config.ThrottlingEnabled()
+	schedulesChanged := !reflect.DeepEqual(old.Schedules, config.Schedules)
+	if limitChanged {
+		change("limit", old.Limit, config.Limit, "")
+	}
+	if enabledChanged {
+		change("enabled", old.ThrottlingEnabled(), config.ThrottlingEnabled(), "")
+	}
+	if schedulesChanged {
+		change("schedules", len(old.Schedules), len(config.Schedules), "")
+	}
+	if limitChanged || enabledChanged || schedulesChanged {
+		d.applyLimits(fs, config)
+	}
+
+	idleTimeout, transferTimeout := config.Timeouts()
+	if old.IdleTimeout != config.IdleTimeout {
+		change("idle_timeout", old.IdleTimeout, config.IdleTimeout, "")
+		fs.server.SetIdleTimeout(idleTimeout)
+	}
+	if old.TransferTimeout != config.TransferTimeout {
+		change("transfer_timeout", old.TransferTimeout, config.TransferTimeout, "")
+		fs.server.SetTransferTimeout(transferTimeout)
+	}
+
+	if old.KeyByHost != config.KeyByHost {
+		change("key_by_host", old.KeyByHost, config.KeyByHost, "")
+		fs.server.SetKeyByHost(config.KeyByHost)
+	}
+	if old.ChecksumAlgorithm() != config.ChecksumAlgorithm() {
+		change("checksum", old.ChecksumAlgorithm(), config.ChecksumAlgorithm(), "")
+		fs.server.SetChecksumAlgorithm(config.ChecksumAlgorithm())
+	}
+	if !reflect.DeepEqual(old.Uploads, config.Uploads) {
+		change("uploads", uploadsSummary(old.Uploads), uploadsSummary(config.Uploads), "")
+		fs.server.SetUploadPolicy(config.Uploads)
+	}
+
+	fs.config.Limit, fs.config.Enabled, fs.config.Schedules = config.Limit, config.Enabled, config.Schedules
+	fs.config.IdleTimeout, fs.config.TransferTimeout = config.IdleTimeout, config.TransferTimeout
+	fs.config.KeyByHost, fs.config.Checksum, fs.config.Uploads = config.KeyByHost, config.Checksum, config.Uploads
+	return changes
+}
+
+// applyLimits set a server's limit and throttling in place and restart its schedules with them as base settings.
+func (d *daemon) applyLimits(fs *fileServer, config qos.ServerConfig) {
+	if fs.scheduler != nil {
+		fs.scheduler.Stop()
+		fs.scheduler = nil
+	}
+	if res := fs.throttler.SetBandwidthLimit(config.Limit); res.Reason != "" {
+		d.logger.Printf("Reload: %s: %s", config.Name, res.Reason)
+	}
+	if config.ThrottlingEnabled() {
+		fs.throttler.Enable()
+	} else {
+		fs.throttler.Disable()
+	}
+	if len(config.Schedules) > 0 {
+		fs.scheduler = qos.NewScheduler(fs.throttler, config.Schedules, d.newLogger("SCHEDULE "+config.Name+" "))
+		go fs.scheduler.Run(scheduleInterval)
+	}
+}
+
+func sandboxSummary(config *qos.SandboxConfig) string {
+	if config == nil {
+		return "default"
+	}
+	symlinks := config.Symlinks
+	if symlinks == "" {
+		symlinks = qos.SymlinksInside
+	}
+	return fmt.Sprintf("symlinks=%s allow=%v deny=%v allow_hidden=%t",
+		symlinks, config.Allow, config.Deny, config.AllowHidden)
+}
+
+func uploadsSummary(policy *qos.UploadPolicy) string {
+	if policy == nil {
+		return "disabled"
+	}
+	overwrite := policy.Overwrite
+	if overwrite == "" {
+		overwrite = qos.OverwriteDeny
+	}
+	return fmt.Sprintf("max_size=%d overwrite=%s min_free_space=%d", policy.MaxSize, overwrite, policy.MinFreeSpace)
+}
+
+func userNames(users []qos.UserConfig) string {
+	names := []string{}
+	for _, u := range users {
+		names = append(names, u.Name+"="+u.Role)
+	}
+	return strings.Join(names, ",")
+}
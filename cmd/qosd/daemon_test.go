@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kolotaev/qos"
+)
+
+const testConfig = `{
+  "log_file": "%s",
+  "servers": [
+    {"name": "srv1", "address": "127.0.0.1:0", "base_dir": ".", "limit": 10},
+    {"name": "srv2", "address": "127.0.0.1:0", "base_dir": ".", "limit": 20}
+  ],
+  "admin": [{"protocol": "tcp", "address": "127.0.0.1:0"}]
+}`
+
+const testReloadedConfig = `{
+  "log_file": "%s",
+  "servers": [
+    {"name": "srv1", "address": "127.0.0.1:1", "base_dir": ".", "limit": 5, "enabled": false},
+    {"name": "srv3", "address": "127.0.0.1:0", "base_dir": ".", "limit": 30}
+  ],
+  "admin": [{"protocol": "tcp", "address": "127.0.0.1:0"}],
+  "users": [{"name": "root", "password": "secret", "role": "admin"}]
+}`
+
+func writeConfig(t *testing.T, path, format, logFile string) {
+	require.NoError(t, ioutil.WriteFile(path, []byte(fmt.Sprintf(format, logFile)), 0600))
+}
+
+func TestDaemon_Reload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "qosd.json")
+	logFile := filepath.Join(dir, "qosd.log")
+	writeConfig(t, path, testConfig, logFile)
+
+	config, err := qos.LoadConfig(path)
+	require.NoError(t, err)
+	d, err := newDaemon(path, config, time.Second)
+	require.NoError(t, err)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- d.run()
+	}()
+	srv1 := d.servers["srv1"].throttler
+
+	changes, err := d.reload()
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+
+	writeConfig(t, path, testReloadedConfig, logFile)
+	changes, err = d.reload()
+	require.NoError(t, err)
+	assert.Equal(t, []qos.ConfigChange{
+		{Server: "srv1", Setting: "address", OldValue: "tcp 127.0.0.1:0", NewValue: "tcp 127.0.0.1:1 (restart required)"},
+		{Server: "srv1", Setting: "limit", OldValue: "10", NewValue: "5"},
+		{Server: "srv1", Setting: "enabled", OldValue: "true", NewValue: "false"},
+		{Server: "srv3", Setting: "server", NewValue: "added"},
+		{Server: "srv2", Setting: "server", OldValue: "running", NewValue: "removed"},
+		{Setting: "users", OldValue: "", NewValue: "root=admin"},
+	}, changes)
+	assert.Equal(t, int64(5), srv1.GetBandwidthLimit())
+	assert.False(t, srv1.IsEnabled())
+	assert.Contains(t, d.servers, "srv3")
+	assert.NotContains(t, d.servers, "srv2")
+
+	// Only the setting that needs a restart is reported again
+	changes, err = d.reload()
+	require.NoError(t, err)
+	assert.Equal(t, []qos.ConfigChange{
+		{Server: "srv1", Setting: "address", OldValue: "tcp 127.0.0.1:0", NewValue: "tcp 127.0.0.1:1 (restart required)"},
+	}, changes)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"servers": []}`), 0600))
+	_, err = d.reload()
+	assert.ErrorIs(t, err, qos.ErrInvalidConfig)
+	assert.Contains(t, d.servers, "srv1")
+
+	// Removed server stops without stopping the daemon
+	select {
+	case err := <-stopped:
+		t.Fatalf("daemon stopped: %s", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDaemon_Shutdown(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "qosd.json")
+	writeConfig(t, path, testConfig, filepath.Join(dir, "qosd.log"))
+
+	config, err := qos.LoadConfig(path)
+	require.NoError(t, err)
+	d, err := newDaemon(path, config, time.Second)
+	require.NoError(t, err)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- d.run()
+	}()
+
+	d.requestShutdown(0)
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon didn't stop")
+	}
+
+	_, err = d.reload()
+	assert.ErrorIs(t, err, qos.ErrShuttingDown)
+}
+
+func TestDaemon_ShutdownDrainsRemovedServers(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "qosd.json")
+	logFile := filepath.Join(dir, "qosd.log")
+	writeConfig(t, path, testConfig, logFile)
+
+	config, err := qos.LoadConfig(path)
+	require.NoError(t, err)
+	d, err := newDaemon(path, config, time.Second)
+	require.NoError(t, err)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- d.run()
+	}()
+
+	srv2 := d.servers["srv2"].throttler
+	require.Eventually(t, func() bool {
+		return srv2.Addr() != nil
+	}, time.Second, 10*time.Millisecond)
+	conn, err := net.Dial("tcp", srv2.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("FILE daemon.go\n"))
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 1))
+	require.NoError(t, err)
+
+	// The transfer of the removed server goes on until the shutdown interrupts it
+	writeConfig(t, path, testReloadedConfig, logFile)
+	_, err = d.reload()
+	require.NoError(t, err)
+	d.requestShutdown(0)
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("daemon didn't stop")
+	}
+	log, err := ioutil.ReadFile(logFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(log),
+		"Shutdown complete: 0 idle connections closed, 0 transfers finished, 1 transfers interrupted")
+}
@@ -0,0 +1,64 @@
+// Command qosd runs file servers and admin servers described by a JSON configuration file:
+//
+//	qosd -config /etc/qosd.json
+//
+// See example/qosd.json for a configuration example. On SIGHUP the configuration file is reloaded.
+// On SIGINT or SIGTERM servers are gracefully stopped, a second signal forces an immediate exit.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/kolotaev/qos"
+)
+
+func main() {
+	configPath := flag.String("config", "qosd.json", "path to the configuration file")
+	checkOnly := flag.Bool("check", false, "only validate the configuration file and exit")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "how long transfers in progress can take on shutdown")
+	flag.Parse()
+
+	config, err := qos.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s: %s\n", *configPath, err)
+		os.Exit(1)
+	}
+	if *checkOnly {
+		fmt.Printf("%s: OK\n", *configPath)
+		return
+	}
+
+	d, err := newDaemon(*configPath, config, *drainTimeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		shuttingDown := false
+		for sig := range signals {
+			switch {
+			case sig == syscall.SIGHUP:
+				if _, err := d.reload(); err != nil {
+					d.logger.Printf("Reload failed, configuration is kept as is: %s", err)
+				}
+			case shuttingDown:
+				d.logger.Fatalf("Got %s while shutting down, exiting immediately", sig)
+			default:
+				shuttingDown = true
+				d.requestShutdown(0)
+			}
+		}
+	}()
+
+	if err := d.run(); err != nil {
+		d.logger.Fatal(err)
+	}
+}
@@ -0,0 +1,206 @@
+package qos
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// Temporary disk space compressed framed responses and archives can use at once by default
+const defaultCompressionSpace = 256 << 20
+
+// errNotSmaller compressed contents are not smaller than the original ones
+var errNotSmaller = errors.New("compressed contents are not smaller")
+
+// errNoTempSpace the temporary space is used up
+var errNoTempSpace = errors.New("temporary space is used up")
+
+// ContentEncoding a compression of file contents negotiated per FILE request
+type ContentEncoding string
+
+// Content encodings
+const (
+	EncodingGzip    ContentEncoding = "gzip"
+	EncodingDeflate ContentEncoding = "deflate" // zlib format, as `deflate` of HTTP
+)
+
+// Validate is the encoding supported?
+func (e ContentEncoding) Validate() error {
+	if e != EncodingGzip && e != EncodingDeflate {
+		return fmt.Errorf("%w: unknown encoding `%s`, want gzip or deflate", ErrBadArgument, e)
+	}
+	return nil
+}
+
+// newWriter get a writer compressing to w, it must be closed to flush the compressed data.
+func (e ContentEncoding) newWriter(w io.Writer) (io.WriteCloser, error) {
+	switch e {
+	case EncodingGzip:
+		return gzip.NewWriter(w), nil
+	case EncodingDeflate:
+		return zlib.NewWriter(w), nil
+	}
+	return nil, e.Validate()
+}
+
+// newReader get a reader decompressing r.
+func (e ContentEncoding) newReader(r io.Reader) (io.ReadCloser, error) {
+	switch e {
+	case EncodingGzip:
+		return gzip.NewReader(r)
+	case EncodingDeflate:
+		return zlib.NewReader(r)
+	}
+	return nil, e.Validate()
+}
+
+// tempSpace temporary disk space shared by compressed responses and archives, so parallel requests can't fill the disk
+type tempSpace struct {
+	mu    *sync.Mutex
+	limit int64
+	used  int64
+}
+
+func newTempSpace(limit int64) *tempSpace {
+	return &tempSpace{mu: new(sync.Mutex), limit: limit}
+}
+
+func (t *tempSpace) setLimit(limit int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.limit = limit
+}
+
+// reserve take n bytes of the space, returns false if there is not enough of it.
+func (t *tempSpace) reserve(n int64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.used+n > t.limit {
+		return false
+	}
+	t.used += n
+	return true
+}
+
+func (t *tempSpace) release(n int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.used -= n
+}
+
+// encodedFile compressed contents or an archive in a temporary file, it must be removed with remove.
+type encodedFile struct {
+	*os.File
+	size     int64
+	space    *tempSpace
+	reserved int64
+}
+
+func (f *encodedFile) remove() {
+	removeTemp(f.File)
+	f.space.release(f.reserved)
+}
+
+// compressRange compress length bytes of src starting at offset into a temporary file, so the compressed size
+// is known before it's sent. Compression stops as soon as the contents don't get smaller, so at most length bytes
+// of the space are taken. Returns errNotSmaller then, or if the space is used up by other responses.
+func (t *tempSpace) compressRange(src io.ReaderAt, offset, length int64, encoding ContentEncoding) (*encodedFile, error) {
+	if !t.reserve(length) {
+		return nil, errNotSmaller
+	}
+	temp, err := ioutil.TempFile("", "qos-encoded-*")
+	if err != nil {
+		t.release(length)
+		return nil, err
+	}
+	size, err := compressTo(&limitedWriter{w: temp, n: length}, io.NewSectionReader(src, offset, length), encoding)
+	if err != nil {
+		removeTemp(temp)
+		t.release(length)
+		return nil, err
+	}
+	return &encodedFile{File: temp, size: size, space: t, reserved: length}, nil
+}
+
+// compressStream compress length bytes of src starting at offset on the fly, as long as the returned reader is read.
+// The reader must be closed when the transfer stops, then wait waits until compression stops.
+func compressStream(src io.ReaderAt, offset, length int64, encoding ContentEncoding) (r *io.PipeReader, wait func()) {
+	r, w := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := compressTo(w, io.NewSectionReader(src, offset, length), encoding)
+		w.CloseWithError(err)
+	}()
+	return r, func() { <-done }
+}
+
+func compressTo(w io.Writer, r io.Reader, encoding ContentEncoding) (int64, error) {
+	counter := &countingWriter{w: w}
+	encoder, err := encoding.newWriter(counter)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := io.Copy(encoder, r); err != nil {
+		return 0, err
+	}
+	if err := encoder.Close(); err != nil {
+		return 0, err
+	}
+	return counter.n, nil
+}
+
+func removeTemp(file *os.File) {
+	file.Close()
+	os.Remove(file.Name())
+}
+
+// limitedWriter fails with errNotSmaller once n bytes or more would be written through it
+type limitedWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) >= w.n {
+		return 0, errNotSmaller
+	}
+	n, err := w.w.Write(p)
+	w.n -= int64(n)
+	return n, err
+}
+
+// spaceWriter takes the space for bytes written through it, failing with errNoTempSpace once it's used up.
+// The taken bytes must be released.
+type spaceWriter struct {
+	w     io.Writer
+	space *tempSpace
+	n     int64
+}
+
+func (w *spaceWriter) Write(p []byte) (int, error) {
+	if !w.space.reserve(int64(len(p))) {
+		return 0, errNoTempSpace
+	}
+	n, err := w.w.Write(p)
+	w.space.release(int64(len(p) - n))
+	w.n += int64(n)
+	return n, err
+}
+
+// countingWriter counts bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,342 @@
+package qos
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrInvalidConfig configuration can't be used to run servers
+var ErrInvalidConfig = errors.New("invalid config")
+
+// Config configuration of file servers, admin servers and their users, e.g. for a daemon.
+type Config struct {
+	LogFile  string         `json:"log_file,omitempty"`
+	AuditLog string         `json:"audit_log,omitempty"`
+	Servers  []ServerConfig `json:"servers"`
+	Admin    []AdminConfig  `json:"admin"`
+	Users    []UserConfig   `json:"users,omitempty"`
+}
+
+// ServerConfig configuration of a file server and its Throttler.
+type ServerConfig struct {
+	Name      string     `json:"name"`
+	Network   string     `json:"network,omitempty"`
+	Address   string     `json:"address"`
+	BaseDir   string     `json:"base_dir"`
+	Limit     int64      `json:"limit"`
+	Enabled   *bool      `json:"enabled,omitempty"`
+	TLS       *TLSFiles  `json:"tls,omitempty"`
+	Schedules []Schedule `json:"schedules,omitempty"`
+	// Durations like "5m", see TCPFileServer.SetIdleTimeout and TCPFileServer.SetTransferTimeout
+	IdleTimeout     string         `json:"idle_timeout,omitempty"`
+	TransferTimeout string         `json:"transfer_timeout,omitempty"`
+	Sandbox         *SandboxConfig `json:"sandbox,omitempty"`
+	// Account connections of a host against a single limit, off by default, see TCPFileServer.SetKeyByHost
+	KeyByHost bool `json:"key_by_host,omitempty"`
+	// Algorithm of checksums of served files, sha256 if it's empty
+	Checksum ChecksumAlgorithm `json:"checksum,omitempty"`
+	// Uploads with PUT command, they are disabled if it's not set
+	Uploads *UploadPolicy `json:"uploads,omitempty"`
+}
+
+// SandboxConfig restrictions of files a server can serve from its base directory, see Sandbox.
+type SandboxConfig struct {
+	Symlinks    SymlinkPolicy `json:"symlinks,omitempty"`
+	Allow       []string      `json:"allow,omitempty"`
+	Deny        []string      `json:"deny,omitempty"`
+	AllowHidden bool          `json:"allow_hidden,omitempty"`
+}
+
+// ThrottlingEnabled is throttling enabled for the server? It's enabled unless explicitly disabled.
+func (s ServerConfig) ThrottlingEnabled() bool {
+	return s.Enabled == nil || *s.Enabled
+}
+
+// NewSandbox get a sandbox of the server's base directory.
+func (s ServerConfig) NewSandbox() (*Sandbox, error) {
+	sandbox := NewSandbox(s.BaseDir)
+	if s.Sandbox == nil {
+		return sandbox, nil
+	}
+	if s.Sandbox.Symlinks != "" {
+		if err := sandbox.SetSymlinkPolicy(s.Sandbox.Symlinks); err != nil {
+			return nil, err
+		}
+	}
+	if err := sandbox.SetAllowList(s.Sandbox.Allow...); err != nil {
+		return nil, err
+	}
+	if err := sandbox.SetDenyList(s.Sandbox.Deny...); err != nil {
+		return nil, err
+	}
+	sandbox.AllowHidden(s.Sandbox.AllowHidden)
+	return sandbox, nil
+}
+
+// ChecksumAlgorithm get the algorithm of checksums of served files.
+func (s ServerConfig) ChecksumAlgorithm() ChecksumAlgorithm {
+	if s.Checksum == "" {
+		return ChecksumSHA256
+	}
+	return s.Checksum
+}
+
+// Timeouts get idle and transfer timeouts of the server, zero if they are not set.
+func (s ServerConfig) Timeouts() (idle, transfer time.Duration) {
+	idle, _ = parseTimeout(s.IdleTimeout)
+	transfer, _ = parseTimeout(s.TransferTimeout)
+	return idle, transfer
+}
+
+func parseTimeout(timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("must be a positive duration, e.g. 30s, got `%s`", timeout)
+	}
+	return d, nil
+}
+
+// AdminConfig configuration of an admin server listener.
+type AdminConfig struct {
+	Protocol string    `json:"protocol"`
+	Network  string    `json:"network,omitempty"`
+	Address  string    `json:"address"`
+	TLS      *TLSFiles `json:"tls,omitempty"`
+}
+
+// TLSFiles PEM encoded certificate files of a TLS listener, see NewTLSConfig.
+type TLSFiles struct {
+	CertFile     string `json:"cert_file"`
+	KeyFile      string `json:"key_file"`
+	ClientCAFile string `json:"client_ca_file,omitempty"`
+}
+
+// UserConfig admin user and its role: viewer, operator or admin.
+// Password is taken from PasswordEnv environment variable if it's set, so it can be kept out of the file.
+type UserConfig struct {
+	Name        string   `json:"name"`
+	Password    string   `json:"password,omitempty"`
+	PasswordEnv string   `json:"password_env,omitempty"`
+	Role        string   `json:"role"`
+	Servers     []string `json:"servers,omitempty"`
+}
+
+// Admin server protocols
+var adminProtocols = map[string]bool{
+	"tcp":  true,
+	"http": true,
+}
+
+// Role constructors by names used in configuration
+var namedRoles = map[string]func(servers ...string) *Role{
+	"viewer":   NewViewerRole,
+	"operator": NewOperatorRole,
+	"admin":    NewAdminRole,
+}
+
+// LoadConfig read and validate a JSON configuration file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %s", err)
+	}
+	return ParseConfig(data)
+}
+
+// ParseConfig parse and validate a JSON configuration.
+// Unknown fields are rejected, so typos don't go unnoticed.
+func ParseConfig(data []byte) (*Config, error) {
+	c := &Config{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return nil, fmt.Errorf("%w: line %d: %s", ErrInvalidConfig, lineOf(data, syntaxErr.Offset), err)
+		}
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return nil, fmt.Errorf("%w: line %d: %s must be %s, got %s",
+				ErrInvalidConfig, lineOf(data, typeErr.Offset), typeErr.Field, typeErr.Type, typeErr.Value)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrInvalidConfig, err)
+	}
+
+	for i := range c.Servers {
+		if c.Servers[i].Network == "" {
+			c.Servers[i].Network = "tcp"
+		}
+	}
+	for i := range c.Admin {
+		if c.Admin[i].Network == "" {
+			c.Admin[i].Network = "tcp"
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func lineOf(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// Validate check the configuration and report all the found problems at once.
+func (c *Config) Validate() error {
+	problems := []string{}
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if len(c.Servers) == 0 {
+		problem("no servers are configured")
+	}
+	names := make(map[string]bool)
+	for i, s := range c.Servers {
+		where := fmt.Sprintf("servers[%d]", i)
+		if s.Name != "" {
+			where += fmt.Sprintf(" (%s)", s.Name)
+		}
+		switch {
+		case s.Name == "":
+			problem("%s: name is required", where)
+		case names[s.Name]:
+			problem("%s: duplicate name", where)
+		}
+		names[s.Name] = true
+		if s.Address == "" {
+			problem("%s: address is required", where)
+		}
+		if s.Limit <= 0 {
+			problem("%s: limit must be positive, got %d", where, s.Limit)
+		}
+		if info, err := os.Stat(s.BaseDir); err != nil || !info.IsDir() {
+			problem("%s: base_dir `%s` is not a directory", where, s.BaseDir)
+		}
+		if s.TLS != nil {
+			if err := s.TLS.validate(); err != nil {
+				problem("%s: tls: %s", where, err)
+			}
+		}
+		if _, err := s.NewSandbox(); err != nil {
+			problem("%s: sandbox: %s", where, err)
+		}
+		if _, err := parseTimeout(s.IdleTimeout); err != nil {
+			problem("%s: idle_timeout %s", where, err)
+		}
+		if _, err := parseTimeout(s.TransferTimeout); err != nil {
+			problem("%s: transfer_timeout %s", where, err)
+		}
+		if s.Checksum != "" {
+			if err := s.Checksum.Validate(); err != nil {
+				problem("%s: checksum: %s", where, err)
+			}
+		}
+		if s.Uploads != nil {
+			if _, err := s.Uploads.validate(); err != nil {
+				problem("%s: uploads: %s", where, err)
+			}
+		}
+		for j, schedule := range s.Schedules {
+			if err := schedule.Validate(); err != nil {
+				problem("%s: schedules[%d]: %s", where, j, err)
+			}
+		}
+	}
+
+	if len(c.Admin) == 0 {
+		problem("no admin servers are configured")
+	}
+	for i, a := range c.Admin {
+		where := fmt.Sprintf("admin[%d]", i)
+		if !adminProtocols[a.Protocol] {
+			problem("%s: protocol must be tcp or http, got `%s`", where, a.Protocol)
+		}
+		if a.Address == "" {
+			problem("%s: address is required", where)
+		}
+		if a.TLS != nil {
+			if err := a.TLS.validate(); err != nil {
+				problem("%s: tls: %s", where, err)
+			}
+		}
+	}
+
+	users := make(map[string]bool)
+	for i, u := range c.Users {
+		where := fmt.Sprintf("users[%d]", i)
+		if u.Name != "" {
+			where += fmt.Sprintf(" (%s)", u.Name)
+		}
+		switch {
+		case u.Name == "":
+			problem("%s: name is required", where)
+		case users[u.Name]:
+			problem("%s: duplicate name", where)
+		}
+		users[u.Name] = true
+		if _, ok := namedRoles[u.Role]; !ok {
+			problem("%s: role must be viewer, operator or admin, got `%s`", where, u.Role)
+		}
+		if u.password() == "" {
+			problem("%s: password is empty", where)
+		}
+		for _, name := range u.Servers {
+			if !names[name] {
+				problem("%s: unknown server `%s`", where, name)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidConfig, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// AccessControl build access control of the configured users.
+// Returns nil if no users are configured, so admin servers are open.
+func (c *Config) AccessControl() *AccessControl {
+	if len(c.Users) == 0 {
+		return nil
+	}
+	ac := NewAccessControl()
+	for _, u := range c.Users {
+		ac.AddUser(u.Name, u.password(), namedRoles[u.Role](u.Servers...))
+	}
+	return ac
+}
+
+func (u UserConfig) password() string {
+	if u.PasswordEnv != "" {
+		return os.Getenv(u.PasswordEnv)
+	}
+	return u.Password
+}
+
+// Config build a server TLS configuration.
+func (f *TLSFiles) Config() (*tls.Config, error) {
+	return NewTLSConfig(f.CertFile, f.KeyFile, f.ClientCAFile)
+}
+
+func (f *TLSFiles) validate() error {
+	if f.CertFile == "" || f.KeyFile == "" {
+		return errors.New("cert_file and key_file are required")
+	}
+	_, err := f.Config()
+	return err
+}
@@ -0,0 +1,96 @@
+package qos_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kolotaev/qos"
+)
+
+func TestParseConfig(t *testing.T) {
+	dir := t.TempDir()
+
+	c, err := qos.ParseConfig([]byte(`{
+		"servers": [
+			{"name": "srv1", "address": ":3000", "base_dir": "` + dir + `", "limit": 10, "idle_timeout": "5m"},
+			{"name": "srv2", "address": ":4000", "base_dir": "` + dir + `", "limit": 20, "enabled": false,
+			 "schedules": [{"from": "09:00", "to": "18:00", "limit": 5}]}
+		],
+		"admin": [{"protocol": "tcp", "network": "tcp4", "address": ":5000"}],
+		"users": [{"name": "root", "password": "pass", "role": "admin"}]
+	}`))
+	require.NoError(t, err)
+	assert.Equal(t, "tcp", c.Servers[0].Network)
+	assert.True(t, c.Servers[0].ThrottlingEnabled())
+	assert.False(t, c.Servers[1].ThrottlingEnabled())
+	assert.Equal(t, []qos.Schedule{{From: "09:00", To: "18:00", Limit: 5}}, c.Servers[1].Schedules)
+	assert.Equal(t, "tcp4", c.Admin[0].Network)
+	idle, transfer := c.Servers[0].Timeouts()
+	assert.Equal(t, 5*time.Minute, idle)
+	assert.Equal(t, time.Duration(0), transfer)
+
+	role, err := c.AccessControl().Authenticate("root", "pass")
+	require.NoError(t, err)
+	assert.Equal(t, "admin", role.Name)
+}
+
+func TestParseConfig_Invalid(t *testing.T) {
+	cases := []struct {
+		config   string
+		expected string
+	}{
+		{"{\n\"servers\": [\n}", "invalid config: line 3: invalid character '}' looking for beginning of value"},
+		{`{"server": []}`, `invalid config: json: unknown field "server"`},
+		{`{"servers": [
+			{"name": "srv1", "address": ":3000", "base_dir": "/nonexistent", "limit": 0,
+			 "schedules": [{"from": "9am", "to": "18:00", "limit": 5}]},
+			{"name": "srv1", "base_dir": ".", "limit": 10, "idle_timeout": "5", "transfer_timeout": "-1m",
+			 "checksum": "md5", "uploads": {"overwrite": "append"}, "sandbox": {"symlinks": "never", "deny": ["[a-"]},
+			 "schedules": [{"from": "09:00", "to": "18:00", "enabled": true}]}
+		 ],
+		 "admin": [{"protocol": "ftp", "address": ":5000"}],
+		 "users": [{"name": "bob", "role": "root", "servers": ["srv3"]}]}`,
+			"invalid config: " +
+				"servers[0] (srv1): limit must be positive, got 0; " +
+				"servers[0] (srv1): base_dir `/nonexistent` is not a directory; " +
+				"servers[0] (srv1): schedules[0]: bad `from` time: `9am` is not in HH:MM format; " +
+				"servers[1] (srv1): duplicate name; " +
+				"servers[1] (srv1): address is required; " +
+				"servers[1] (srv1): sandbox: bad argument: unknown symlink policy `never`, want inside, deny or follow; " +
+				"servers[1] (srv1): idle_timeout must be a positive duration, e.g. 30s, got `5`; " +
+				"servers[1] (srv1): transfer_timeout must be a positive duration, e.g. 30s, got `-1m`; " +
+				"servers[1] (srv1): checksum: bad argument: unknown checksum algorithm `md5`, want sha256 or crc32c; " +
+				"servers[1] (srv1): uploads: bad argument: unknown overwrite policy `append`, want deny or replace; " +
+				"servers[1] (srv1): schedules[0]: limit must be positive, got 0; " +
+				"admin[0]: protocol must be tcp or http, got `ftp`; " +
+				"users[0] (bob): role must be viewer, operator or admin, got `root`; " +
+				"users[0] (bob): password is empty; " +
+				"users[0] (bob): unknown server `srv3`"},
+	}
+	for _, tc := range cases {
+		_, err := qos.ParseConfig([]byte(tc.config))
+		assert.ErrorIs(t, err, qos.ErrInvalidConfig)
+		assert.EqualError(t, err, tc.expected)
+	}
+
+	_, err := qos.ParseConfig([]byte(`{"servers": [{"name": "srv1", "limit": "10"}]}`))
+	assert.ErrorIs(t, err, qos.ErrInvalidConfig)
+	assert.Contains(t, err.Error(), "limit must be int64, got string")
+}
+
+func TestLoadConfig_Example(t *testing.T) {
+	os.Setenv("QOSD_ROOT_PASSWORD", "s3cret")
+	defer os.Unsetenv("QOSD_ROOT_PASSWORD")
+
+	c, err := qos.LoadConfig(filepath.Join("example", "qosd.json"))
+	require.NoError(t, err)
+	assert.Len(t, c.Servers, 2)
+	assert.Len(t, c.Admin, 2)
+	assert.False(t, c.Servers[0].KeyByHost)
+	assert.True(t, c.Servers[1].KeyByHost)
+}
@@ -1,6 +1,7 @@
 package qos
 
 import (
+	"sort"
 	"sync"
 )
 
@@ -30,6 +31,21 @@ func NewDatabase() *Database {
 	}
 }
 
+// Clone get a copy of the database that can be changed independently.
+func (d *Database) Clone() *Database {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	c := NewDatabase()
+	for k, v := range d.connections {
+		record := *v
+		c.connections[k] = &record
+	}
+	c.individualLimitCount = d.individualLimitCount
+	c.activeConnCount = d.activeConnCount
+	return c
+}
+
 // Activate upsert a connection in an active state
 func (d *Database) Activate(connectionKey string) {
 	d.mu.Lock()
@@ -46,6 +62,9 @@ func (d *Database) Activate(connectionKey string) {
 	if !d.connections[connectionKey].Active {
 		d.connections[connectionKey].Active = true
 		d.activeConnCount++
+		if d.connections[connectionKey].HasIndividualLimit {
+			d.individualLimitCount++
+		}
 	}
 }
 
@@ -54,8 +73,8 @@ func (d *Database) Deactivate(connectionKey string) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	// Already unregistered
-	if !d.connections[connectionKey].Active {
+	// Already unregistered or never registered
+	if c, ok := d.connections[connectionKey]; !ok || !c.Active {
 		return
 	}
 
@@ -73,6 +92,19 @@ func (d *Database) Get(connectionKey string) *ConnectionRecord {
 	return d.connections[connectionKey]
 }
 
+// Keys get keys of all known connections in a sorted order.
+func (d *Database) Keys() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	keys := make([]string, 0, len(d.connections))
+	for k := range d.connections {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // UpdateIndividualLimits sets individual limits for all connection that already have individual limits.
 func (d *Database) UpdateIndividualLimits(limit int64) {
 	d.mu.Lock()
@@ -89,9 +121,54 @@ func (d *Database) UpdateIndividualLimits(limit int64) {
 func (d *Database) SetLimit(limit int64, connectionKey string) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	d.connections[connectionKey].Limit = limit
-	d.connections[connectionKey].HasIndividualLimit = true
-	d.individualLimitCount++
+	c := d.connections[connectionKey]
+	c.Limit = limit
+	if c.Active && !c.HasIndividualLimit {
+		d.individualLimitCount++
+	}
+	c.HasIndividualLimit = true
+}
+
+// IndividualLimits get individual limits of all connections that have them, active or not.
+func (d *Database) IndividualLimits() map[string]int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	limits := make(map[string]int64)
+	for k, v := range d.connections {
+		if v.HasIndividualLimit {
+			limits[k] = v.Limit
+		}
+	}
+	return limits
+}
+
+// ReplaceIndividualLimits replace individual limits of all connections with the given ones.
+// Returns a sum of individual limits of active connections.
+func (d *Database) ReplaceIndividualLimits(limits map[string]int64) int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for k, limit := range limits {
+		if _, exists := d.connections[k]; !exists {
+			d.connections[k] = &ConnectionRecord{}
+		}
+		d.connections[k].Limit = limit
+	}
+
+	activeLimitsSum := int64(0)
+	d.individualLimitCount = 0
+	for k, v := range d.connections {
+		_, v.HasIndividualLimit = limits[k]
+		if !v.HasIndividualLimit {
+			v.Limit = 0
+		}
+		if v.Active && v.HasIndividualLimit {
+			d.individualLimitCount++
+			activeLimitsSum += v.Limit
+		}
+	}
+	return activeLimitsSum
 }
 
 // CountActiveConnections get a number of active connections.
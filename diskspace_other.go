@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package qos
+
+// freeSpace free space is unknown on this platform, so disk space isn't checked before uploads.
+func freeSpace(dir string) (int64, error) {
+	return 0, errFreeSpaceUnknown
+}
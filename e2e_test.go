@@ -61,7 +61,7 @@ func TestEnd2End(t *testing.T) {
 	fsClient.Write([]byte("FILE qq.txt\n"))
 	res, err = fsClientReader.ReadString('\n')
 	assert.NoError(t, err)
-	assert.Contains(t, res, "example/files/qq.txt: no such file or directory")
+	assert.Equal(t, "Error: lstat qq.txt: no such file or directory\n", res)
 
 	fmt.Println("Test case #3. Try to read file within approximately 7 seconds.")
 	start := time.Now()
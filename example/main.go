@@ -1,23 +1,44 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"os/signal"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/kolotaev/qos"
 )
 
+// How long transfers in progress can take on shutdown unless SHUTDOWN command sets it
+const drainTimeout = 30 * time.Second
+
 func main() {
 	orchestarator := new(sync.WaitGroup)
+	logger := log.New(os.Stdout, "MAIN ", log.LstdFlags)
 	baseDir := "./example/files"
 	throttlers := map[string]*qos.Throttler{
 		"srv1": qos.NewThrottler(10, true),
 		"srv2": qos.NewThrottler(20, true),
+		"http": qos.NewThrottler(10, true),
 	}
 	fileServer1 := qos.NewTCPFileServer(throttlers["srv1"], baseDir, log.New(os.Stdout, "FILE SRV #1 ", log.LstdFlags))
 	fileServer2 := qos.NewTCPFileServer(throttlers["srv2"], baseDir, log.New(os.Stdout, "FILE SRV #2 ", log.LstdFlags))
+	httpFileServer := qos.NewHTTPFileServer(throttlers["http"], baseDir, log.New(os.Stdout, "HTTP FILE SRV ", log.LstdFlags))
 	adminServer := qos.NewTCPAdminServer(throttlers, log.New(os.Stdout, "ADMIN SRV ", log.LstdFlags))
+	httpAdminServer := qos.NewHTTPAdminServer(throttlers, log.New(os.Stdout, "HTTP ADMIN SRV ", log.LstdFlags))
+
+	shutdownRequests := make(chan time.Duration, 1)
+	requestShutdown := func(timeout time.Duration) {
+		select {
+		case shutdownRequests <- timeout:
+		default:
+		}
+	}
+	adminServer.SetShutdownHandler(requestShutdown)
+	httpAdminServer.SetShutdownHandler(requestShutdown)
 
 	orchestarator.Add(1)
 	go func() {
@@ -31,11 +52,62 @@ func main() {
 		orchestarator.Done()
 	}()
 
+	orchestarator.Add(1)
+	go func() {
+		httpFileServer.Serve("tcp4", ":3001")
+		orchestarator.Done()
+	}()
+
 	orchestarator.Add(1)
 	go func() {
 		adminServer.Serve("tcp4", ":5000")
 		orchestarator.Done()
 	}()
 
+	orchestarator.Add(1)
+	go func() {
+		httpAdminServer.Serve("tcp4", ":5001")
+		orchestarator.Done()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		logger.Printf("Got %s", sig)
+		requestShutdown(0)
+		sig = <-signals
+		logger.Fatalf("Got %s while shutting down, exiting immediately", sig)
+	}()
+
+	timeout := <-shutdownRequests
+	if timeout == 0 {
+		timeout = drainTimeout
+	}
+	logger.Printf("Shutting down, transfers in progress are given %s to finish", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	reports := make([]qos.ShutdownReport, 2)
+	drain := new(sync.WaitGroup)
+	for i, s := range []*qos.TCPFileServer{fileServer1, fileServer2} {
+		drain.Add(1)
+		go func(i int, s *qos.TCPFileServer) {
+			reports[i] = s.Shutdown(ctx)
+			drain.Done()
+		}(i, s)
+	}
+	drain.Add(1)
+	go func() {
+		httpFileServer.Shutdown(ctx)
+		drain.Done()
+	}()
+	drain.Wait()
+	adminServer.Stop()
+	httpAdminServer.Shutdown(ctx)
 	orchestarator.Wait()
+
+	for i, r := range reports {
+		logger.Printf("FILE SRV #%d: %s", i+1, r)
+	}
 }
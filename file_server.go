@@ -3,43 +3,286 @@ package qos
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"net"
-	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-// TCPFileServer server for serving files over TCP from a base directory.
+// How long a client is given to receive a notice of why its transfer or connection is cancelled
+const noticeTimeout = time.Second
+
+// ErrShuttingDown server doesn't accept new requests because it is shutting down
+var ErrShuttingDown = errors.New("server is shutting down")
+
+// ErrUnsupportedFileCommand a command of the admin server was sent to a file server
+var ErrUnsupportedFileCommand = errors.New("command is not supported by file server")
+
+// ShutdownReport outcome of a graceful shutdown of a TCPFileServer.
+type ShutdownReport struct {
+	IdleClosed  int `json:"idle_closed"` // connections that were waiting for a command
+	Drained     int `json:"drained"`     // transfers that finished before the deadline
+	Interrupted int `json:"interrupted"` // transfers that were cut at the deadline
+}
+
+func (r ShutdownReport) String() string {
+	return fmt.Sprintf("%d idle connections closed, %d transfers finished, %d transfers interrupted",
+		r.IdleClosed, r.Drained, r.Interrupted)
+}
+
+// TCPFileServer server for serving files over TCP from a base directory or any other file system.
+// Requested file names are resolved with a Sandbox of the file system, see SetSandbox.
 type TCPFileServer struct {
-	throttler     *Throttler
-	baseDirectory string
-	logger        *log.Logger
+	throttler       *Throttler
+	sandbox         *Sandbox
+	logger          *log.Logger
+	ctx             *reasonContext // cancelled to abort transfers when shutdown deadline is reached
+	mu              *sync.Mutex
+	conns           map[net.Conn]*connectionState // connections being handled
+	handlers        *sync.WaitGroup
+	shuttingDown    bool
+	idleTimeout     time.Duration
+	transferTimeout time.Duration
+	keyByHost       bool
+	keys            map[string]int // connections sharing throttler keys
+	checksum        ChecksumAlgorithm
+	checksums       *checksumCache
+	uploads         *UploadPolicy // uploads are disabled if it's nil
+	compression     *tempSpace    // temporary disk space of compressed framed responses
+}
+
+// connectionState a connection being handled
+type connectionState struct {
+	ctx  *reasonContext // cancelled to abort the connection's transfer and close it
+	busy bool           // is a file being sent?
 }
 
 // NewTCPFileServer TCPFileServer ctor
 func NewTCPFileServer(throttler *Throttler, baseDirectory string, logger *log.Logger) *TCPFileServer {
+	return newTCPFileServer(throttler, NewSandbox(baseDirectory), logger)
+}
+
+// NewTCPFileServerFS TCPFileServer ctor of a file system other than a directory,
+// e.g. embedded assets, a zip archive or fstest.MapFS. Files can't be uploaded to it.
+func NewTCPFileServerFS(throttler *Throttler, fsys fs.FS, logger *log.Logger) *TCPFileServer {
+	return newTCPFileServer(throttler, NewSandboxFS(fsys), logger)
+}
+
+func newTCPFileServer(throttler *Throttler, sandbox *Sandbox, logger *log.Logger) *TCPFileServer {
 	return &TCPFileServer{
-		throttler:     throttler,
-		baseDirectory: baseDirectory,
-		logger:        logger,
+		throttler:   throttler,
+		sandbox:     sandbox,
+		logger:      logger,
+		ctx:         withReason(context.Background()),
+		mu:          new(sync.Mutex),
+		conns:       make(map[net.Conn]*connectionState),
+		handlers:    new(sync.WaitGroup),
+		keys:        make(map[string]int),
+		checksum:    ChecksumSHA256,
+		checksums:   newChecksumCache(),
+		compression: newTempSpace(defaultCompressionSpace),
+	}
+}
+
+// SetSandbox restrict files that can be served with a sandbox other than the default one of the file system.
+// The sandbox's file system is served from then on.
+func (s *TCPFileServer) SetSandbox(sandbox *Sandbox) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sandbox = sandbox
+}
+
+func (s *TCPFileServer) getSandbox() *Sandbox {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sandbox
+}
+
+// SetIdleTimeout close connections that don't send a command for longer than timeout. Zero means no timeout.
+func (s *TCPFileServer) SetIdleTimeout(timeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.idleTimeout = timeout
+}
+
+// SetTransferTimeout cancel transfers that take longer than timeout, connections are kept. Zero means no timeout.
+func (s *TCPFileServer) SetTransferTimeout(timeout time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transferTimeout = timeout
+}
+
+func (s *TCPFileServer) timeouts() (idle, transfer time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.idleTimeout, s.transferTimeout
+}
+
+// transferContext get a context of a connection's transfer, it's done when the transfer timeout expires.
+// Writes to conn are interrupted once it's done. cancel must be called once the transfer ends.
+func (s *TCPFileServer) transferContext(ctx *reasonContext, conn net.Conn) (context.Context, context.CancelFunc) {
+	var transferCtx context.Context
+	var cancel context.CancelFunc
+	if _, timeout := s.timeouts(); timeout > 0 {
+		transferCtx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		transferCtx, cancel = context.WithCancel(ctx)
+	}
+	stop := expireWrites(transferCtx, conn)
+	return transferCtx, func() {
+		cancel()
+		stop()
+	}
+}
+
+// SetKeyByHost account connections of a host against a single bandwidth limit instead of limits per connection,
+// so parallel downloads of a client don't get more bandwidth. The host is then used as the connection's address
+// in CLIMIT and CLIST. Only new connections are affected.
+// It's off by default, as clients behind a shared NAT or proxy have a single address and would share a single limit.
+func (s *TCPFileServer) SetKeyByHost(byHost bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keyByHost = byHost
+}
+
+// SetChecksumAlgorithm set the algorithm of checksums in framed FILE responses and SUM replies, sha256 by default.
+func (s *TCPFileServer) SetChecksumAlgorithm(algorithm ChecksumAlgorithm) error {
+	if err := algorithm.Validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checksum = algorithm
+	return nil
+}
+
+func (s *TCPFileServer) getChecksumAlgorithm() ChecksumAlgorithm {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.checksum
+}
+
+// SetCompressionSpace limit temporary disk space that compressed framed FILE responses and framed BATCH archives
+// can use at once, 256 MiB by default. Framed responses need their size up front, so their contents are written
+// to temporary files before they are sent. Contents that don't fit are sent uncompressed, while archives fail.
+func (s *TCPFileServer) SetCompressionSpace(limit int64) {
+	s.compression.setLimit(limit)
+}
+
+// SetUploadPolicy allow clients to upload files with PUT command following the policy, nil disables uploads.
+// Uploads are disabled by default.
+func (s *TCPFileServer) SetUploadPolicy(policy *UploadPolicy) error {
+	if policy != nil {
+		validated, err := policy.validate()
+		if err != nil {
+			return err
+		}
+		policy = &validated
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploads = policy
+	return nil
+}
+
+func (s *TCPFileServer) getUploadPolicy() *UploadPolicy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.uploads
+}
+
+// acquireKey get a key a connection is throttled with.
+func (s *TCPFileServer) acquireKey(connectionAddress string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := connectionAddress
+	if host, _, err := net.SplitHostPort(connectionAddress); err == nil && s.keyByHost {
+		key = host
+	}
+	s.keys[key]++
+	return key
+}
+
+// releaseKey unregister a connection, and its key once no other connections use it.
+func (s *TCPFileServer) releaseKey(connectionKey, connectionAddress string) {
+	s.mu.Lock()
+	s.keys[connectionKey]--
+	last := s.keys[connectionKey] == 0
+	if last {
+		delete(s.keys, connectionKey)
+	}
+	s.mu.Unlock()
+
+	if connectionKey != connectionAddress {
+		s.throttler.UnregisterConnection(connectionAddress)
+	}
+	if last {
+		s.throttler.UnregisterConnection(connectionKey)
 	}
 }
 
 // Handle serve a file over a TCP connection.
+// A transfer is cancelled when the connection is killed, the server shuts down or the transfer timeout is reached,
+// and the reason is reported to the client.
 func (s *TCPFileServer) Handle(conn net.Conn) {
 	connectionAddress := conn.RemoteAddr().String()
-	defer s.throttler.UnregisterConnection(connectionAddress)
+	connectionKey := s.acquireKey(connectionAddress)
+	defer s.releaseKey(connectionKey, connectionAddress)
 	defer conn.Close()
+	ctx := s.track(conn)
+	if ctx == nil {
+		errorRespond(conn, ErrShuttingDown)
+		return
+	}
+	defer s.untrack(conn)
+	s.throttler.setCanceler(connectionAddress, ctx.cancelWith)
+	go func() {
+		<-ctx.Done()
+		// Unblock waiting for a command, transfers are interrupted by the context itself
+		conn.SetReadDeadline(time.Now())
+	}()
+	defer ctx.cancel()
 
+	framed := false
+	// Kept for the whole connection, as uploaded contents may be buffered along with their command
+	reader := bufio.NewReader(conn)
 	for {
-		netData, err := bufio.NewReader(conn).ReadString('\n')
+		idleTimeout, _ := s.timeouts()
+		deadline := time.Time{}
+		if idleTimeout > 0 {
+			deadline = time.Now().Add(idleTimeout)
+		}
+		conn.SetReadDeadline(deadline)
+		// Check after the deadline is set, so cancellation can't be missed
+		if reason := ctx.Reason(); reason != nil {
+			s.notify(conn, reason)
+			break
+		}
+
+		netData, err := reader.ReadString('\n')
 		if err == io.EOF {
 			s.logger.Println(fmt.Errorf("client %s has left", connectionAddress))
 			break
 		}
+		if reason := ctx.Reason(); err != nil && reason != nil {
+			s.notify(conn, reason)
+			break
+		}
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			s.logger.Printf("client %s: %s", connectionAddress, ErrIdleTimeout)
+			s.notify(conn, ErrIdleTimeout)
+			break
+		}
+		if err != nil && errors.Is(err, net.ErrClosed) {
+			break
+		}
 		if err != nil {
 			s.logger.Println(fmt.Errorf("failed to read net data: %s", err))
 			continue
@@ -55,15 +298,145 @@ func (s *TCPFileServer) Handle(conn net.Conn) {
 			textRespond(conn, "BYE!")
 			break
 		}
-		if cmd.Action == "FILE" {
-			err := s.writeFile(cmd.GetArg(0), conn, connectionAddress)
-			if err != nil && err != io.EOF {
+		if cmd.Action == "PROTO" {
+			switch protocol := cmd.GetArg(0); protocol {
+			case FileProtocolRaw, FileProtocolFramed:
+				framed = protocol == FileProtocolFramed
+				textRespond(conn, "OK")
+			default:
+				errorRespond(conn, fmt.Errorf("%w: unknown file protocol `%s`, want raw or framed", ErrBadArgument, protocol))
+			}
+			continue
+		}
+		if cmd.Action == "LIST" {
+			lines, err := s.listFiles(cmd)
+			if err != nil {
+				errorRespond(conn, err)
+				continue
+			}
+			for _, line := range lines {
+				textRespond(conn, line)
+			}
+			continue
+		}
+		if cmd.Action == "STAT" {
+			res, err := s.statFile(cmd)
+			if err != nil {
+				errorRespond(conn, err)
+				continue
+			}
+			textRespond(conn, res)
+			continue
+		}
+		if cmd.Action == "SUM" {
+			res, err := s.sumFile(cmd)
+			if err != nil {
 				s.logger.Println(err)
 				errorRespond(conn, err)
 				continue
 			}
+			textRespond(conn, res)
+			continue
+		}
+		if cmd.Action == "PUT" {
+			if !s.setBusy(conn, true) {
+				errorRespond(conn, ErrShuttingDown)
+				break
+			}
+			res, err := s.receiveFile(ctx, cmd, conn, reader, connectionKey)
+			running := s.setBusy(conn, false)
+			if err != nil {
+				s.logger.Println(err)
+				s.notify(conn, err)
+				// The rest of the contents can't be told from commands, so the connection is closed
+				if errors.Is(err, errIncompleteUpload) || ctx.Err() != nil {
+					break
+				}
+				continue
+			}
+			textRespond(conn, res)
+			if !running {
+				// Connections are closed once their uploads end during shutdown
+				break
+			}
+			continue
+		}
+		if cmd.Action == "FILE" || cmd.Action == "BATCH" {
+			if !s.setBusy(conn, true) {
+				errorRespond(conn, ErrShuttingDown)
+				break
+			}
+			var err error
+			if cmd.Action == "FILE" {
+				err = s.writeFile(ctx, cmd, conn, connectionKey, framed)
+			} else {
+				err = s.writeBatch(ctx, cmd, conn, connectionKey, framed)
+			}
+			if !s.setBusy(conn, false) && ctx.Err() == nil {
+				// Connections are closed once their transfers end during shutdown
+				break
+			}
+			if errors.Is(err, errIncompleteFrame) {
+				// Client can't tell an error from file contents, closing is the only way to report it
+				s.logger.Println(err)
+				break
+			}
+			if err != nil && err != io.EOF {
+				s.logger.Println(err)
+				s.notify(conn, err)
+				if ctx.Err() != nil {
+					break
+				}
+				continue
+			}
+			continue
 		}
+		if !cmd.handledBy(FileServerCommand) {
+			errorRespond(conn, fmt.Errorf("%w: %s", ErrUnsupportedFileCommand, cmd.Action))
+		}
+	}
+}
+
+// notify report an error to a client that may not be reading anymore.
+func (s *TCPFileServer) notify(conn net.Conn, err error) {
+	conn.SetWriteDeadline(time.Now().Add(noticeTimeout))
+	errorRespond(conn, err)
+	conn.SetWriteDeadline(time.Time{})
+}
+
+// track register a connection being handled and get its context. Returns nil if the server is shutting down.
+func (s *TCPFileServer) track(conn net.Conn) *reasonContext {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shuttingDown {
+		return nil
 	}
+	ctx := withReason(s.ctx)
+	s.conns[conn] = &connectionState{ctx: ctx}
+	s.handlers.Add(1)
+	return ctx
+}
+
+func (s *TCPFileServer) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+	s.handlers.Done()
+}
+
+// setBusy mark a connection as sending a file or waiting for a command.
+// Returns false if the server is shutting down.
+func (s *TCPFileServer) setBusy(conn net.Conn, busy bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conns[conn].busy = busy
+	return !s.shuttingDown
+}
+
+func (s *TCPFileServer) isShuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shuttingDown
 }
 
 // Serve listen for incoming connections and run server.
@@ -73,7 +446,25 @@ func (s *TCPFileServer) Serve(protocol, address string) error {
 	if err != nil {
 		return err
 	}
+	return s.acceptLoop()
+}
+
+// ServeTLS listen for incoming TLS connections and run server.
+func (s *TCPFileServer) ServeTLS(protocol, address string, config *tls.Config) error {
+	s.logger.Printf("TCP File Server listens with TLS on %s %s\n", protocol, address)
+	err := s.throttler.ListenTLS(protocol, address, config)
+	if err != nil {
+		return err
+	}
+	return s.acceptLoop()
+}
+
+func (s *TCPFileServer) acceptLoop() error {
 	defer s.throttler.Close()
+	// Shutdown could have happened before listening was started
+	if s.isShuttingDown() {
+		return ErrShuttingDown
+	}
 
 	for {
 		c, err := s.throttler.Accept()
@@ -95,23 +486,218 @@ func (s *TCPFileServer) Stop() error {
 	return nil
 }
 
-func (s *TCPFileServer) writeFile(fileName string, conn net.Conn, connectionKey string) error {
-	filePath, err := filepath.Abs(filepath.Join(s.baseDirectory, strings.TrimSpace(fileName)))
+// Shutdown gracefully stop the server: stop listening, notify and close connections waiting for a command,
+// and let transfers in progress finish until ctx is done. Then the remaining transfers are cancelled,
+// and their connections are closed.
+func (s *TCPFileServer) Shutdown(ctx context.Context) ShutdownReport {
+	s.logger.Println("TCP File Server shuts down")
+	report := ShutdownReport{}
+	s.mu.Lock()
+	s.shuttingDown = true
+	s.mu.Unlock()
+	s.throttler.Close()
+
+	s.mu.Lock()
+	busy := 0
+	for _, state := range s.conns {
+		if state.busy {
+			busy++
+			continue
+		}
+		state.ctx.cancelWith(ErrShuttingDown)
+		report.IdleClosed++
+	}
+	s.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		s.mu.Lock()
+		for _, state := range s.conns {
+			if state.busy {
+				report.Interrupted++
+			}
+		}
+		s.mu.Unlock()
+		s.ctx.cancelWith(ErrShuttingDown)
+		select {
+		case <-drained:
+		case <-time.After(noticeTimeout):
+			// Handlers that are stuck writing to clients are released by closing their connections
+			s.mu.Lock()
+			for conn := range s.conns {
+				conn.Close()
+			}
+			s.mu.Unlock()
+			<-drained
+		}
+	}
+	report.Drained = busy - report.Interrupted
+	s.logger.Printf("TCP File Server stopped: %s", report)
+	return report
+}
+
+// fileRequest a file, or a part of it, requested with FILE command
+type fileRequest struct {
+	offset   int64
+	length   int64           // up to the end of the file if negative
+	encoding ContentEncoding // contents are sent as they are if it's empty
+}
+
+// parseFileRequest parse `FILE file_name [offset] [length] [enc=gzip|deflate]`.
+func parseFileRequest(cmd *Command) (fileRequest, error) {
+	r := fileRequest{length: -1}
+	positional := []string{}
+	for _, arg := range cmd.Args[1:] {
+		if !strings.Contains(arg, "=") {
+			positional = append(positional, arg)
+			continue
+		}
+		if !strings.HasPrefix(arg, "enc=") {
+			return r, fmt.Errorf("%w: unknown FILE option `%s`, want enc", ErrBadArgument, arg)
+		}
+		r.encoding = ContentEncoding(strings.TrimPrefix(arg, "enc="))
+		if err := r.encoding.Validate(); err != nil {
+			return r, err
+		}
+	}
+	if len(positional) > 2 {
+		return r, fmt.Errorf("%w: too many arguments, want file_name [offset] [length] [enc=gzip|deflate]", ErrBadArgument)
+	}
+	if len(positional) > 0 {
+		n, err := strconv.ParseInt(positional[0], 10, 64)
+		if err != nil || n < 0 {
+			return r, fmt.Errorf("%w: offset must be a non-negative number of bytes, got `%s`", ErrBadArgument, positional[0])
+		}
+		r.offset = n
+	}
+	if len(positional) > 1 {
+		n, err := strconv.ParseInt(positional[1], 10, 64)
+		if err != nil || n <= 0 {
+			return r, fmt.Errorf("%w: length must be a positive number of bytes, got `%s`", ErrBadArgument, positional[1])
+		}
+		r.length = n
+	}
+	return r, nil
+}
+
+// sumFile get a reply to SUM command: `OK <file_size> <checksum>`.
+func (s *TCPFileServer) sumFile(cmd *Command) (string, error) {
+	algorithm := s.getChecksumAlgorithm()
+	if arg := cmd.GetArg(1); arg != "" {
+		algorithm = ChecksumAlgorithm(arg)
+		if err := algorithm.Validate(); err != nil {
+			return "", err
+		}
+	}
+	file, err := s.getSandbox().open(cmd.GetArg(0))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	checksum, err := s.checksums.get(file, algorithm)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("OK %d %s", file.info.Size(), checksum), nil
+}
+
+// writeFile send a file, or a range of it, to a client. In framed protocol the contents are preceded
+// by a FileHeader line, and an error after the header is wrapped with errIncompleteFrame.
+// Contents are compressed before they are throttled, so the throttler meters only the compressed bytes.
+func (s *TCPFileServer) writeFile(ctx *reasonContext, cmd *Command, conn net.Conn, connectionKey string, framed bool) error {
+	fileName := cmd.GetArg(0)
+	r, err := parseFileRequest(cmd)
 	if err != nil {
 		return err
 	}
-	file, err := os.Open(filePath)
+	file, err := s.getSandbox().open(fileName)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
+	info := file.info
 
-	ctx := context.Background()
-	n, err := s.throttler.Write(ctx, conn, connectionKey, file)
-	if err != nil {
+	if r.offset > info.Size() {
+		return fmt.Errorf("%w: offset %d is beyond the end of `%s` of %d bytes",
+			ErrBadArgument, r.offset, fileName, info.Size())
+	}
+	if r.length < 0 || r.length > info.Size()-r.offset {
+		r.length = info.Size() - r.offset
+	}
+
+	// What is sent over the wire
+	var src io.ReaderAt = file
+	offset, length := r.offset, r.length
+	if r.encoding != "" && framed {
+		encoded, err := s.compression.compressRange(file, r.offset, r.length, r.encoding)
+		switch {
+		case errors.Is(err, errNotSmaller):
+			// Contents that don't get smaller are sent as they are, framed responses tell the client
+			r.encoding = ""
+		case err != nil:
+			return err
+		default:
+			defer encoded.remove()
+			src, offset, length = encoded, 0, encoded.size
+		}
+	}
+
+	var header *FileHeader
+	if framed {
+		// Checksums of whole files are cached, ranges are usually requested once
+		var checksum string
+		if r.offset == 0 && r.length == info.Size() {
+			checksum, err = s.checksums.get(file, s.getChecksumAlgorithm())
+		} else {
+			checksum, err = Checksum(io.NewSectionReader(file, r.offset, r.length), s.getChecksumAlgorithm())
+		}
+		if err != nil {
+			return err
+		}
+		header, err = newFileHeader(file, fileName, r.offset, r.length, info.Size(), checksum)
+		if err != nil {
+			return err
+		}
+		if r.encoding != "" {
+			header.Encoding, header.EncodedSize = r.encoding, length
+		}
+		textRespond(conn, header.String())
+	}
+
+	transferCtx, cancel := s.transferContext(ctx, conn)
+	defer cancel()
+	var n int64
+	if r.encoding != "" && !framed {
+		// Raw responses don't need the compressed size, so the contents are compressed while they are sent
+		encoded, wait := compressStream(file, r.offset, r.length, r.encoding)
+		n, err = s.throttler.Write(transferCtx, conn, connectionKey, encoded)
+		encoded.CloseWithError(errors.New("transfer has stopped"))
+		wait()
+	} else {
+		// The range is fixed when the request starts, so a file growing while it's sent can't overrun a frame
+		n, err = s.throttler.WriteRange(transferCtx, conn, connectionKey, src, offset, length)
+	}
+	if err != nil && err != io.EOF {
+		err = transferError(ctx, "transfer", n, err)
+		if framed {
+			err = fmt.Errorf("%w: %s", errIncompleteFrame, err)
+		}
 		return err
 	}
+	if framed && n != length {
+		return fmt.Errorf("%w: sent %d of %d bytes of %s", errIncompleteFrame, n, length, fileName)
+	}
 
+	if r.encoding != "" {
+		s.logger.Printf("%d bytes sent, %d before %s compression", n, r.length, r.encoding)
+		return nil
+	}
 	s.logger.Println(n, "bytes sent")
 	return nil
 }
@@ -0,0 +1,583 @@
+package qos_test
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kolotaev/qos"
+)
+
+var testModTime = time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC)
+
+// newFileServerTestFS get files served in tests
+func newFileServerTestFS() fstest.MapFS {
+	return fstest.MapFS{
+		"small.txt":      {Data: []byte("Go is awesome."), Mode: 0644, ModTime: testModTime},
+		"b.txt":          {Data: []byte("bb"), Mode: 0644, ModTime: testModTime},
+		"c.md":           {Data: []byte("ccc"), Mode: 0644, ModTime: testModTime},
+		"docs/d.txt":     {Data: []byte("dddd"), Mode: 0600, ModTime: testModTime},
+		"docs/sub/e.txt": {Data: []byte("eeeee"), Mode: 0644, ModTime: testModTime},
+		".hidden":        {Data: []byte("secret"), Mode: 0644, ModTime: testModTime},
+		".git/config":    {Data: []byte("[core]"), Mode: 0644, ModTime: testModTime},
+		"key.pem":        {Data: []byte("key"), Mode: 0644, ModTime: testModTime},
+	}
+}
+
+// newFileServerTestDir write files of newFileServerTestFS to a base directory of a temporary directory, with links:
+//
+//	base/link_in -> small.txt, base/link_dir -> docs, base/link_hidden -> .hidden, base/link_pem -> key.pem,
+//	base/link_out -> ../outside.txt, base/link_up -> ..
+func newFileServerTestDir(t *testing.T) (root, base string) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	base = filepath.Join(root, "base")
+	for name, file := range newFileServerTestFS() {
+		filePath := filepath.Join(base, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(filePath), 0755))
+		require.NoError(t, ioutil.WriteFile(filePath, file.Data, file.Mode))
+		require.NoError(t, os.Chtimes(filePath, testModTime, testModTime))
+	}
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "outside.txt"), []byte("outside"), 0644))
+	links := map[string]string{
+		"link_in":     "small.txt",
+		"link_dir":    "docs",
+		"link_hidden": ".hidden",
+		"link_pem":    "key.pem",
+		"link_out":    "../outside.txt",
+		"link_up":     "..",
+	}
+	for name, target := range links {
+		require.NoError(t, os.Symlink(target, filepath.Join(base, name)))
+	}
+	return root, base
+}
+
+func newFileServerTestClient(s *qos.TCPFileServer) (net.Conn, *bufio.Reader) {
+	server, client := net.Pipe()
+	go s.Handle(server)
+	return client, bufio.NewReader(client)
+}
+
+func TestTCPFileServer_Sandbox(t *testing.T) {
+	s := qos.NewTCPFileServerFS(qos.NewThrottler(100, true), newFileServerTestFS(), log.New(ioutil.Discard, "", 0))
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+
+	client.Write([]byte("FILE ../../go.mod\n"))
+	res, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "Error: forbidden: `../../go.mod` escapes the base directory\n", res)
+
+	sandbox := qos.NewSandboxFS(newFileServerTestFS())
+	require.NoError(t, sandbox.SetDenyList("small.*"))
+	s.SetSandbox(sandbox)
+	client.Write([]byte("FILE small.txt\n"))
+	res, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "Error: forbidden: `small.txt` is denied\n", res)
+}
+
+func TestTCPFileServer_AdminCommands(t *testing.T) {
+	s := qos.NewTCPFileServerFS(qos.NewThrottler(100, true), newFileServerTestFS(), log.New(ioutil.Discard, "", 0))
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+
+	for _, request := range []string{"SLIMIT srv1 10", "AUTH root pass", "MULTI", "HISTORY"} {
+		client.Write([]byte(request + "\n"))
+		res, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "Error: command is not supported by file server: "+strings.Fields(request)[0]+"\n", res)
+	}
+	client.Write([]byte("STAT small.txt\n"))
+	res, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(res, "OK "), res)
+}
+
+func TestTCPFileServer_ShutdownIdle(t *testing.T) {
+	s := qos.NewTCPFileServerFS(qos.NewThrottler(10, true), newFileServerTestFS(), log.New(ioutil.Discard, "", 0))
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+
+	// Make sure the connection is being handled
+	client.Write([]byte("foobar\n"))
+	res, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "Error: received unknown command: `foobar`\n", res)
+
+	report := make(chan qos.ShutdownReport)
+	go func() {
+		report <- s.Shutdown(context.Background())
+	}()
+	res, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "Error: server is shutting down\n", res)
+	assert.Equal(t, qos.ShutdownReport{IdleClosed: 1}, <-report)
+
+	// New connections are refused
+	late, lateReader := newFileServerTestClient(s)
+	defer late.Close()
+	res, err = lateReader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "Error: server is shutting down\n", res)
+}
+
+func TestTCPFileServer_ShutdownDrainsTransfers(t *testing.T) {
+	s := qos.NewTCPFileServerFS(qos.NewThrottler(7, true), newFileServerTestFS(), log.New(ioutil.Discard, "", 0))
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+
+	client.Write([]byte("FILE small.txt\n"))
+	chunk := make([]byte, 7)
+	_, err := io.ReadFull(reader, chunk)
+	require.NoError(t, err)
+	assert.Equal(t, "Go is a", string(chunk))
+
+	report := make(chan qos.ShutdownReport)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		report <- s.Shutdown(ctx)
+	}()
+	rest, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "wesome.", string(rest))
+	assert.Equal(t, qos.ShutdownReport{Drained: 1}, <-report)
+}
+
+func TestTCPFileServer_ShutdownInterruptsTransfers(t *testing.T) {
+	s := qos.NewTCPFileServerFS(qos.NewThrottler(1, true), newFileServerTestFS(), log.New(ioutil.Discard, "", 0))
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+
+	client.Write([]byte("FILE small.txt\n"))
+	b, err := reader.ReadByte()
+	require.NoError(t, err)
+	assert.Equal(t, byte('G'), b)
+
+	report := make(chan qos.ShutdownReport)
+	start := time.Now()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		report <- s.Shutdown(ctx)
+	}()
+	res, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "Error: transfer was cancelled after 1 bytes: server is shutting down\n", res)
+	assert.Equal(t, qos.ShutdownReport{Interrupted: 1}, <-report)
+	assert.WithinDuration(t, start.Add(100*time.Millisecond), time.Now(), 500*time.Millisecond)
+}
+
+func TestTCPFileServer_KillCancelsTransfer(t *testing.T) {
+	th := qos.NewThrottler(1, true)
+	s := qos.NewTCPFileServerFS(th, newFileServerTestFS(), log.New(ioutil.Discard, "", 0))
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+
+	client.Write([]byte("FILE small.txt\n"))
+	b, err := reader.ReadByte()
+	require.NoError(t, err)
+	assert.Equal(t, byte('G'), b)
+
+	assert.True(t, th.KillConnection("pipe"))
+	res, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "Error: transfer was cancelled after 1 bytes: connection was killed by an admin\n", res)
+	_, err = reader.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestTCPFileServer_KillIdleConnection(t *testing.T) {
+	th := qos.NewThrottler(1, true)
+	s := qos.NewTCPFileServerFS(th, newFileServerTestFS(), log.New(ioutil.Discard, "", 0))
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+
+	client.Write([]byte("foobar\n"))
+	_, err := reader.ReadString('\n')
+	require.NoError(t, err)
+
+	assert.True(t, th.KillConnection("pipe"))
+	res, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "Error: connection was killed by an admin\n", res)
+	assert.False(t, th.KillConnection("pipe"))
+}
+
+func TestTCPFileServer_Timeouts(t *testing.T) {
+	s := qos.NewTCPFileServerFS(qos.NewThrottler(1, true), newFileServerTestFS(), log.New(ioutil.Discard, "", 0))
+	s.SetTransferTimeout(1500 * time.Millisecond)
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+
+	// Transfer is cancelled, but the connection is kept
+	client.Write([]byte("FILE small.txt\n"))
+	res, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "GoError: transfer was cancelled after 2 bytes: transfer took too long\n", res)
+	client.Write([]byte("foobar\n"))
+	res, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "Error: received unknown command: `foobar`\n", res)
+
+	s.SetIdleTimeout(100 * time.Millisecond)
+	client.Write([]byte("foobar\n"))
+	_, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	start := time.Now()
+	res, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "Error: connection was idle for too long\n", res)
+	assert.WithinDuration(t, start.Add(100*time.Millisecond), time.Now(), 500*time.Millisecond)
+}
+
+func TestTCPFileServer_ClientNotReading(t *testing.T) {
+	th := qos.NewThrottler(1000, true)
+	s := qos.NewTCPFileServerFS(th, newFileServerTestFS(), log.New(ioutil.Discard, "", 0))
+	s.SetTransferTimeout(200 * time.Millisecond)
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+
+	// Nothing is read until the transfer times out, so no bytes are sent
+	client.Write([]byte("FILE small.txt\n"))
+	time.Sleep(500 * time.Millisecond)
+	res, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "Error: transfer was cancelled after 0 bytes: transfer took too long\n", res)
+
+	s.SetTransferTimeout(0)
+	client.Write([]byte("FILE small.txt\n"))
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, th.KillConnection("pipe"))
+	time.Sleep(100 * time.Millisecond)
+	res, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "Error: transfer was cancelled after 0 bytes: connection was killed by an admin\n", res)
+}
+
+func TestTCPFileServer_Framed(t *testing.T) {
+	s := qos.NewTCPFileServerFS(qos.NewThrottler(100, true), newFileServerTestFS(), log.New(ioutil.Discard, "", 0))
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+
+	client.Write([]byte("PROTO xml\n"))
+	res, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "Error: bad argument: unknown file protocol `xml`, want raw or framed\n", res)
+
+	client.Write([]byte("PROTO framed\n"))
+	res, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "OK\n", res)
+
+	// Files follow each other without any delimiters
+	for i := 0; i < 2; i++ {
+		client.Write([]byte("FILE small.txt\n"))
+		out := new(bytes.Buffer)
+		header, err := qos.ReadFramedFile(reader, out)
+		require.NoError(t, err)
+		assert.Equal(t, &qos.FileHeader{
+			Size:        14,
+			ContentType: "text/plain;charset=utf-8",
+			Checksum:    "sha256:25c43f80bd5cb377e113d330ddcbbb2847a23721530c2dfca97ba1463a3122ce",
+			FileSize:    14,
+		}, header)
+		assert.Equal(t, "Go is awesome.", out.String())
+	}
+
+	client.Write([]byte("FILE .hidden\n"))
+	_, err = qos.ReadFramedFile(reader, new(bytes.Buffer))
+	assert.ErrorIs(t, err, qos.ErrFileRequest)
+	assert.EqualError(t, err, "file request failed: forbidden: `.hidden` is a hidden file")
+
+	client.Write([]byte("PROTO raw\n"))
+	res, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "OK\n", res)
+	client.Write([]byte("FILE small.txt\n"))
+	res, err = reader.ReadString('.')
+	require.NoError(t, err)
+	assert.Equal(t, "Go is awesome.", res)
+}
+
+func TestTCPFileServer_FramedTransferCancelled(t *testing.T) {
+	th := qos.NewThrottler(1, true)
+	s := qos.NewTCPFileServerFS(th, newFileServerTestFS(), log.New(ioutil.Discard, "", 0))
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+
+	client.Write([]byte("PROTO framed\n"))
+	_, err := reader.ReadString('\n')
+	require.NoError(t, err)
+
+	client.Write([]byte("FILE small.txt\n"))
+	done := make(chan error)
+	out := new(bytes.Buffer)
+	go func() {
+		_, err := qos.ReadFramedFile(reader, out)
+		done <- err
+	}()
+	time.Sleep(1500 * time.Millisecond)
+	assert.True(t, th.KillConnection("pipe"))
+	err = <-done
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.NotContains(t, out.String(), "Error")
+}
+
+func TestTCPFileServer_Range(t *testing.T) {
+	s := qos.NewTCPFileServerFS(qos.NewThrottler(100, true), newFileServerTestFS(), log.New(ioutil.Discard, "", 0))
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+
+	client.Write([]byte("FILE small.txt 6 2\n"))
+	chunk := make([]byte, 2)
+	_, err := io.ReadFull(reader, chunk)
+	require.NoError(t, err)
+	assert.Equal(t, "aw", string(chunk))
+
+	client.Write([]byte("PROTO framed\n"))
+	_, err = reader.ReadString('\n')
+	require.NoError(t, err)
+
+	// Resume from an offset up to the end of the file, even if more is requested
+	client.Write([]byte("FILE small.txt 6 100\n"))
+	out := new(bytes.Buffer)
+	header, err := qos.ReadFramedFile(reader, out)
+	require.NoError(t, err)
+	assert.Equal(t, "awesome.", out.String())
+	assert.Equal(t, int64(8), header.Size)
+	assert.Equal(t, int64(6), header.Offset)
+	assert.Equal(t, int64(14), header.FileSize)
+	assert.True(t, header.IsRange())
+
+	client.Write([]byte("FILE small.txt 14\n"))
+	header, err = qos.ReadFramedFile(reader, new(bytes.Buffer))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), header.Size)
+
+	errorCases := map[string]string{
+		"FILE small.txt 15":     "bad argument: offset 15 is beyond the end of `small.txt` of 14 bytes",
+		"FILE small.txt -1":     "bad argument: offset must be a non-negative number of bytes, got `-1`",
+		"FILE small.txt 0 zero": "bad argument: length must be a positive number of bytes, got `zero`",
+		"FILE small.txt 0 0":    "bad argument: length must be a positive number of bytes, got `0`",
+	}
+	for request, message := range errorCases {
+		client.Write([]byte(request + "\n"))
+		_, err := qos.ReadFramedFile(reader, new(bytes.Buffer))
+		assert.EqualError(t, err, "file request failed: "+message, request)
+	}
+}
+
+func TestTCPFileServer_KeyByHost(t *testing.T) {
+	th := qos.NewThrottler(100, true)
+	s := qos.NewTCPFileServerFS(th, newFileServerTestFS(), log.New(ioutil.Discard, "", 0))
+	s.SetKeyByHost(true)
+	go s.Serve("tcp", "127.0.0.1:0")
+	defer s.Stop()
+	var address net.Addr
+	require.Eventually(t, func() bool {
+		address = th.Addr()
+		return address != nil
+	}, time.Second, 10*time.Millisecond)
+
+	read := func(conn net.Conn, request string) string {
+		conn.Write([]byte(request + "\n"))
+		chunk := make([]byte, 7)
+		_, err := io.ReadFull(conn, chunk)
+		require.NoError(t, err)
+		return string(chunk)
+	}
+	first, err := net.Dial("tcp", address.String())
+	require.NoError(t, err)
+	defer first.Close()
+	second, err := net.Dial("tcp", address.String())
+	require.NoError(t, err)
+	defer second.Close()
+	assert.Equal(t, "Go is a", read(first, "FILE small.txt 0 7"))
+	assert.Equal(t, "wesome.", read(second, "FILE small.txt 7"))
+
+	// Connections of a host are accounted together
+	assert.Equal(t, []qos.ConnectionInfo{{Key: "127.0.0.1", Limit: 100}}, th.Connections())
+	first.Close()
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, []qos.ConnectionInfo{{Key: "127.0.0.1", Limit: 100}}, th.Connections())
+
+	// A host is killed with all its connections
+	assert.True(t, th.KillConnection("127.0.0.1"))
+	res, err := bufio.NewReader(second).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "Error: connection was killed by an admin\n", res)
+	assert.Eventually(t, func() bool {
+		return len(th.Connections()) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestTCPFileServer_Sum(t *testing.T) {
+	fsys := fstest.MapFS{
+		"small.txt": {Data: []byte("Go is awesome."), ModTime: time.Now()},
+		"notes.txt": {Data: []byte("Go is awesome.")},
+	}
+	s := qos.NewTCPFileServerFS(qos.NewThrottler(100, true), fsys, log.New(ioutil.Discard, "", 0))
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+	sum := func(request string) string {
+		client.Write([]byte(request + "\n"))
+		res, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		return res
+	}
+
+	assert.Equal(t, "OK 14 "+smallChecksum+"\n", sum("SUM small.txt"))
+	assert.Equal(t, "OK 14 crc32c:16a8989e\n", sum("SUM small.txt crc32c"))
+	assert.Equal(t, "Error: bad argument: unknown checksum algorithm `md5`, want sha256 or crc32c\n", sum("SUM small.txt md5"))
+	assert.Equal(t, "Error: forbidden: `../small.txt` escapes the base directory\n", sum("SUM ../small.txt"))
+
+	// A cached checksum is recomputed once the file changes
+	fsys["small.txt"] = &fstest.MapFile{Data: []byte("Go is awesome!"), ModTime: time.Now().Add(time.Minute)}
+	assert.NotEqual(t, "OK 14 "+smallChecksum+"\n", sum("SUM small.txt"))
+
+	// Files without a modification time are never cached
+	assert.Equal(t, "OK 14 "+smallChecksum+"\n", sum("SUM notes.txt"))
+	fsys["notes.txt"] = &fstest.MapFile{Data: []byte("Go is awesome!")}
+	assert.NotEqual(t, "OK 14 "+smallChecksum+"\n", sum("SUM notes.txt"))
+}
+
+func TestTCPFileServer_FramedChecksumAlgorithm(t *testing.T) {
+	s := qos.NewTCPFileServerFS(qos.NewThrottler(100, true), newFileServerTestFS(), log.New(ioutil.Discard, "", 0))
+	assert.Error(t, s.SetChecksumAlgorithm("md5"))
+	require.NoError(t, s.SetChecksumAlgorithm(qos.ChecksumCRC32C))
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+
+	client.Write([]byte("PROTO framed\n"))
+	_, err := reader.ReadString('\n')
+	require.NoError(t, err)
+
+	client.Write([]byte("FILE small.txt\n"))
+	header, err := qos.ReadFramedFile(reader, new(bytes.Buffer))
+	require.NoError(t, err)
+	assert.Equal(t, "crc32c:16a8989e", header.Checksum)
+
+	client.Write([]byte("FILE small.txt 6\n"))
+	header, err = qos.ReadFramedFile(reader, new(bytes.Buffer))
+	require.NoError(t, err)
+	assert.Equal(t, "crc32c:cec2be40", header.Checksum)
+}
+
+func TestTCPFileServer_ZipArchive(t *testing.T) {
+	archive := new(bytes.Buffer)
+	zipWriter := zip.NewWriter(archive)
+	w, err := zipWriter.CreateHeader(&zip.FileHeader{Name: "docs/small.txt", Method: zip.Deflate})
+	require.NoError(t, err)
+	w.Write([]byte("Go is awesome."))
+	require.NoError(t, zipWriter.Close())
+	fsys, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	require.NoError(t, err)
+
+	s := qos.NewTCPFileServerFS(qos.NewThrottler(100, true), fsys, log.New(ioutil.Discard, "", 0))
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+	client.Write([]byte("PROTO framed\n"))
+	_, err = reader.ReadString('\n')
+	require.NoError(t, err)
+
+	// Compressed files can't be read at an offset, they are read again for the checksum and the contents
+	client.Write([]byte("FILE docs/small.txt\n"))
+	out := new(bytes.Buffer)
+	header, err := qos.ReadFramedFile(reader, out)
+	require.NoError(t, err)
+	assert.Equal(t, "Go is awesome.", out.String())
+	assert.Equal(t, smallChecksum, header.Checksum)
+
+	client.Write([]byte("FILE docs/small.txt 6 7\n"))
+	out.Reset()
+	_, err = qos.ReadFramedFile(reader, out)
+	require.NoError(t, err)
+	assert.Equal(t, "awesome", out.String())
+
+	client.Write([]byte("FILE docs/missing.txt\n"))
+	_, err = qos.ReadFramedFile(reader, new(bytes.Buffer))
+	assert.EqualError(t, err, "file request failed: open docs/missing.txt: file does not exist")
+}
+
+func TestTCPFileServer_Compressed(t *testing.T) {
+	text := strings.Repeat("Go is awesome. ", 100)
+	fsys := newFileServerTestFS()
+	fsys["big.txt"] = &fstest.MapFile{Data: []byte(text)}
+	// Raw contents would take 15 seconds at this limit
+	s := qos.NewTCPFileServerFS(qos.NewThrottler(100, true), fsys, log.New(ioutil.Discard, "", 0))
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+	start := time.Now()
+
+	client.Write([]byte("FILE big.txt enc=deflate\n"))
+	decoder, err := zlib.NewReader(reader)
+	require.NoError(t, err)
+	contents, err := ioutil.ReadAll(decoder)
+	require.NoError(t, err)
+	assert.Equal(t, text, string(contents))
+
+	client.Write([]byte("PROTO framed\n"))
+	_, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	client.Write([]byte("FILE big.txt 15 1485 enc=gzip\n"))
+	out := new(bytes.Buffer)
+	header, err := qos.ReadFramedFile(reader, out)
+	require.NoError(t, err)
+	assert.Equal(t, text[15:], out.String())
+	assert.Equal(t, qos.EncodingGzip, header.Encoding)
+	assert.Equal(t, int64(1485), header.Size)
+	assert.Less(t, header.EncodedSize, int64(100))
+	assert.Less(t, time.Since(start), 3*time.Second)
+
+	// Contents that don't get smaller are sent as they are
+	client.Write([]byte("FILE small.txt enc=gzip\n"))
+	out.Reset()
+	header, err = qos.ReadFramedFile(reader, out)
+	require.NoError(t, err)
+	assert.Equal(t, "Go is awesome.", out.String())
+	assert.Equal(t, qos.ContentEncoding(""), header.Encoding)
+
+	// So are contents that don't fit the temporary space for compression
+	s.SetCompressionSpace(150)
+	client.Write([]byte("FILE big.txt 0 151 enc=gzip\n"))
+	out.Reset()
+	header, err = qos.ReadFramedFile(reader, out)
+	require.NoError(t, err)
+	assert.Equal(t, text[:151], out.String())
+	assert.Equal(t, qos.ContentEncoding(""), header.Encoding)
+	client.Write([]byte("FILE big.txt 0 150 enc=gzip\n"))
+	out.Reset()
+	header, err = qos.ReadFramedFile(reader, out)
+	require.NoError(t, err)
+	assert.Equal(t, text[:150], out.String())
+	assert.Equal(t, qos.EncodingGzip, header.Encoding)
+
+	errorCases := map[string]string{
+		"FILE big.txt enc=br":  "bad argument: unknown encoding `br`, want gzip or deflate",
+		"FILE big.txt level=9": "bad argument: unknown FILE option `level=9`, want enc",
+		"FILE big.txt 1 2 3":   "bad argument: too many arguments, want file_name [offset] [length] [enc=gzip|deflate]",
+	}
+	for request, message := range errorCases {
+		client.Write([]byte(request + "\n"))
+		_, err := qos.ReadFramedFile(reader, new(bytes.Buffer))
+		assert.EqualError(t, err, "file request failed: "+message, request)
+	}
+}
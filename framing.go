@@ -0,0 +1,201 @@
+package qos
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// File server protocols negotiated with PROTO command
+const (
+	FileProtocolRaw    = "raw"    // file contents only, errors are text lines
+	FileProtocolFramed = "framed" // a status line with a FileHeader, then exactly Size bytes
+)
+
+// Framed responses errors
+var (
+	ErrFileRequest      = errors.New("file request failed")
+	ErrBadFileHeader    = errors.New("malformed file header")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// errIncompleteFrame a framed transfer was cut after its header was sent,
+// so the client can't find where the next response starts.
+var errIncompleteFrame = errors.New("framed transfer is incomplete")
+
+// Prefixes of optional fields of file headers
+const (
+	rangePrefix    = "range:"
+	encodingPrefix = "enc:"
+)
+
+// FileHeader a status line preceding file contents in framed protocol, e.g.
+//
+//	OK 14 text/plain;charset=utf-8 sha256:25c43f80...
+//
+// A response to a range request has one more field with the offset of the range and the size of the whole file,
+// e.g. `range:10/14`. Compressed contents have a field with the encoding and their size, e.g. `enc:gzip/34`,
+// then EncodedSize bytes follow instead of Size. Checksum is of the contents before compression, see Checksum.
+type FileHeader struct {
+	Size        int64
+	ContentType string
+	Checksum    string
+	Offset      int64
+	FileSize    int64
+	Encoding    ContentEncoding
+	EncodedSize int64
+}
+
+func (h FileHeader) String() string {
+	line := fmt.Sprintf("OK %d %s %s", h.Size, h.ContentType, h.Checksum)
+	if h.IsRange() {
+		line += fmt.Sprintf(" %s%d/%d", rangePrefix, h.Offset, h.FileSize)
+	}
+	if h.Encoding != "" {
+		line += fmt.Sprintf(" %s%s/%d", encodingPrefix, h.Encoding, h.EncodedSize)
+	}
+	return line
+}
+
+// frameSize get the number of bytes following the header.
+func (h FileHeader) frameSize() int64 {
+	if h.Encoding != "" {
+		return h.EncodedSize
+	}
+	return h.Size
+}
+
+// IsRange are the contents only a part of the file?
+func (h FileHeader) IsRange() bool {
+	return h.Offset != 0 || h.Size != h.FileSize
+}
+
+// ParseFileHeader parse a framed protocol status line.
+// A status line with an error reported by the server is returned as ErrFileRequest.
+func ParseFileHeader(line string) (*FileHeader, error) {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, "Error: ") {
+		return nil, fmt.Errorf("%w: %s", ErrFileRequest, strings.TrimPrefix(line, "Error: "))
+	}
+	fields := strings.Split(line, " ")
+	if len(fields) < 4 || len(fields) > 6 || fields[0] != "OK" {
+		return nil, fmt.Errorf("%w: `%s`", ErrBadFileHeader, line)
+	}
+	if _, err := checksumAlgorithmOf(fields[3]); err != nil {
+		return nil, fmt.Errorf("%w: bad checksum in `%s`", ErrBadFileHeader, line)
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("%w: bad size in `%s`", ErrBadFileHeader, line)
+	}
+	header := &FileHeader{Size: size, ContentType: fields[2], Checksum: fields[3], FileSize: size}
+	hasRange := false
+	for _, field := range fields[4:] {
+		switch {
+		case strings.HasPrefix(field, rangePrefix) && !hasRange:
+			hasRange = true
+			var offset, fileSize int64
+			_, err := fmt.Sscanf(field, rangePrefix+"%d/%d", &offset, &fileSize)
+			if err != nil || offset < 0 || offset+size > fileSize {
+				return nil, fmt.Errorf("%w: bad range in `%s`", ErrBadFileHeader, line)
+			}
+			header.Offset, header.FileSize = offset, fileSize
+		case strings.HasPrefix(field, encodingPrefix) && header.Encoding == "":
+			parts := strings.SplitN(strings.TrimPrefix(field, encodingPrefix), "/", 2)
+			if len(parts) != 2 || ContentEncoding(parts[0]).Validate() != nil {
+				return nil, fmt.Errorf("%w: bad encoding in `%s`", ErrBadFileHeader, line)
+			}
+			encodedSize, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil || encodedSize < 0 {
+				return nil, fmt.Errorf("%w: bad encoding in `%s`", ErrBadFileHeader, line)
+			}
+			header.Encoding, header.EncodedSize = ContentEncoding(parts[0]), encodedSize
+		default:
+			return nil, fmt.Errorf("%w: `%s`", ErrBadFileHeader, line)
+		}
+	}
+	return header, nil
+}
+
+// ReadFramedFile read a framed FILE response and write the file contents to w.
+// Compressed contents are decompressed, and the contents are verified against the header's checksum.
+func ReadFramedFile(r *bufio.Reader, w io.Writer) (*FileHeader, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	header, err := ParseFileHeader(line)
+	if err != nil {
+		return nil, err
+	}
+	// The algorithm is validated by ParseFileHeader
+	algorithm, _ := checksumAlgorithmOf(header.Checksum)
+	hash, _ := algorithm.newHash()
+	if header.Encoding != "" {
+		err = readEncoded(r, io.MultiWriter(w, hash), header)
+	} else {
+		var n int64
+		n, err = io.CopyN(io.MultiWriter(w, hash), r, header.Size)
+		if err == io.EOF {
+			err = fmt.Errorf("%w: got %d of %d bytes", io.ErrUnexpectedEOF, n, header.Size)
+		}
+	}
+	if err != nil {
+		return header, err
+	}
+	if checksum := formatChecksum(algorithm, hash); checksum != header.Checksum {
+		return header, fmt.Errorf("%w: got %s, want %s", ErrChecksumMismatch, checksum, header.Checksum)
+	}
+	return header, nil
+}
+
+// readEncoded decompress the contents of a frame to w. The whole frame is read even if it can't be decompressed,
+// so the next response can be read.
+func readEncoded(r io.Reader, w io.Writer, header *FileHeader) error {
+	frame := &io.LimitedReader{R: r, N: header.EncodedSize}
+	decoder, err := header.Encoding.newReader(frame)
+	var n int64
+	if err == nil {
+		n, err = io.Copy(w, decoder)
+	}
+	io.Copy(ioutil.Discard, frame)
+	if frame.N > 0 {
+		return fmt.Errorf("%w: got %d of %d bytes", io.ErrUnexpectedEOF, header.EncodedSize-frame.N, header.EncodedSize)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decompress %s contents: %s", header.Encoding, err)
+	}
+	if n != header.Size {
+		return fmt.Errorf("%w: decompressed %d of %d bytes", ErrChecksumMismatch, n, header.Size)
+	}
+	return nil
+}
+
+// newFileHeader get a header of size bytes of a file starting at offset with their checksum.
+// The content type is found by the file name or the beginning of the file.
+func newFileHeader(file io.ReaderAt, name string, offset, size, fileSize int64, checksum string) (*FileHeader, error) {
+	contentType := mime.TypeByExtension(filepath.Ext(name))
+	if contentType == "" {
+		sniff := make([]byte, 512)
+		n, err := file.ReadAt(sniff, 0)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		contentType = http.DetectContentType(sniff[:n])
+	}
+	return &FileHeader{
+		Size: size,
+		// Header fields are separated by spaces, e.g. `text/plain; charset=utf-8` is sent without them
+		ContentType: strings.ReplaceAll(contentType, " ", ""),
+		Checksum:    checksum,
+		Offset:      offset,
+		FileSize:    fileSize,
+	}, nil
+}
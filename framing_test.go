@@ -0,0 +1,115 @@
+package qos_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kolotaev/qos"
+)
+
+const smallChecksum = "sha256:25c43f80bd5cb377e113d330ddcbbb2847a23721530c2dfca97ba1463a3122ce"
+
+func TestParseFileHeader(t *testing.T) {
+	header, err := qos.ParseFileHeader("OK 14 text/plain;charset=utf-8 " + smallChecksum + "\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, &qos.FileHeader{Size: 14, ContentType: "text/plain;charset=utf-8", Checksum: smallChecksum, FileSize: 14}, header)
+	assert.Equal(t, "OK 14 text/plain;charset=utf-8 "+smallChecksum, header.String())
+	assert.False(t, header.IsRange())
+
+	header, err = qos.ParseFileHeader("OK 8 text/plain " + smallChecksum + " range:6/14\n")
+	require.NoError(t, err)
+	assert.Equal(t, &qos.FileHeader{Size: 8, ContentType: "text/plain", Checksum: smallChecksum, Offset: 6, FileSize: 14}, header)
+	assert.Equal(t, "OK 8 text/plain "+smallChecksum+" range:6/14", header.String())
+	assert.True(t, header.IsRange())
+
+	header, err = qos.ParseFileHeader("OK 8 text/plain " + smallChecksum + " range:6/14 enc:gzip/30\n")
+	require.NoError(t, err)
+	assert.Equal(t, qos.EncodingGzip, header.Encoding)
+	assert.Equal(t, int64(30), header.EncodedSize)
+	assert.Equal(t, int64(6), header.Offset)
+	assert.Equal(t, "OK 8 text/plain "+smallChecksum+" range:6/14 enc:gzip/30", header.String())
+
+	cases := []struct {
+		line string
+		err  string
+	}{
+		{"Error: forbidden", "file request failed: forbidden"},
+		{"OK 14 text/plain", "malformed file header: `OK 14 text/plain`"},
+		{"OK 14 text/plain md5:abc", "malformed file header: bad checksum in `OK 14 text/plain md5:abc`"},
+		{"OK -1 text/plain sha256:abc", "malformed file header: bad size in `OK -1 text/plain sha256:abc`"},
+		{"OK ten text/plain sha256:abc", "malformed file header: bad size in `OK ten text/plain sha256:abc`"},
+		{"OK 8 text/plain sha256:abc range:7/14", "malformed file header: bad range in `OK 8 text/plain sha256:abc range:7/14`"},
+		{"OK 8 text/plain sha256:abc 6/14", "malformed file header: `OK 8 text/plain sha256:abc 6/14`"},
+		{"OK 8 text/plain sha256:abc enc:br/5", "malformed file header: bad encoding in `OK 8 text/plain sha256:abc enc:br/5`"},
+		{"OK 8 text/plain sha256:abc enc:gzip", "malformed file header: bad encoding in `OK 8 text/plain sha256:abc enc:gzip`"},
+	}
+	for _, c := range cases {
+		_, err := qos.ParseFileHeader(c.line)
+		assert.EqualError(t, err, c.err, c.line)
+	}
+}
+
+func TestReadFramedFile(t *testing.T) {
+	cases := []struct {
+		name     string
+		response string
+		contents string
+		err      error
+	}{
+		{"complete", "OK 14 text/plain " + smallChecksum + "\nGo is awesome.OK", "Go is awesome.", nil},
+		{"empty", "OK 0 text/plain sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855\n", "", nil},
+		{"short", "OK 14 text/plain " + smallChecksum + "\nGo is", "Go is", io.ErrUnexpectedEOF},
+		{"corrupted", "OK 14 text/plain " + smallChecksum + "\nGo is AWESOME.", "Go is AWESOME.", qos.ErrChecksumMismatch},
+		{"error", "Error: forbidden\n", "", qos.ErrFileRequest},
+		{"no header", "Go is awesome.", "", io.EOF},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			reader := bufio.NewReader(strings.NewReader(c.response))
+			out := new(bytes.Buffer)
+			_, err := qos.ReadFramedFile(reader, out)
+			if c.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, c.err)
+			}
+			assert.Equal(t, c.contents, out.String())
+		})
+	}
+}
+
+func TestReadFramedFile_Encoded(t *testing.T) {
+	encoded := new(bytes.Buffer)
+	w := gzip.NewWriter(encoded)
+	w.Write([]byte("Go is awesome."))
+	require.NoError(t, w.Close())
+	header := fmt.Sprintf("OK 14 text/plain %s enc:gzip/%d\n", smallChecksum, encoded.Len())
+
+	// Contents are decompressed, and the next response can be read after them
+	reader := bufio.NewReader(strings.NewReader(header + encoded.String() + "Error: forbidden\n"))
+	out := new(bytes.Buffer)
+	_, err := qos.ReadFramedFile(reader, out)
+	require.NoError(t, err)
+	assert.Equal(t, "Go is awesome.", out.String())
+	_, err = qos.ReadFramedFile(reader, out)
+	assert.ErrorIs(t, err, qos.ErrFileRequest)
+
+	reader = bufio.NewReader(strings.NewReader(header + encoded.String()[:10]))
+	_, err = qos.ReadFramedFile(reader, new(bytes.Buffer))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	reader = bufio.NewReader(strings.NewReader(fmt.Sprintf("OK 14 text/plain %s enc:deflate/4\nbad!next", smallChecksum)))
+	_, err = qos.ReadFramedFile(reader, new(bytes.Buffer))
+	assert.EqualError(t, err, "failed to decompress deflate contents: zlib: invalid header")
+	rest, _ := ioutil.ReadAll(reader)
+	assert.Equal(t, "next", string(rest))
+}
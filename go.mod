@@ -4,5 +4,6 @@ go 1.16
 
 require (
 	github.com/stretchr/testify v1.7.1
+	golang.org/x/term v0.0.0-20210220032956-6a3ed077a48d
 	golang.org/x/time v0.0.0-20220224211638-0e9765cccd65
 )
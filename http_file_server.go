@@ -0,0 +1,258 @@
+package qos
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// HTTPFileServer server for serving files over HTTP from a base directory or any other file system,
+// with the same Sandbox and bandwidth limits as TCPFileServer:
+//
+//	GET  /{file_name} - download a file, Range requests and conditional GETs are supported
+//	HEAD /{file_name} - get the headers only
+//
+// Response bodies are written through the Throttler keyed by client address, so connections are listed,
+// limited and killed by admin servers as those of TCPFileServer. It can be used as an http.Handler too,
+// then ConnState should be set as the http.Server's hook, so closed connections are unregistered.
+type HTTPFileServer struct {
+	throttler *Throttler
+	sandbox   *Sandbox
+	logger    *log.Logger
+	server    *http.Server
+	ctx       *reasonContext // cancelled to abort transfers when shutdown deadline is reached
+	mu        *sync.Mutex
+	checksums *checksumCache
+}
+
+// NewHTTPFileServer HTTPFileServer ctor
+func NewHTTPFileServer(throttler *Throttler, baseDirectory string, logger *log.Logger) *HTTPFileServer {
+	return newHTTPFileServer(throttler, NewSandbox(baseDirectory), logger)
+}
+
+// NewHTTPFileServerFS HTTPFileServer ctor of a file system other than a directory,
+// e.g. embedded assets, a zip archive or fstest.MapFS.
+func NewHTTPFileServerFS(throttler *Throttler, fsys fs.FS, logger *log.Logger) *HTTPFileServer {
+	return newHTTPFileServer(throttler, NewSandboxFS(fsys), logger)
+}
+
+func newHTTPFileServer(throttler *Throttler, sandbox *Sandbox, logger *log.Logger) *HTTPFileServer {
+	s := &HTTPFileServer{
+		throttler: throttler,
+		sandbox:   sandbox,
+		logger:    logger,
+		ctx:       withReason(context.Background()),
+		mu:        new(sync.Mutex),
+		checksums: newChecksumCache(),
+	}
+	s.server = &http.Server{Handler: s, ConnState: s.ConnState}
+	return s
+}
+
+// SetSandbox restrict files that can be served with a sandbox other than the default one of the file system.
+// The sandbox's file system is served from then on.
+func (s *HTTPFileServer) SetSandbox(sandbox *Sandbox) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sandbox = sandbox
+}
+
+func (s *HTTPFileServer) getSandbox() *Sandbox {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sandbox
+}
+
+// ConnState unregister connections from the Throttler once they are closed.
+func (s *HTTPFileServer) ConnState(conn net.Conn, state http.ConnState) {
+	if state == http.StateClosed || state == http.StateHijacked {
+		s.throttler.UnregisterConnection(conn.RemoteAddr().String())
+	}
+}
+
+// ServeHTTP handle an HTTP request.
+func (s *HTTPFileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if name == "" {
+		http.NotFound(w, r)
+		return
+	}
+	file, err := s.getSandbox().open(name)
+	if err != nil {
+		s.httpError(w, err)
+		return
+	}
+	defer file.Close()
+	if file.info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	etag, err := s.etag(file)
+	if err != nil {
+		s.httpError(w, err)
+		return
+	}
+	w.Header().Set("ETag", etag)
+
+	ctx := s.track(r)
+	defer ctx.cancel()
+	response := &throttledResponse{ResponseWriter: w, ctx: ctx, throttler: s.throttler, key: r.RemoteAddr}
+	// The size is fixed when the request starts, so Content-Length holds if the file grows while it's sent
+	http.ServeContent(response, r, file.info.Name(), file.info.ModTime(), io.NewSectionReader(file, 0, file.info.Size()))
+	n, err := response.close()
+	if err != nil {
+		if reason := ctx.Reason(); reason != nil {
+			err = reason
+		}
+		s.logger.Printf("transfer of %s to %s was cancelled after %d bytes: %s", name, r.RemoteAddr, n, err)
+		return
+	}
+	s.logger.Printf("%d bytes of %s sent to %s", n, name, r.RemoteAddr)
+}
+
+// track get a context of a request, it's cancelled when the client's connection is killed or on shutdown.
+func (s *HTTPFileServer) track(r *http.Request) *reasonContext {
+	ctx := withReason(s.ctx)
+	s.throttler.setCanceler(r.RemoteAddr, ctx.cancelWith)
+	go func() {
+		select {
+		case <-r.Context().Done():
+			ctx.cancelWith(r.Context().Err())
+		case <-ctx.Done():
+		}
+	}()
+	return ctx
+}
+
+// etag get an entity tag of a file for conditional requests. It's made of the size and the modification time,
+// or of a checksum of the contents if the file has no modification time, e.g. when it's embedded.
+func (s *HTTPFileServer) etag(file *servedFile) (string, error) {
+	if !file.info.ModTime().IsZero() {
+		return fmt.Sprintf(`"%x-%x"`, file.info.ModTime().UnixNano(), file.info.Size()), nil
+	}
+	checksum, err := s.checksums.get(file, ChecksumSHA256)
+	if err != nil {
+		return "", err
+	}
+	return `"` + checksum + `"`, nil
+}
+
+func (s *HTTPFileServer) httpError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrForbidden):
+		status = http.StatusForbidden
+	case errors.Is(err, ErrBadArgument):
+		status = http.StatusBadRequest
+	default:
+		s.logger.Println(err)
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// Serve listen for incoming connections and run server.
+func (s *HTTPFileServer) Serve(protocol, address string) error {
+	s.logger.Printf("HTTP File Server listens on %s %s\n", protocol, address)
+	err := s.throttler.Listen(protocol, address)
+	if err != nil {
+		return err
+	}
+	return s.server.Serve(s.throttler)
+}
+
+// ServeTLS listen for incoming TLS connections and run server.
+func (s *HTTPFileServer) ServeTLS(protocol, address string, config *tls.Config) error {
+	s.logger.Printf("HTTP File Server listens with TLS on %s %s\n", protocol, address)
+	err := s.throttler.ListenTLS(protocol, address, config)
+	if err != nil {
+		return err
+	}
+	return s.server.Serve(s.throttler)
+}
+
+// Stop stop listening for incoming connections and close all connections.
+func (s *HTTPFileServer) Stop() error {
+	s.logger.Println("HTTP File Server stops")
+	return s.server.Close()
+}
+
+// Shutdown gracefully stop the server: stop listening, close idle connections and let transfers in progress
+// finish until ctx is done. Then the remaining transfers are cancelled, and their connections are closed.
+func (s *HTTPFileServer) Shutdown(ctx context.Context) error {
+	s.logger.Println("HTTP File Server shuts down")
+	err := s.server.Shutdown(ctx)
+	if err != nil {
+		s.ctx.cancelWith(ErrShuttingDown)
+		s.server.Close()
+	}
+	return err
+}
+
+// throttledResponse a response whose body is written through a single throttled transfer,
+// so the bandwidth is used continuously however the body is split into writes.
+type throttledResponse struct {
+	http.ResponseWriter
+	ctx       context.Context
+	throttler *Throttler
+	key       string
+	body      *io.PipeWriter
+	sent      chan struct{} // closed when the transfer ends
+	n         int64
+	err       error
+}
+
+func (w *throttledResponse) Write(p []byte) (int, error) {
+	if w.body == nil {
+		reader, writer := io.Pipe()
+		w.body, w.sent = writer, make(chan struct{})
+		go func() {
+			defer close(w.sent)
+			w.n, w.err = w.throttler.Write(w.ctx, flushWriter{w.ResponseWriter}, w.key, reader)
+			// Writes of the rest of the body fail once the transfer has failed
+			reader.CloseWithError(errors.New("transfer has stopped"))
+		}()
+	}
+	return w.body.Write(p)
+}
+
+// close finish the body and wait until it's sent. Returns the number of sent bytes.
+func (w *throttledResponse) close() (int64, error) {
+	if w.body == nil {
+		return 0, nil
+	}
+	w.body.Close()
+	<-w.sent
+	if w.err == io.EOF {
+		return w.n, nil
+	}
+	return w.n, w.err
+}
+
+// flushWriter sends written data to the client at once, as responses are buffered otherwise,
+// and a throttled body would be sent in bursts.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (w flushWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if flusher, ok := w.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
@@ -0,0 +1,120 @@
+package qos_test
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kolotaev/qos"
+)
+
+// newHTTPFileServer start an HTTP file server of files for tests and get its URL.
+func newHTTPFileServer(t *testing.T, th *qos.Throttler) (*qos.HTTPFileServer, string) {
+	fsys := fstest.MapFS{
+		"small.txt":    {Data: []byte("Go is awesome."), ModTime: testModTime},
+		"embedded.txt": {Data: []byte("Go is awesome.")},
+		"big.txt":      {Data: []byte(strings.Repeat("a", 3000)), ModTime: testModTime},
+		"docs/c.md":    {Data: []byte("ccc"), ModTime: testModTime},
+		".hidden":      {Data: []byte("secret")},
+	}
+	s := qos.NewHTTPFileServerFS(th, fsys, log.New(ioutil.Discard, "", 0))
+	go s.Serve("tcp", "127.0.0.1:0")
+	t.Cleanup(func() {
+		s.Stop()
+	})
+	var address net.Addr
+	require.Eventually(t, func() bool {
+		address = th.Addr()
+		return address != nil
+	}, time.Second, 10*time.Millisecond)
+	return s, "http://" + address.String()
+}
+
+func httpGet(t *testing.T, url string, header map[string]string) (*http.Response, string) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res, string(body)
+}
+
+func TestHTTPFileServer_Get(t *testing.T) {
+	_, url := newHTTPFileServer(t, qos.NewThrottler(1000, true))
+
+	res, body := httpGet(t, url+"/small.txt", nil)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "Go is awesome.", body)
+	assert.Equal(t, "14", res.Header.Get("Content-Length"))
+	assert.Equal(t, "text/plain; charset=utf-8", res.Header.Get("Content-Type"))
+	assert.Equal(t, "Sun, 18 Oct 2026 10:00:00 GMT", res.Header.Get("Last-Modified"))
+	etag := res.Header.Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	res, body = httpGet(t, url+"/small.txt", map[string]string{"Range": "bytes=6-12"})
+	assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+	assert.Equal(t, "awesome", body)
+	assert.Equal(t, "bytes 6-12/14", res.Header.Get("Content-Range"))
+
+	res, _ = httpGet(t, url+"/small.txt", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, http.StatusNotModified, res.StatusCode)
+	res, _ = httpGet(t, url+"/small.txt", map[string]string{"If-Modified-Since": "Sun, 18 Oct 2026 10:00:00 GMT"})
+	assert.Equal(t, http.StatusNotModified, res.StatusCode)
+
+	// Files without a modification time are tagged by their checksum
+	res, _ = httpGet(t, url+"/embedded.txt", nil)
+	assert.Equal(t, `"`+smallChecksum+`"`, res.Header.Get("ETag"))
+
+	res, err := http.Head(url + "/docs/c.md")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, int64(3), res.ContentLength)
+
+	res, body = httpGet(t, url+"/.hidden", nil)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	assert.Equal(t, "forbidden: `.hidden` is a hidden file\n", body)
+	res, _ = httpGet(t, url+"/missing.txt", nil)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	res, _ = httpGet(t, url+"/docs", nil)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	res, err = http.Post(url+"/small.txt", "text/plain", strings.NewReader("Go"))
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+}
+
+func TestHTTPFileServer_Throttled(t *testing.T) {
+	th := qos.NewThrottler(1000, true)
+	_, url := newHTTPFileServer(t, th)
+
+	start := time.Now()
+	res, body := httpGet(t, url+"/big.txt", nil)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Len(t, body, 3000)
+	assert.GreaterOrEqual(t, time.Since(start).Seconds(), 2.0)
+
+	// Connections are controlled as the ones of TCP file servers
+	res, err := http.Get(url + "/big.txt")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	connections := th.Connections()
+	require.Len(t, connections, 1)
+	assert.True(t, th.KillConnection(connections[0].Key))
+	body2, err := ioutil.ReadAll(res.Body)
+	assert.Error(t, err)
+	assert.Less(t, len(body2), 3000)
+}
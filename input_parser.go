@@ -3,21 +3,109 @@ package qos
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strings"
 )
 
+// Command parsing errors
+var (
+	ErrEmptyCommand      = errors.New("command can not be an empty string")
+	ErrUnknownCommand    = errors.New("received unknown command")
+	ErrArgsCountMismatch = errors.New("command arguments count mismatch")
+)
+
+// ArgKind kind of the first argument of a command, e.g. for completion in clients
+type ArgKind int
+
+// Argument kinds
+const (
+	ArgOther ArgKind = iota
+	ArgServerName
+	ArgConnection
+	ArgFileName // name of a file, a directory or a glob on a file server
+)
+
+// CommandScope servers that handle a command
+type CommandScope int
+
+// Command scopes
+const (
+	AdminCommand CommandScope = 1 << iota
+	FileServerCommand
+	AnyServerCommand = AdminCommand | FileServerCommand
+)
+
 // Commands language
 var commandLanguageRules = map[string]struct {
-	ActionMark  string
-	ArgsCount   int
-	IsHalt      bool
-	Description string
+	ActionMark        string
+	ArgsCount         int
+	OptionalArgsCount int
+	IsHalt            bool
+	FirstArg          ArgKind
+	Scope             CommandScope
+	Description       string
 }{
-	"STOP":     {"STOP", 0, true, "Stop server"},
-	"FILE":     {"FILE", 1, false, "Download a file (args: file_name)"},
-	"THROTTLE": {"THROTTLE", 2, false, "Enable or disable throttling for a server (args: yes/no)"},
-	"SLIMIT":   {"SLIMIT", 2, false, "Set bandwidth limit per server (args: srv_name limit_number)"},
-	"CLIMIT":   {"CLIMIT", 2, false, "Set bandwidth limit per connection (args: srv_name limit_number)"},
+	"STOP":     {"STOP", 0, 0, true, ArgOther, AnyServerCommand, "Stop server"},
+	"FILE":     {"FILE", 1, 3, false, ArgFileName, FileServerCommand, "Download a file or a range of it, optionally compressed (args: file_name [offset] [length] [enc=gzip|deflate])"},
+	"THROTTLE": {"THROTTLE", 2, 0, false, ArgServerName, AdminCommand, "Enable or disable throttling for a server (args: srv_name yes/no)"},
+	"SLIMIT":   {"SLIMIT", 2, 0, false, ArgServerName, AdminCommand, "Set bandwidth limit per server (args: srv_name limit_number)"},
+	"CLIMIT":   {"CLIMIT", 2, 0, false, ArgConnection, AdminCommand, "Set bandwidth limit per connection (args: conn_address limit_number)"},
+	"CLIST":    {"CLIST", 1, 0, false, ArgServerName, AdminCommand, "List connections of a server with their limits (args: srv_name)"},
+	"CGET":     {"CGET", 1, 0, false, ArgConnection, AdminCommand, "Show limits of a connection on every server (args: conn_address)"},
+	"KILL":     {"KILL", 1, 0, false, ArgConnection, AdminCommand, "Close a connection (args: conn_address)"},
+	"SLIST":    {"SLIST", 0, 0, false, ArgOther, AdminCommand, "List servers with their limits"},
+	"SGET":     {"SGET", 1, 0, false, ArgServerName, AdminCommand, "Show limit of a server (args: srv_name)"},
+	"AUTH":     {"AUTH", 2, 0, false, ArgOther, AdminCommand, "Authenticate admin session (args: user password)"},
+	"PROTO":    {"PROTO", 1, 0, false, ArgOther, AnyServerCommand, "Switch session responses format (args: text/resp/json on admin server, raw/framed on file server)"},
+	"MULTI":    {"MULTI", 0, 0, false, ArgOther, AdminCommand, "Start a transaction of SLIMIT, CLIMIT and THROTTLE commands"},
+	"EXEC":     {"EXEC", 0, 0, false, ArgOther, AdminCommand, "Validate and atomically apply all commands of the transaction"},
+	"DISCARD":  {"DISCARD", 0, 0, false, ArgOther, AdminCommand, "Discard all commands of the transaction"},
+	"HISTORY":  {"HISTORY", 0, 1, false, ArgOther, AdminCommand, "Show recent admin changes from the audit log (args: [count])"},
+	"VERSIONS": {"VERSIONS", 1, 0, false, ArgServerName, AdminCommand, "List configuration versions of a server (args: srv_name)"},
+	"VDIFF":    {"VDIFF", 3, 0, false, ArgServerName, AdminCommand, "Show changes between configuration versions (args: srv_name from_version to_version)"},
+	"ROLLBACK": {"ROLLBACK", 2, 0, false, ArgServerName, AdminCommand, "Restore a previous configuration version of a server (args: srv_name version)"},
+	"PUT":      {"PUT", 2, 0, false, ArgFileName, FileServerCommand, "Upload a file of the given size, send its contents after OK reply (args: file_name size)"},
+	"RELOAD":   {"RELOAD", 0, 0, false, ArgOther, AdminCommand, "Reload configuration of running servers"},
+	"SHUTDOWN": {"SHUTDOWN", 0, 1, false, ArgOther, AdminCommand, "Gracefully stop all servers letting transfers finish (args: [drain_timeout])"},
+	"LIST":     {"LIST", 0, 5, false, ArgFileName, FileServerCommand, "List files of a directory (args: [dir] [recursive] [match=glob] [limit=n] [after=cursor])"},
+	"STAT":     {"STAT", 1, 0, false, ArgFileName, FileServerCommand, "Show mode, size and modification time of a file or a directory (args: name)"},
+	"BATCH":    {"BATCH", 1, maxBatchArgs - 1, false, ArgFileName, FileServerCommand, "Download files as a tar archive (args: name_or_glob ...)"},
+	"SUM":      {"SUM", 1, 1, false, ArgFileName, FileServerCommand, "Show size and checksum of a file without downloading it (args: file_name [sha256/crc32c])"},
+	"DRYRUN":   {"DRYRUN", 3, 0, false, ArgOther, AdminCommand, "Show connection limits SLIMIT or CLIMIT would result in without applying it (args: command ...)"},
+}
+
+// CommandInfo describes a command of the commands language, e.g. for help and completion in clients.
+type CommandInfo struct {
+	Name              string
+	ArgsCount         int
+	OptionalArgsCount int
+	FirstArg          ArgKind
+	Scope             CommandScope
+	Description       string
+}
+
+// HandledBy is the command handled by servers of a scope?
+func (c CommandInfo) HandledBy(scope CommandScope) bool {
+	return c.Scope&scope != 0
+}
+
+// Commands get all commands of the commands language sorted by name.
+func Commands() []CommandInfo {
+	res := make([]CommandInfo, 0, len(commandLanguageRules))
+	for _, rule := range commandLanguageRules {
+		res = append(res, CommandInfo{
+			Name:              rule.ActionMark,
+			ArgsCount:         rule.ArgsCount,
+			OptionalArgsCount: rule.OptionalArgsCount,
+			FirstArg:          rule.FirstArg,
+			Scope:             rule.Scope,
+			Description:       rule.Description,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
 }
 
 // Command convenient command object from a parsed text command
@@ -27,6 +115,11 @@ type Command struct {
 	IsHalt bool
 }
 
+// handledBy is the command handled by servers of a scope?
+func (c *Command) handledBy(scope CommandScope) bool {
+	return commandLanguageRules[c.Action].Scope&scope != 0
+}
+
 // GetArg return command argument by number
 func (c *Command) GetArg(number int) string {
 	if len(c.Args) == 0 {
@@ -38,22 +131,37 @@ func (c *Command) GetArg(number int) string {
 	return c.Args[number]
 }
 
+// String get the command back in its text form
+func (c *Command) String() string {
+	return strings.Join(append([]string{c.Action}, c.Args...), " ")
+}
+
 // ParseInput parse raw string input into a command object
 func ParseInput(input string) (*Command, error) {
 	input = strings.TrimSpace(input)
-	inputs := strings.Split(input, " ")
+	return ParseArgs(strings.Split(input, " "))
+}
 
+// ParseArgs parse an already tokenized input into a command object
+func ParseArgs(inputs []string) (*Command, error) {
 	if len(inputs) == 0 {
-		return nil, errors.New("command can not be an empty string")
+		return nil, ErrEmptyCommand
 	}
 	if _, ok := commandLanguageRules[inputs[0]]; !ok {
-		return nil, fmt.Errorf("received unknown command: `%s`", input)
+		return nil, fmt.Errorf("%w: `%s`", ErrUnknownCommand, strings.Join(inputs, " "))
 	}
 
 	commandRule := commandLanguageRules[inputs[0]]
-	if len(inputs)-1 != commandRule.ArgsCount {
+	argsCount := len(inputs) - 1
+	if argsCount < commandRule.ArgsCount || argsCount > commandRule.ArgsCount+commandRule.OptionalArgsCount {
+		if commandRule.OptionalArgsCount == 0 {
+			return nil, fmt.Errorf(
+				"%w. Got: %d. Want: %d", ErrArgsCountMismatch, argsCount, commandRule.ArgsCount,
+			)
+		}
 		return nil, fmt.Errorf(
-			"command arguments count mismatch. Got: %d. Want: %d", len(inputs)-1, commandRule.ArgsCount,
+			"%w. Got: %d. Want: %d-%d", ErrArgsCountMismatch, argsCount,
+			commandRule.ArgsCount, commandRule.ArgsCount+commandRule.OptionalArgsCount,
 		)
 	}
 
@@ -63,7 +171,7 @@ func ParseInput(input string) (*Command, error) {
 		IsHalt: commandRule.IsHalt,
 	}
 
-	for i := 1; i <= commandRule.ArgsCount; i++ {
+	for i := 1; i <= argsCount; i++ {
 		cmd.Args = append(cmd.Args, strings.TrimSpace(inputs[i]))
 	}
 
@@ -40,14 +40,18 @@ func TestParseInput(t *testing.T) {
 		{"", nil, true, "received unknown command: ``"},
 		{"  ", nil, true, "received unknown command: ``"},
 		{"foobar", nil, true, "received unknown command: `foobar`"},
-		{"FILE", nil, true, "command arguments count mismatch. Got: 0. Want: 1"},
+		{"FILE", nil, true, "command arguments count mismatch. Got: 0. Want: 1-4"},
 
 		{"STOP", &qos.Command{"STOP", []string{}, true}, false, ""},
 		{"  STOP", &qos.Command{"STOP", []string{}, true}, false, ""},
 		{"FILE a.txt", &qos.Command{"FILE", []string{"a.txt"}, false}, false, ""},
+		{"FILE a.txt 100 50", &qos.Command{"FILE", []string{"a.txt", "100", "50"}, false}, false, ""},
 		{"THROTTLE srv1 33", &qos.Command{"THROTTLE", []string{"srv1", "33"}, false}, false, ""},
 		{"SLIMIT srv1 122   ", &qos.Command{"SLIMIT", []string{"srv1", "122"}, false}, false, ""},
 		{"CLIMIT 127.0.0.1:88888 500", &qos.Command{"CLIMIT", []string{"127.0.0.1:88888", "500"}, false}, false, ""},
+		{"HISTORY", &qos.Command{"HISTORY", []string{}, false}, false, ""},
+		{"HISTORY 5", &qos.Command{"HISTORY", []string{"5"}, false}, false, ""},
+		{"HISTORY 5 6", nil, true, "command arguments count mismatch. Got: 2. Want: 0-1"},
 	}
 
 	for i, tc := range cases {
@@ -61,3 +65,30 @@ func TestParseInput(t *testing.T) {
 		}
 	}
 }
+
+func TestParseArgs(t *testing.T) {
+	res, err := qos.ParseArgs([]string{"SLIMIT", "srv1", "12"})
+	assert.NoError(t, err)
+	assert.Equal(t, &qos.Command{"SLIMIT", []string{"srv1", "12"}, false}, res)
+
+	_, err = qos.ParseArgs([]string{})
+	assert.EqualError(t, err, "command can not be an empty string")
+
+	_, err = qos.ParseArgs([]string{"slimit", "srv1", "12"})
+	assert.EqualError(t, err, "received unknown command: `slimit srv1 12`")
+}
+
+func TestCommands(t *testing.T) {
+	commands := map[string]qos.CommandInfo{}
+	for _, c := range qos.Commands() {
+		commands[c.Name] = c
+	}
+	assert.Equal(t, qos.ArgServerName, commands["SLIMIT"].FirstArg)
+	assert.Equal(t, qos.ArgConnection, commands["KILL"].FirstArg)
+	assert.Equal(t, qos.ArgFileName, commands["BATCH"].FirstArg)
+	assert.True(t, commands["SLIMIT"].HandledBy(qos.AdminCommand))
+	assert.False(t, commands["SLIMIT"].HandledBy(qos.FileServerCommand))
+	assert.False(t, commands["FILE"].HandledBy(qos.AdminCommand))
+	assert.True(t, commands["PROTO"].HandledBy(qos.AdminCommand))
+	assert.True(t, commands["PROTO"].HandledBy(qos.FileServerCommand))
+}
@@ -0,0 +1,308 @@
+package qos
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrBadFileEntry a line of LIST or STAT reply can't be parsed
+var ErrBadFileEntry = errors.New("malformed file entry")
+
+// Page sizes of LIST command
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// Prefix of the cursor of the next page in LIST replies
+const nextPrefix = "next:"
+
+// FileEntry metadata of a file or a directory reported by LIST and STAT commands as a line
+// `<mode> <size> <modification_time> <name>`, e.g.
+//
+//	-rw-r--r-- 14 2026-10-18T10:00:00Z docs/small.txt
+//
+// Names are slash separated and relative to the base directory, names of directories end with a slash.
+type FileEntry struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	Mode    os.FileMode
+}
+
+func (e FileEntry) String() string {
+	return fmt.Sprintf("%s %d %s %s", e.Mode, e.Size, e.ModTime.UTC().Format(time.RFC3339), e.Name)
+}
+
+// IsDir is the entry a directory?
+func (e FileEntry) IsDir() bool {
+	return e.Mode.IsDir()
+}
+
+// ParseFileEntry parse a line of LIST or STAT reply. The mode is parsed back from its string form
+// only as far as the type and the permission bits.
+func ParseFileEntry(line string) (*FileEntry, error) {
+	line = strings.TrimRight(line, "\r\n")
+	fields := strings.SplitN(line, " ", 4)
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("%w: `%s`", ErrBadFileEntry, line)
+	}
+	mode, err := parseFileMode(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad mode in `%s`", ErrBadFileEntry, line)
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("%w: bad size in `%s`", ErrBadFileEntry, line)
+	}
+	modTime, err := time.Parse(time.RFC3339, fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad modification time in `%s`", ErrBadFileEntry, line)
+	}
+	return &FileEntry{Name: fields[3], Size: size, ModTime: modTime, Mode: mode}, nil
+}
+
+func parseFileMode(s string) (os.FileMode, error) {
+	if len(s) < 10 {
+		return 0, errors.New("mode is too short")
+	}
+	var mode os.FileMode
+	switch s[len(s)-10] {
+	case 'd':
+		mode |= os.ModeDir
+	case 'L':
+		mode |= os.ModeSymlink
+	case '-':
+	default:
+		// Other types can't be served, they are reported as irregular files
+		mode |= os.ModeIrregular
+	}
+	const rwx = "rwxrwxrwx"
+	for i, c := range s[len(s)-9:] {
+		if c == rune(rwx[i]) {
+			mode |= 1 << uint(8-i)
+		}
+	}
+	return mode, nil
+}
+
+// ReadFileList read a reply to LIST command.
+// next is the cursor to request the next page with, it's empty for the last page.
+func ReadFileList(r *bufio.Reader) (entries []FileEntry, next string, err error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, "Error: ") {
+		return nil, "", fmt.Errorf("%w: %s", ErrFileRequest, strings.TrimPrefix(line, "Error: "))
+	}
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) < 2 || fields[0] != "OK" {
+		return nil, "", fmt.Errorf("%w: `%s`", ErrBadFileHeader, line)
+	}
+	count, err := strconv.Atoi(fields[1])
+	if err != nil || count < 0 {
+		return nil, "", fmt.Errorf("%w: bad count in `%s`", ErrBadFileHeader, line)
+	}
+	if len(fields) == 3 {
+		if !strings.HasPrefix(fields[2], nextPrefix) {
+			return nil, "", fmt.Errorf("%w: `%s`", ErrBadFileHeader, line)
+		}
+		next = strings.TrimPrefix(fields[2], nextPrefix)
+	}
+
+	entries = make([]FileEntry, 0, count)
+	for i := 0; i < count; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return entries, next, err
+		}
+		entry, err := ParseFileEntry(line)
+		if err != nil {
+			return entries, next, err
+		}
+		entries = append(entries, *entry)
+	}
+	return entries, next, nil
+}
+
+// listOptions options of LIST command
+type listOptions struct {
+	dir       string
+	recursive bool
+	match     string
+	limit     int
+	after     string
+}
+
+// parseListOptions parse `LIST [dir] [recursive] [match=glob] [limit=n] [after=cursor]`.
+// The first argument is a directory unless it's an option, so `./recursive` lists a directory named `recursive`.
+func parseListOptions(cmd *Command) (listOptions, error) {
+	opts := listOptions{dir: ".", limit: defaultListLimit}
+	args := cmd.Args
+	if len(args) > 0 && args[0] != "recursive" && !strings.Contains(args[0], "=") {
+		opts.dir = args[0]
+		args = args[1:]
+	}
+	for _, arg := range args {
+		name, value := arg, ""
+		if i := strings.Index(arg, "="); i >= 0 {
+			name, value = arg[:i], arg[i+1:]
+		}
+		switch name {
+		case "recursive":
+			opts.recursive = true
+		case "match":
+			if err := validatePatterns([]string{value}); err != nil {
+				return opts, err
+			}
+			opts.match = value
+		case "limit":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 || n > maxListLimit {
+				return opts, fmt.Errorf("%w: limit must be a number from 1 to %d, got `%s`", ErrBadArgument, maxListLimit, value)
+			}
+			opts.limit = n
+		case "after":
+			opts.after = value
+		default:
+			return opts, fmt.Errorf("%w: unknown LIST option `%s`, want recursive, match, limit or after", ErrBadArgument, arg)
+		}
+	}
+	return opts, nil
+}
+
+// listFiles get a reply to LIST command: `OK <count> [next:<cursor>]` followed by lines of FileEntry.
+// Entries are sorted by name, and files the sandbox refuses to serve are left out.
+func (s *TCPFileServer) listFiles(cmd *Command) ([]string, error) {
+	opts, err := parseListOptions(cmd)
+	if err != nil {
+		return nil, err
+	}
+	sandbox := s.getSandbox()
+	dirName, err := sandbox.resolveDir(opts.dir)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fs.Stat(sandbox.fsys, dirName)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%w: `%s` is not a directory", ErrBadArgument, opts.dir)
+	}
+
+	entries := []FileEntry{}
+	err = listDir(sandbox, dirName, opts, &entries)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	first := sort.Search(len(entries), func(i int) bool {
+		return entries[i].Name > opts.after
+	})
+	entries = entries[first:]
+	header := fmt.Sprintf("OK %d", len(entries))
+	if len(entries) > opts.limit {
+		entries = entries[:opts.limit]
+		header = fmt.Sprintf("OK %d %s%s", len(entries), nextPrefix, entries[len(entries)-1].Name)
+	}
+	lines := []string{header}
+	for _, entry := range entries {
+		lines = append(lines, entry.String())
+	}
+	return lines, nil
+}
+
+// listDir collect entries of a directory of the sandbox's file system, and of its subdirectories
+// if the listing is recursive. Directories reached through symbolic links are not entered to avoid cycles.
+func listDir(sandbox *Sandbox, dirName string, opts listOptions, entries *[]FileEntry) error {
+	dirEntries, err := fs.ReadDir(sandbox.fsys, dirName)
+	if err != nil {
+		return err
+	}
+	for _, dirEntry := range dirEntries {
+		name := path.Join(dirName, dirEntry.Name())
+		link := dirEntry.Type()&fs.ModeSymlink != 0
+		var info fs.FileInfo
+		if link {
+			// Links are reported as what they point to
+			info, err = fs.Stat(sandbox.fsys, name)
+		} else {
+			info, err = dirEntry.Info()
+		}
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			if _, err := sandbox.resolveDir(name); err != nil {
+				continue
+			}
+			if opts.match == "" || matchAny([]string{opts.match}, name) {
+				*entries = append(*entries, newFileEntry(name+"/", info))
+			}
+			if opts.recursive && !link {
+				if err := listDir(sandbox, name, opts, entries); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		if _, err := sandbox.Resolve(name); err != nil {
+			continue
+		}
+		if opts.match == "" || matchAny([]string{opts.match}, name) {
+			*entries = append(*entries, newFileEntry(name, info))
+		}
+	}
+	return nil
+}
+
+// statFile get a reply to STAT command: `OK <file_entry>`.
+func (s *TCPFileServer) statFile(cmd *Command) (string, error) {
+	sandbox := s.getSandbox()
+	name, err := sandbox.resolveDir(cmd.GetArg(0))
+	if err != nil {
+		return "", err
+	}
+	info, err := fs.Stat(sandbox.fsys, name)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "OK " + newFileEntry(name+"/", info).String(), nil
+	}
+	// Files are checked against the allow list too
+	if _, err := sandbox.Resolve(name); err != nil {
+		return "", err
+	}
+	return "OK " + newFileEntry(name, info).String(), nil
+}
+
+func newFileEntry(name string, info os.FileInfo) FileEntry {
+	size := info.Size()
+	if info.IsDir() {
+		size = 0
+	}
+	return FileEntry{
+		Name:    name,
+		Size:    size,
+		ModTime: info.ModTime().UTC().Truncate(time.Second),
+		Mode:    info.Mode() & (os.ModeType | os.ModePerm),
+	}
+}
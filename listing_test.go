@@ -0,0 +1,129 @@
+package qos_test
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kolotaev/qos"
+)
+
+func names(entries []qos.FileEntry) []string {
+	res := []string{}
+	for _, e := range entries {
+		res = append(res, e.Name)
+	}
+	return res
+}
+
+func TestTCPFileServer_List(t *testing.T) {
+	_, dir := newFileServerTestDir(t)
+	s := qos.NewTCPFileServer(qos.NewThrottler(100, true), dir, log.New(ioutil.Discard, "", 0))
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+	list := func(request string) ([]qos.FileEntry, string, error) {
+		client.Write([]byte(request + "\n"))
+		return qos.ReadFileList(reader)
+	}
+
+	// Hidden files and links pointing outside of the base directory are not listed
+	entries, next, err := list("LIST")
+	require.NoError(t, err)
+	assert.Equal(t, "", next)
+	assert.Equal(t, []string{"b.txt", "c.md", "docs/", "key.pem", "link_dir/", "link_in", "link_pem", "small.txt"},
+		names(entries))
+	assert.Equal(t, qos.FileEntry{Name: "b.txt", Size: 2, ModTime: testModTime, Mode: 0644}, entries[0])
+	assert.True(t, entries[2].IsDir())
+
+	entries, _, err = list("LIST docs")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"docs/d.txt", "docs/sub/"}, names(entries))
+
+	entries, _, err = list("LIST recursive match=*.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b.txt", "docs/d.txt", "docs/sub/e.txt", "small.txt"}, names(entries))
+
+	// Pages follow each other with a cursor
+	entries, next, err = list("LIST docs recursive limit=2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"docs/d.txt", "docs/sub/"}, names(entries))
+	assert.Equal(t, "docs/sub/", next)
+	entries, next, err = list("LIST docs recursive limit=2 after=" + next)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"docs/sub/e.txt"}, names(entries))
+	assert.Equal(t, "", next)
+
+	errorCases := map[string]string{
+		"LIST ..":              "forbidden: `..` escapes the base directory",
+		"LIST small.txt":       "bad argument: `small.txt` is not a directory",
+		"LIST limit=0":         "bad argument: limit must be a number from 1 to 1000, got `0`",
+		"LIST match=[a-":       "bad argument: bad pattern `[a-`: syntax error in pattern",
+		"LIST docs sorted=yes": "bad argument: unknown LIST option `sorted=yes`, want recursive, match, limit or after",
+	}
+	for request, message := range errorCases {
+		_, _, err := list(request)
+		assert.EqualError(t, err, "file request failed: "+message, request)
+	}
+}
+
+func TestTCPFileServer_ListWithAllowList(t *testing.T) {
+	_, dir := newFileServerTestDir(t)
+	s := qos.NewTCPFileServer(qos.NewThrottler(100, true), dir, log.New(ioutil.Discard, "", 0))
+	sandbox := qos.NewSandbox(dir)
+	require.NoError(t, sandbox.SetAllowList("*.md"))
+	s.SetSandbox(sandbox)
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+
+	// Directories are listed even though only files matching the allow list are
+	client.Write([]byte("LIST recursive\n"))
+	entries, _, err := qos.ReadFileList(reader)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c.md", "docs/", "docs/sub/", "link_dir/"}, names(entries))
+}
+
+func TestTCPFileServer_Stat(t *testing.T) {
+	_, dir := newFileServerTestDir(t)
+	s := qos.NewTCPFileServer(qos.NewThrottler(100, true), dir, log.New(ioutil.Discard, "", 0))
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+	stat := func(request string) string {
+		client.Write([]byte(request + "\n"))
+		res, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		return res
+	}
+
+	assert.Equal(t, "OK -rw------- 4 2026-10-18T10:00:00Z docs/d.txt\n", stat("STAT docs/d.txt"))
+	res := stat("STAT ./docs")
+	assert.Regexp(t, `^OK drwx.* 0 \S+ docs/\n$`, res)
+	entry, err := qos.ParseFileEntry(res[len("OK "):])
+	require.NoError(t, err)
+	assert.True(t, entry.IsDir())
+	assert.Equal(t, "docs/", entry.Name)
+
+	assert.Equal(t, "Error: forbidden: `.hidden` is a hidden file\n", stat("STAT .hidden"))
+	assert.Equal(t, "Error: forbidden: `link_out` is a symbolic link pointing outside of the base directory\n",
+		stat("STAT link_out"))
+}
+
+func TestTCPFileServer_ListFS(t *testing.T) {
+	s := qos.NewTCPFileServerFS(qos.NewThrottler(100, true), newFileServerTestFS(), log.New(ioutil.Discard, "", 0))
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+
+	// Directories are implied by the names of files in it
+	client.Write([]byte("LIST docs recursive\n"))
+	entries, _, err := qos.ReadFileList(reader)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"docs/d.txt", "docs/sub/", "docs/sub/e.txt"}, names(entries))
+	assert.Equal(t, qos.FileEntry{Name: "docs/sub/e.txt", Size: 5, ModTime: testModTime, Mode: 0644}, entries[2])
+
+	client.Write([]byte("STAT docs/sub/e.txt\n"))
+	res, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "OK -rw-r--r-- 5 2026-10-18T10:00:00Z docs/sub/e.txt\n", res)
+}
@@ -0,0 +1,213 @@
+package qos
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RESP2 framing limits
+const (
+	respMaxArgs      = 64
+	respMaxBulkBytes = 64 * 1024
+)
+
+// ErrRESPProtocol malformed RESP input
+var ErrRESPProtocol = errors.New("protocol error")
+
+// readRESPArray read a RESP2 array of bulk strings, e.g. `*2\r\n$5\r\nSGET\r\n$4\r\nsrv1\r\n`.
+func readRESPArray(r *bufio.Reader) ([]string, error) {
+	count, err := readRESPHeader(r, '*', respMaxArgs)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		size, err := readRESPHeader(r, '$', respMaxBulkBytes)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, fmt.Errorf("%w: bulk string is not terminated with CRLF", ErrRESPProtocol)
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+func readRESPHeader(r *bufio.Reader, kind byte, max int) (int, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return 0, err
+	}
+	line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+	if len(line) == 0 || line[0] != kind {
+		return 0, fmt.Errorf("%w: expected '%c', got `%s`", ErrRESPProtocol, kind, line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > max {
+		return 0, fmt.Errorf("%w: invalid length `%s`", ErrRESPProtocol, line[1:])
+	}
+	return n, nil
+}
+
+// writeRESP write a value as RESP2: strings as bulk strings, numbers and booleans as integers,
+// slices as arrays and nil as a null bulk string.
+func writeRESP(w io.Writer, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		io.WriteString(w, "$-1\r\n")
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case int:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case bool:
+		if v {
+			io.WriteString(w, ":1\r\n")
+		} else {
+			io.WriteString(w, ":0\r\n")
+		}
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeRESP(w, item)
+		}
+	default:
+		writeRESP(w, fmt.Sprint(v))
+	}
+}
+
+// respResponder responds in RESP2 format so admin server can be driven with Redis clients.
+// Records are replied as flat arrays of field names and values, similar to HGETALL.
+type respResponder struct{}
+
+func (respResponder) respondText(conn io.Writer, txt string) {
+	io.WriteString(conn, "+"+txt+"\r\n")
+}
+
+func (respResponder) respondResult(conn io.Writer, res interface{}) {
+	switch r := res.(type) {
+	case nil:
+		io.WriteString(conn, "+OK\r\n")
+	case statusResult:
+		io.WriteString(conn, "+"+string(r)+"\r\n")
+	case *ChangeResult:
+		io.WriteString(conn, "+"+respSimple(withWarnings("OK", r.Warnings))+"\r\n")
+	case []TransactionReport:
+		// Like Redis EXEC, reply with an array of each command's reply
+		fmt.Fprintf(conn, "*%d\r\n", len(r))
+		for _, report := range r {
+			io.WriteString(conn, "+"+respSimple(withWarnings(report.Status, report.Warnings))+"\r\n")
+		}
+	case []AuditRecord:
+		items := []interface{}{}
+		for _, record := range r {
+			line, _ := json.Marshal(record)
+			items = append(items, string(line))
+		}
+		writeRESP(conn, items)
+	case []ServerAllocationChange:
+		items := []interface{}{}
+		for _, change := range r {
+			items = append(items, []interface{}{
+				"server", change.Server, "key", change.Key, "old_limit", change.OldLimit, "new_limit", change.NewLimit,
+			})
+		}
+		writeRESP(conn, items)
+	case []ThrottlerConfig:
+		items := []interface{}{}
+		for _, cfg := range r {
+			items = append(items, respConfig(cfg))
+		}
+		writeRESP(conn, items)
+	case []ConfigChange:
+		items := []interface{}{}
+		for _, change := range r {
+			item := []interface{}{"setting", change.Setting, "old_value", change.OldValue, "new_value", change.NewValue}
+			if change.Server != "" {
+				item = append([]interface{}{"server", change.Server}, item...)
+			}
+			items = append(items, item)
+		}
+		writeRESP(conn, items)
+	case []ServerInfo:
+		items := []interface{}{}
+		for _, srv := range r {
+			items = append(items, respServer(srv))
+		}
+		writeRESP(conn, items)
+	case *ServerInfo:
+		writeRESP(conn, respServer(*r))
+	case []ConnectionInfo:
+		items := []interface{}{}
+		for _, c := range r {
+			items = append(items, respConnection(c))
+		}
+		writeRESP(conn, items)
+	case []ServerConnectionInfo:
+		items := []interface{}{}
+		for _, c := range r {
+			items = append(items, append([]interface{}{"server", c.Server}, respConnection(c.ConnectionInfo)...))
+		}
+		writeRESP(conn, items)
+	default:
+		writeRESP(conn, r)
+	}
+}
+
+func (respResponder) respondError(conn io.Writer, err error) {
+	// Error prefixes follow Redis conventions
+	prefix := "ERR"
+	switch {
+	case errors.Is(err, ErrAuthRequired):
+		prefix = "NOAUTH"
+	case errors.Is(err, ErrBadCredentials):
+		prefix = "WRONGPASS"
+	case errors.Is(err, ErrPermissionDenied):
+		prefix = "NOPERM"
+	case errors.Is(err, ErrTransactionAborted):
+		prefix = "EXECABORT"
+	}
+	io.WriteString(conn, "-"+prefix+" "+respSimple(err.Error())+"\r\n")
+}
+
+// respSimple make a text safe to be sent as a simple string or an error.
+func respSimple(txt string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(txt)
+}
+
+func respServer(srv ServerInfo) []interface{} {
+	return []interface{}{"name", srv.Name, "limit", srv.Limit, "enabled", srv.Enabled, "connections", srv.Connections}
+}
+
+func respConnection(c ConnectionInfo) []interface{} {
+	return []interface{}{"key", c.Key, "limit", c.Limit, "individual", c.HasIndividualLimit}
+}
+
+func respConfig(cfg ThrottlerConfig) []interface{} {
+	keys := []string{}
+	for k := range cfg.ConnectionLimits {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	limits := []interface{}{}
+	for _, k := range keys {
+		limits = append(limits, k, cfg.ConnectionLimits[k])
+	}
+	return []interface{}{
+		"version", cfg.Version, "time", cfg.Time.Format(time.RFC3339),
+		"limit", cfg.Limit, "enabled", cfg.Enabled, "connection_limits", limits,
+	}
+}
@@ -0,0 +1,70 @@
+package qos
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadRESPArray(t *testing.T) {
+	cases := []struct {
+		input     string
+		expected  []string
+		errorText string
+	}{
+		{"*2\r\n$4\r\nSGET\r\n$4\r\nsrv1\r\n", []string{"SGET", "srv1"}, ""},
+		{"*0\r\n", []string{}, ""},
+		{"*1\r\n$0\r\n\r\n", []string{""}, ""},
+		{"*1\n$5\nSLIST\r\n", []string{"SLIST"}, ""},
+		{"*x\r\n", nil, "protocol error: invalid length `x`"},
+		{"*65\r\n", nil, "protocol error: invalid length `65`"},
+		{"*1\r\n:5\r\n", nil, "protocol error: expected '$', got `:5`"},
+		{"*1\r\n$2\r\nSLIST\r\n", nil, "protocol error: bulk string is not terminated with CRLF"},
+		{"*1\r\n$5\r\nSL", nil, "unexpected EOF"},
+	}
+
+	for i, tc := range cases {
+		msg := fmt.Sprintf("Test case #%d", i)
+		res, err := readRESPArray(bufio.NewReader(strings.NewReader(tc.input)))
+		if tc.errorText != "" {
+			assert.EqualError(t, err, tc.errorText, msg)
+		} else {
+			assert.NoError(t, err, msg)
+			assert.Equal(t, tc.expected, res, msg)
+		}
+	}
+}
+
+func TestWriteRESP(t *testing.T) {
+	w := bytes.NewBuffer([]byte(""))
+	writeRESP(w, []interface{}{"name", "srv1", "limit", int64(30), "enabled", true, "connections", 0, nil})
+	assert.Equal(t, "*9\r\n$4\r\nname\r\n$4\r\nsrv1\r\n$5\r\nlimit\r\n:30\r\n$7\r\nenabled\r\n:1\r\n"+
+		"$11\r\nconnections\r\n:0\r\n$-1\r\n", w.String())
+}
+
+func TestRESPResponder(t *testing.T) {
+	r := respResponder{}
+	w := bytes.NewBuffer([]byte(""))
+	r.respondResult(w, nil)
+	assert.Equal(t, "+OK\r\n", w.String())
+
+	w.Reset()
+	r.respondResult(w, []ConnectionInfo{{Key: "A", Limit: 5, HasIndividualLimit: false}})
+	assert.Equal(t, "*1\r\n*6\r\n$3\r\nkey\r\n$1\r\nA\r\n$5\r\nlimit\r\n:5\r\n$10\r\nindividual\r\n:0\r\n", w.String())
+
+	w.Reset()
+	r.respondError(w, fmt.Errorf("%w: role `x` can't run SLIMIT", ErrPermissionDenied))
+	assert.Equal(t, "-NOPERM permission denied: role `x` can't run SLIMIT\r\n", w.String())
+
+	w.Reset()
+	r.respondError(w, ErrAuthRequired)
+	assert.Equal(t, "-NOAUTH authentication required\r\n", w.String())
+
+	w.Reset()
+	r.respondError(w, fmt.Errorf("multi\nline"))
+	assert.Equal(t, "-ERR multi line\r\n", w.String())
+}
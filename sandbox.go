@@ -0,0 +1,318 @@
+package qos
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrForbidden a requested file is outside of what a Sandbox allows to serve
+var ErrForbidden = errors.New("forbidden")
+
+// SymlinkPolicy how a Sandbox treats symbolic links
+type SymlinkPolicy string
+
+// Symbolic link policies
+const (
+	SymlinksInside SymlinkPolicy = "inside" // follow links that resolve inside the base directory
+	SymlinksDeny   SymlinkPolicy = "deny"   // refuse files reached through any link
+	SymlinksFollow SymlinkPolicy = "follow" // follow any links, even outside of the base directory
+)
+
+var symlinkPolicies = map[SymlinkPolicy]bool{
+	SymlinksInside: true,
+	SymlinksDeny:   true,
+	SymlinksFollow: true,
+}
+
+// Sandbox resolves file names requested by clients to paths inside a base directory or a file system.
+// Names are relative to the base directory: absolute names and `..` elements are refused,
+// as well as hidden files (with a name starting with a dot) unless they are allowed.
+//
+// Allow and deny lists are glob patterns, see path.Match. A pattern with a slash is matched against
+// the whole slash separated name, otherwise against the last element of it, e.g. `*.txt` matches `docs/a.txt`.
+// Deny list wins over allow list, and an empty allow list allows everything.
+// A Sandbox must not be changed once it's used by a server, set up a new one instead.
+type Sandbox struct {
+	fsys          fs.FS
+	baseDirectory string // empty if the file system isn't a directory, links are then up to the file system
+	symlinks      SymlinkPolicy
+	allow         []string
+	deny          []string
+	allowHidden   bool
+}
+
+// NewSandbox Sandbox ctor. By default only links that stay inside the base directory are followed.
+func NewSandbox(baseDirectory string) *Sandbox {
+	return &Sandbox{
+		fsys:          os.DirFS(baseDirectory),
+		baseDirectory: baseDirectory,
+		symlinks:      SymlinksInside,
+	}
+}
+
+// NewSandboxFS Sandbox ctor of a file system other than a directory, e.g. embed.FS, a zip archive or fstest.MapFS.
+// Symbolic link policy doesn't apply to it, and files can't be uploaded to it.
+func NewSandboxFS(fsys fs.FS) *Sandbox {
+	return &Sandbox{
+		fsys:     fsys,
+		symlinks: SymlinksInside,
+	}
+}
+
+// SetSymlinkPolicy set how symbolic links are treated.
+func (s *Sandbox) SetSymlinkPolicy(policy SymlinkPolicy) error {
+	if !symlinkPolicies[policy] {
+		return fmt.Errorf("%w: unknown symlink policy `%s`, want inside, deny or follow", ErrBadArgument, policy)
+	}
+	s.symlinks = policy
+	return nil
+}
+
+// SetAllowList serve only files matching any of the patterns.
+func (s *Sandbox) SetAllowList(patterns ...string) error {
+	if err := validatePatterns(patterns); err != nil {
+		return err
+	}
+	s.allow = patterns
+	return nil
+}
+
+// SetDenyList never serve files matching any of the patterns.
+func (s *Sandbox) SetDenyList(patterns ...string) error {
+	if err := validatePatterns(patterns); err != nil {
+		return err
+	}
+	s.deny = patterns
+	return nil
+}
+
+// AllowHidden allow or refuse to serve hidden files and files in hidden directories.
+func (s *Sandbox) AllowHidden(allow bool) {
+	s.allowHidden = allow
+}
+
+// Resolve get a path of a requested file, or its name in the file system if the sandbox isn't of a directory.
+// Returns ErrForbidden if the file is not allowed to be served.
+func (s *Sandbox) Resolve(name string) (string, error) {
+	fsName, filePath, err := s.resolve(name, true, false)
+	if s.baseDirectory == "" {
+		return fsName, err
+	}
+	return filePath, err
+}
+
+// open open a requested file of the file system, the caller must close it.
+func (s *Sandbox) open(name string) (*servedFile, error) {
+	fsName, filePath, err := s.resolve(name, true, false)
+	if err != nil {
+		return nil, err
+	}
+	if s.baseDirectory == "" {
+		return openServedFile(s.fsys, fsName, fsName)
+	}
+	file, err := openServedFile(os.DirFS(filepath.Dir(filePath)), filepath.Base(filePath), filePath)
+	if err != nil {
+		return nil, relativeError(err, fsName)
+	}
+	// Unless links are followed the checked path has none, so a file is refused
+	// if a link was put in its place after it was checked
+	if s.symlinks != SymlinksFollow {
+		if info, err := os.Lstat(filePath); err != nil || !os.SameFile(info, file.info) {
+			file.Close()
+			return nil, fmt.Errorf("%w: `%s` was replaced while it was opened", ErrForbidden, fsName)
+		}
+	}
+	return file, nil
+}
+
+// resolveDir get a name of a requested directory in the file system.
+// The allow list isn't applied, as it's meant for files in it.
+func (s *Sandbox) resolveDir(name string) (string, error) {
+	fsName, _, err := s.resolve(name, false, false)
+	return fsName, err
+}
+
+// resolveNew get a path of a file that may not exist yet, e.g. to upload it. Its directory must exist.
+func (s *Sandbox) resolveNew(name string) (string, error) {
+	if s.baseDirectory == "" {
+		return "", fmt.Errorf("%w: file system is read-only", ErrForbidden)
+	}
+	fsName, filePath, err := s.resolve(name, true, true)
+	if err != nil {
+		return "", err
+	}
+	// Links may be followed to read files outside of the base directory, but never to write them
+	dir, err := filepath.EvalSymlinks(filepath.Dir(filePath))
+	if err != nil {
+		return "", relativeError(err, fsName)
+	}
+	base, err := s.realBase()
+	if err != nil {
+		return "", relativeError(err, fsName)
+	}
+	filePath = filepath.Join(dir, filepath.Base(filePath))
+	if isOutside(base, filePath) {
+		return "", fmt.Errorf("%w: `%s` is in a directory outside of the base directory", ErrForbidden, fsName)
+	}
+	return filePath, nil
+}
+
+// resolve get a name of a requested file in the file system, and its path if the sandbox is of a directory.
+func (s *Sandbox) resolve(name string, checkAllowList, allowMissing bool) (fsName, filePath string, err error) {
+	name = strings.TrimSpace(name)
+	forbidden := func(why string) error {
+		return fmt.Errorf("%w: `%s` %s", ErrForbidden, name, why)
+	}
+
+	slashed := filepath.ToSlash(name)
+	switch {
+	case name == "":
+		return "", "", fmt.Errorf("%w: file name is empty", ErrBadArgument)
+	case strings.ContainsRune(name, 0):
+		return "", "", forbidden("contains a NUL character")
+	case path.IsAbs(slashed) || filepath.IsAbs(name) || filepath.VolumeName(name) != "":
+		return "", "", forbidden("is an absolute path")
+	}
+	for _, element := range strings.Split(slashed, "/") {
+		if element == ".." {
+			return "", "", forbidden("escapes the base directory")
+		}
+	}
+
+	fsName = path.Clean(slashed)
+	if refusal := s.refusal(fsName, checkAllowList); refusal != "" {
+		return "", "", forbidden("is " + refusal)
+	}
+	if s.baseDirectory == "" {
+		return fsName, "", nil
+	}
+	filePath, target, err := s.resolvePath(fsName, allowMissing, forbidden)
+	if err != nil {
+		return "", "", err
+	}
+	// A link is refused if its target would be, e.g. `pub -> .env`
+	if target != "" && target != fsName {
+		if refusal := s.refusal(target, checkAllowList); refusal != "" {
+			return "", "", forbidden("is a symbolic link to a file that is " + refusal)
+		}
+	}
+	return fsName, filePath, nil
+}
+
+// refusal get why a file of a cleaned slash separated name isn't served, e.g. `denied`, or empty string if it's served.
+func (s *Sandbox) refusal(name string, checkAllowList bool) string {
+	if !s.allowHidden {
+		for _, element := range strings.Split(name, "/") {
+			if strings.HasPrefix(element, ".") && element != "." {
+				return "a hidden file"
+			}
+		}
+	}
+	if matchAny(s.deny, name) {
+		return "denied"
+	}
+	if checkAllowList && len(s.allow) > 0 && !matchAny(s.allow, name) {
+		return "not allowed"
+	}
+	return ""
+}
+
+// resolvePath get a path of a file in the base directory, following symbolic links as the policy allows,
+// and the slash separated name of the file links point to, empty if it's outside of the base directory.
+func (s *Sandbox) resolvePath(cleaned string, allowMissing bool, forbidden func(why string) error) (string, string, error) {
+	filePath, err := filepath.Abs(filepath.Join(s.baseDirectory, filepath.FromSlash(cleaned)))
+	if err != nil {
+		return "", "", err
+	}
+
+	// Compare the real path with the requested one to find links on the way
+	base, err := s.realBase()
+	if err != nil {
+		return "", "", relativeError(err, cleaned)
+	}
+	resolved, err := filepath.EvalSymlinks(filePath)
+	if os.IsNotExist(err) && allowMissing {
+		var dir string
+		dir, err = filepath.EvalSymlinks(filepath.Dir(filePath))
+		resolved = filepath.Join(dir, filepath.Base(filePath))
+	}
+	if err != nil {
+		if s.symlinks == SymlinksFollow {
+			return filePath, "", nil
+		}
+		return "", "", relativeError(err, cleaned)
+	}
+	if resolved == filepath.Join(base, filepath.FromSlash(cleaned)) {
+		return resolved, cleaned, nil
+	}
+	if s.symlinks == SymlinksDeny {
+		return "", "", forbidden("is a symbolic link")
+	}
+	outside := isOutside(base, resolved)
+	rel, _ := filepath.Rel(base, resolved)
+	switch {
+	case s.symlinks == SymlinksFollow && outside:
+		return filePath, "", nil
+	case s.symlinks == SymlinksFollow:
+		return filePath, filepath.ToSlash(rel), nil
+	case outside:
+		return "", "", forbidden("is a symbolic link pointing outside of the base directory")
+	}
+	return resolved, filepath.ToSlash(rel), nil
+}
+
+// realBase get the absolute path of the base directory with symbolic links evaluated.
+func (s *Sandbox) realBase() (string, error) {
+	base, err := filepath.Abs(s.baseDirectory)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(base)
+}
+
+// isOutside is a real path outside of the real base directory?
+func isOutside(base, realPath string) bool {
+	rel, err := filepath.Rel(base, realPath)
+	return err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// relativeError replace the path of a file system error with the requested name,
+// so the base directory isn't disclosed to clients, e.g. `lstat docs/a.txt: no such file or directory`.
+func relativeError(err error, name string) error {
+	var pathErr *fs.PathError
+	var linkErr *os.LinkError
+	switch {
+	case errors.As(err, &pathErr):
+		return &fs.PathError{Op: pathErr.Op, Path: name, Err: pathErr.Err}
+	case errors.As(err, &linkErr):
+		return &fs.PathError{Op: linkErr.Op, Path: name, Err: linkErr.Err}
+	}
+	return err
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		target := name
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(name)
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%w: bad pattern `%s`: %s", ErrBadArgument, pattern, err)
+		}
+	}
+	return nil
+}
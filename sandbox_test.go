@@ -0,0 +1,141 @@
+package qos_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kolotaev/qos"
+)
+
+func TestSandbox_Resolve(t *testing.T) {
+	_, base := newFileServerTestDir(t)
+
+	cases := []struct {
+		name     string
+		setup    func(s *qos.Sandbox)
+		file     string
+		expected string // resolved path relative to the base directory
+		err      string
+	}{
+		{name: "regular file", file: "small.txt", expected: "small.txt"},
+		{name: "nested file", file: "docs/d.txt", expected: "docs/d.txt"},
+		{name: "redundant elements", file: "./docs//d.txt", expected: "docs/d.txt"},
+		{name: "traversal", file: "../../etc/passwd", err: "forbidden: `../../etc/passwd` escapes the base directory"},
+		{name: "inner traversal", file: "docs/../small.txt", err: "forbidden: `docs/../small.txt` escapes the base directory"},
+		{name: "absolute path", file: "/etc/passwd", err: "forbidden: `/etc/passwd` is an absolute path"},
+		{name: "empty name", file: " ", err: "bad argument: file name is empty"},
+		{name: "hidden file", file: ".hidden", err: "forbidden: `.hidden` is a hidden file"},
+		{name: "file in hidden directory", file: ".git/config", err: "forbidden: `.git/config` is a hidden file"},
+		{
+			name:     "allowed hidden file",
+			setup:    func(s *qos.Sandbox) { s.AllowHidden(true) },
+			file:     ".git/config",
+			expected: ".git/config",
+		},
+		{name: "link inside", file: "link_in", expected: "small.txt"},
+		{name: "file under a linked directory", file: "link_dir/d.txt", expected: "docs/d.txt"},
+		{
+			name: "link outside",
+			file: "link_out",
+			err:  "forbidden: `link_out` is a symbolic link pointing outside of the base directory",
+		},
+		{name: "link to hidden file", file: "link_hidden", err: "forbidden: `link_hidden` is a symbolic link to a file that is a hidden file"},
+		{
+			name:  "link to denied file",
+			setup: func(s *qos.Sandbox) { require.NoError(t, s.SetDenyList("*.pem")) },
+			file:  "link_pem",
+			err:   "forbidden: `link_pem` is a symbolic link to a file that is denied",
+		},
+		{
+			name:  "link to file not in allow list",
+			setup: func(s *qos.Sandbox) { require.NoError(t, s.SetAllowList("link_*")) },
+			file:  "link_in",
+			err:   "forbidden: `link_in` is a symbolic link to a file that is not allowed",
+		},
+		{
+			name:  "link to hidden file followed",
+			setup: func(s *qos.Sandbox) { require.NoError(t, s.SetSymlinkPolicy(qos.SymlinksFollow)) },
+			file:  "link_hidden",
+			err:   "forbidden: `link_hidden` is a symbolic link to a file that is a hidden file",
+		},
+		{
+			name:  "links denied",
+			setup: func(s *qos.Sandbox) { require.NoError(t, s.SetSymlinkPolicy(qos.SymlinksDeny)) },
+			file:  "link_dir/d.txt",
+			err:   "forbidden: `link_dir/d.txt` is a symbolic link",
+		},
+		{
+			name:     "links followed anywhere",
+			setup:    func(s *qos.Sandbox) { require.NoError(t, s.SetSymlinkPolicy(qos.SymlinksFollow)) },
+			file:     "link_out",
+			expected: "link_out",
+		},
+		{
+			name:     "allow list by base name",
+			setup:    func(s *qos.Sandbox) { require.NoError(t, s.SetAllowList("*.txt")) },
+			file:     "docs/d.txt",
+			expected: "docs/d.txt",
+		},
+		{
+			name:  "not in allow list",
+			setup: func(s *qos.Sandbox) { require.NoError(t, s.SetAllowList("*.txt")) },
+			file:  "c.md",
+			err:   "forbidden: `c.md` is not allowed",
+		},
+		{
+			name: "deny list wins",
+			setup: func(s *qos.Sandbox) {
+				require.NoError(t, s.SetAllowList("*"))
+				require.NoError(t, s.SetDenyList("docs/*"))
+			},
+			file: "docs/d.txt",
+			err:  "forbidden: `docs/d.txt` is denied",
+		},
+		{name: "missing file", file: "missing.txt", err: "lstat missing.txt: no such file or directory"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := qos.NewSandbox(base)
+			if tc.setup != nil {
+				tc.setup(s)
+			}
+			resolved, err := s.Resolve(tc.file)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, filepath.Join(base, tc.expected), resolved)
+		})
+	}
+}
+
+func TestSandbox_ForbiddenError(t *testing.T) {
+	s := qos.NewSandbox("./example/files")
+	_, err := s.Resolve("../../go.mod")
+	assert.ErrorIs(t, err, qos.ErrForbidden)
+
+	assert.ErrorIs(t, s.SetSymlinkPolicy("sometimes"), qos.ErrBadArgument)
+	assert.ErrorIs(t, s.SetDenyList("[a-"), qos.ErrBadArgument)
+}
+
+func TestSandbox_ResolveFS(t *testing.T) {
+	s := qos.NewSandboxFS(newFileServerTestFS())
+
+	// Names are resolved within the file system, even if the files don't exist
+	resolved, err := s.Resolve("./docs//d.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "docs/d.txt", resolved)
+	resolved, err = s.Resolve("missing.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "missing.txt", resolved)
+
+	_, err = s.Resolve("../small.txt")
+	assert.EqualError(t, err, "forbidden: `../small.txt` escapes the base directory")
+	require.NoError(t, s.SetDenyList("*.txt"))
+	_, err = s.Resolve("docs/d.txt")
+	assert.EqualError(t, err, "forbidden: `docs/d.txt` is denied")
+}
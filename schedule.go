@@ -0,0 +1,143 @@
+package qos
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Schedule a daily time window with its own server settings, e.g. a lower limit during business hours.
+// Window is from From inclusive to To exclusive in local time and can span midnight, e.g. 22:00-06:00.
+type Schedule struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Limit   int64  `json:"limit"`
+	Enabled *bool  `json:"enabled,omitempty"`
+}
+
+// Validate check window times and the limit.
+func (s Schedule) Validate() error {
+	from, err := parseClock(s.From)
+	if err != nil {
+		return fmt.Errorf("bad `from` time: %s", err)
+	}
+	to, err := parseClock(s.To)
+	if err != nil {
+		return fmt.Errorf("bad `to` time: %s", err)
+	}
+	if from == to {
+		return fmt.Errorf("empty window %s-%s", s.From, s.To)
+	}
+	// A zero limit, also of an omitted one, would stall every transfer
+	if s.Limit <= 0 {
+		return fmt.Errorf("limit must be positive, got %d", s.Limit)
+	}
+	return nil
+}
+
+// isActive is the schedule's window active at a given time?
+func (s Schedule) isActive(t time.Time) bool {
+	from, _ := parseClock(s.From)
+	to, _ := parseClock(s.To)
+	now := t.Hour()*60 + t.Minute()
+	if from < to {
+		return now >= from && now < to
+	}
+	return now >= from || now < to
+}
+
+// parseClock parse `HH:MM` into minutes since midnight.
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("`%s` is not in HH:MM format", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Scheduler switches a Throttler's limit and throttling by daily schedules.
+// Settings are changed only when a window starts or ends, so changes made by admins in between are kept until then.
+// When no window is active the base settings the Throttler had at Scheduler creation are restored.
+type Scheduler struct {
+	throttler   *Throttler
+	schedules   []Schedule
+	baseLimit   int64
+	baseEnabled bool
+	active      int // index of the active schedule, -1 for base settings
+	logger      *log.Logger
+	stop        chan struct{}
+	stopOnce    *sync.Once
+}
+
+// NewScheduler Scheduler ctor. If several windows overlap, the first one of them wins.
+func NewScheduler(throttler *Throttler, schedules []Schedule, logger *log.Logger) *Scheduler {
+	return &Scheduler{
+		throttler:   throttler,
+		schedules:   schedules,
+		baseLimit:   throttler.GetBandwidthLimit(),
+		baseEnabled: throttler.IsEnabled(),
+		active:      -1,
+		logger:      logger,
+		stop:        make(chan struct{}),
+		stopOnce:    new(sync.Once),
+	}
+}
+
+// Run apply schedules every interval until Stop is called.
+func (s *Scheduler) Run(interval time.Duration) {
+	s.Apply(time.Now())
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.Apply(now)
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Stop stop running schedules. Current settings are kept.
+func (s *Scheduler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+// Apply switch settings if a different schedule is active at a given time.
+func (s *Scheduler) Apply(now time.Time) {
+	active := -1
+	for i, schedule := range s.schedules {
+		if schedule.isActive(now) {
+			active = i
+			break
+		}
+	}
+	if active == s.active {
+		return
+	}
+	s.active = active
+
+	limit, enabled := s.baseLimit, s.baseEnabled
+	if active >= 0 {
+		schedule := s.schedules[active]
+		limit = schedule.Limit
+		if schedule.Enabled != nil {
+			enabled = *schedule.Enabled
+		}
+		s.logger.Printf("Schedule %s-%s started: limit=%d enabled=%t", schedule.From, schedule.To, limit, enabled)
+	} else {
+		s.logger.Printf("No schedule is active, base settings restored: limit=%d enabled=%t", limit, enabled)
+	}
+
+	if res := s.throttler.SetBandwidthLimit(limit); res.Reason != "" {
+		s.logger.Printf("Schedule: %s", res.Reason)
+	}
+	if enabled {
+		s.throttler.Enable()
+	} else {
+		s.throttler.Disable()
+	}
+}
@@ -0,0 +1,56 @@
+package qos_test
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kolotaev/qos"
+)
+
+func TestSchedule_Validate(t *testing.T) {
+	assert.NoError(t, qos.Schedule{From: "22:00", To: "06:00", Limit: 5}.Validate())
+	assert.EqualError(t, qos.Schedule{From: "22:00", To: "24:00"}.Validate(), "bad `to` time: `24:00` is not in HH:MM format")
+	assert.EqualError(t, qos.Schedule{From: "10:00", To: "10:00"}.Validate(), "empty window 10:00-10:00")
+	assert.EqualError(t, qos.Schedule{From: "10:00", To: "11:00", Limit: -1}.Validate(), "limit must be positive, got -1")
+	assert.EqualError(t, qos.Schedule{From: "10:00", To: "11:00"}.Validate(), "limit must be positive, got 0")
+}
+
+func TestScheduler_Apply(t *testing.T) {
+	disabled := false
+	th := qos.NewThrottler(30, true)
+	s := qos.NewScheduler(th, []qos.Schedule{
+		{From: "09:00", To: "18:00", Limit: 10},
+		{From: "22:00", To: "06:00", Limit: 100, Enabled: &disabled},
+	}, log.New(ioutil.Discard, "", 0))
+	at := func(clock string) time.Time {
+		t, _ := time.Parse("15:04", clock)
+		return t
+	}
+
+	s.Apply(at("08:59"))
+	assert.Equal(t, int64(30), th.GetBandwidthLimit())
+
+	s.Apply(at("09:00"))
+	assert.Equal(t, int64(10), th.GetBandwidthLimit())
+	assert.True(t, th.IsEnabled())
+
+	// Admin changes within a window are kept until the window ends
+	th.SetBandwidthLimit(15)
+	s.Apply(at("12:00"))
+	assert.Equal(t, int64(15), th.GetBandwidthLimit())
+
+	s.Apply(at("18:00"))
+	assert.Equal(t, int64(30), th.GetBandwidthLimit())
+
+	s.Apply(at("23:30"))
+	assert.Equal(t, int64(100), th.GetBandwidthLimit())
+	assert.False(t, th.IsEnabled())
+
+	s.Apply(at("06:00"))
+	assert.Equal(t, int64(30), th.GetBandwidthLimit())
+	assert.True(t, th.IsEnabled())
+}
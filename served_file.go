@@ -0,0 +1,86 @@
+package qos
+
+import (
+	"io"
+	"io/fs"
+	"io/ioutil"
+)
+
+// servedFile a file opened to be served. Files are read at offsets, so ranges and checksums
+// don't depend on how far the file has been read.
+type servedFile struct {
+	io.ReaderAt
+	file fs.File
+	info fs.FileInfo
+	key  string // path of the file, or its name in a file system other than a directory
+}
+
+// openServedFile open a file of a file system to be served. Files that can't be read at an offset,
+// e.g. compressed entries of zip archives, are read sequentially and reopened to go back.
+func openServedFile(fsys fs.FS, name, key string) (*servedFile, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	f := &servedFile{file: file, info: info, key: key}
+	if readerAt, ok := file.(io.ReaderAt); ok {
+		f.ReaderAt = readerAt
+	} else {
+		f.ReaderAt = &sequentialReaderAt{fsys: fsys, name: name, file: file}
+	}
+	return f, nil
+}
+
+// Stat get the current info of the file, e.g. to tell whether it has changed.
+func (f *servedFile) Stat() (fs.FileInfo, error) {
+	return f.current().Stat()
+}
+
+func (f *servedFile) Close() error {
+	return f.current().Close()
+}
+
+// current get the open file, it's replaced when a sequentially read file is reopened.
+func (f *servedFile) current() fs.File {
+	if r, ok := f.ReaderAt.(*sequentialReaderAt); ok {
+		return r.file
+	}
+	return f.file
+}
+
+// sequentialReaderAt reads a file of a file system at offsets that mostly grow, as transfers do.
+type sequentialReaderAt struct {
+	fsys fs.FS
+	name string
+	file fs.File
+	pos  int64
+}
+
+func (r *sequentialReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < r.pos {
+		file, err := r.fsys.Open(r.name)
+		if err != nil {
+			return 0, err
+		}
+		r.file.Close()
+		r.file, r.pos = file, 0
+	}
+	if off > r.pos {
+		n, err := io.CopyN(ioutil.Discard, r.file, off-r.pos)
+		r.pos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := io.ReadFull(r.file, p)
+	r.pos += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -2,17 +2,44 @@ package qos
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"math"
 	"net"
+	"sort"
 	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
 )
 
+// ConnectionInfo describes an active connection and its effective bandwidth limit.
+type ConnectionInfo struct {
+	Key                string `json:"key"`
+	Limit              int64  `json:"limit"`
+	HasIndividualLimit bool   `json:"individual"`
+}
+
+// Reasons of a bandwidth limit change applied differently than requested
+const (
+	ReasonFreePoolExhausted        = "not enough free bandwidth on the server"
+	ReasonIndividualLimitsRescaled = "individual limits exceed the server limit and were rescaled"
+)
+
+// LimitResult effective outcome of a bandwidth limit change.
+// Reason is empty if the limit was applied as requested and didn't affect other connections.
+type LimitResult struct {
+	Requested int64
+	Applied   int64
+	Reason    string
+	Rescaled  []AllocationChange
+}
+
+// How long a killed connection's handler is given to report the reason before the connection is closed
+const killGracePeriod = time.Second
+
 // Throttler object that limits bandwidth for a particular server and connection.
 // Throttler uses 1 second resolution and allows to set bandwidth limits in bytes.
 // Thus minimum bandwidth value is `1 b/s` which is a fair minimum for a practical usage.
@@ -24,22 +51,33 @@ type Throttler struct {
 	limiter       *rate.Limiter
 	mu            *sync.RWMutex
 	listener      net.Listener
+	conns         map[string]net.Conn
+	cancels       map[string]func(reason error) // cancel transfers of connections, see setCanceler
+	turns         map[string]chan struct{}      // writes to a connection key take turns, see Write
+	versions      []ThrottlerConfig
 }
 
 // NewThrottler Throttler ctor.
 func NewThrottler(totalLimit int64, enabled bool) *Throttler {
-	return &Throttler{
+	t := &Throttler{
 		enabled:       enabled,
 		totalLimit:    totalLimit,
 		freeLimitPool: totalLimit,
 		db:            NewDatabase(),
 		limiter:       rate.NewLimiter(rate.Every(time.Duration(1)*time.Second), 1),
 		mu:            new(sync.RWMutex),
+		conns:         make(map[string]net.Conn),
+		cancels:       make(map[string]func(reason error)),
+		turns:         make(map[string]chan struct{}),
 	}
+	t.snapshot()
+	return t
 }
 
 // Listen start listening to incoming connections.
 func (t *Throttler) Listen(network, address string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	if t.listener != nil {
 		return errors.New("listening was started previously, it can be started only once")
 	}
@@ -51,84 +89,180 @@ func (t *Throttler) Listen(network, address string) error {
 	return nil
 }
 
+// ListenTLS start listening to incoming TLS connections.
+// Throttling is applied to the decrypted byte stream.
+func (t *Throttler) ListenTLS(network, address string, config *tls.Config) error {
+	err := t.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.listener = tls.NewListener(t.listener, config)
+	return nil
+}
+
 // Accept waits for and returns the next connection to the listener.
 func (t *Throttler) Accept() (net.Conn, error) {
-	if t.listener == nil {
+	listener := t.getListener()
+	if listener == nil {
 		return nil, errors.New("please start listening first")
 	}
-	return t.listener.Accept()
+	c, err := listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.conns[c.RemoteAddr().String()] = c
+	return c, nil
 }
 
 // Close closes the listener.
 // Any blocked Accept operations will be unblocked and return errors.
 func (t *Throttler) Close() error {
-	if t.listener == nil {
+	listener := t.getListener()
+	if listener == nil {
 		return errors.New("please start listening first")
 	}
-	return t.listener.Close()
+	return listener.Close()
 }
 
 // Addr returns the listener's network address.
 func (t *Throttler) Addr() net.Addr {
-	if t.listener == nil {
+	listener := t.getListener()
+	if listener == nil {
 		return nil
 	}
-	return t.listener.Addr()
+	return listener.Addr()
+}
+
+func (t *Throttler) getListener() net.Listener {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.listener
 }
 
 // Enable bandwidth limitting.
 func (t *Throttler) Enable() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	t.enabled = true
+	t.snapshot()
 }
 
 // Disable bandwidth limitting.
 func (t *Throttler) Disable() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	t.enabled = false
+	t.snapshot()
 }
 
 // IsEnabled is bandwidth limitting enabled or not?
 func (t *Throttler) IsEnabled() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 	return t.enabled
 }
 
 // Write write data from the input source to an output writer.
+// Cancelling ctx interrupts both waiting for the bandwidth and copying, with ctx error returned.
+// Concurrent writes to the same destKey take turns, so they share the bandwidth of the key.
 func (t *Throttler) Write(ctx context.Context, dest io.Writer,
 	destKey string, src io.Reader) (servedBytes int64, err error) {
 
 	servedBytes = int64(0)
+	dest = &contextWriter{ctx: ctx, w: dest}
 
 	t.RegisterConnection(destKey)
+	turn := t.turn(destKey)
 
 	for {
 		var n int64
-		connectionLimit := t.GetBandwidthLimitForConnection(destKey)
-		err = t.limiter.Wait(ctx)
-		if err != nil {
-			return
-		}
-		if !t.enabled {
-			n, err = io.Copy(dest, src)
-			servedBytes += n
+		var done bool
+		select {
+		case turn <- struct{}{}:
+		case <-ctx.Done():
+			err = ctx.Err()
 			return
 		}
-		n, err = io.CopyN(dest, src, connectionLimit)
+		n, done, err = t.writeChunk(ctx, dest, destKey, src)
+		<-turn
 		servedBytes += n
-		if err != nil {
+		if done || err != nil {
 			return
 		}
 	}
 }
 
+// WriteRange write length bytes of the input source starting at offset to an output writer, see Write.
+func (t *Throttler) WriteRange(ctx context.Context, dest io.Writer,
+	destKey string, src io.ReaderAt, offset, length int64) (int64, error) {
+
+	return t.Write(ctx, dest, destKey, io.NewSectionReader(src, offset, length))
+}
+
+// writeChunk wait for the bandwidth and write as much data as the connection's limit allows.
+// done is true if the source was written entirely.
+func (t *Throttler) writeChunk(ctx context.Context, dest io.Writer,
+	destKey string, src io.Reader) (n int64, done bool, err error) {
+
+	connectionLimit := t.GetBandwidthLimitForConnection(destKey)
+	err = t.limiter.Wait(ctx)
+	if err != nil {
+		if _, ok := ctx.Deadline(); ok && ctx.Err() == nil {
+			// Limiter fails in advance if the bandwidth won't be available before the deadline
+			err = fmt.Errorf("%w: %s", context.DeadlineExceeded, err)
+		}
+		return
+	}
+	if !t.IsEnabled() {
+		n, err = io.Copy(dest, src)
+		return n, true, err
+	}
+	n, err = io.CopyN(dest, src, connectionLimit)
+	return
+}
+
+// turn get a token that writes to a connection key have to hold while writing a chunk.
+func (t *Throttler) turn(connectionKey string) chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	turn, ok := t.turns[connectionKey]
+	if !ok {
+		turn = make(chan struct{}, 1)
+		t.turns[connectionKey] = turn
+	}
+	return turn
+}
+
+// GetBandwidthLimit get bandwidth limitting value for a server.
+func (t *Throttler) GetBandwidthLimit() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.totalLimit
+}
+
 // SetBandwidthLimit set bandwidth limitting value for a server.
-func (t *Throttler) SetBandwidthLimit(limit int64) {
+// Result reports individual limits of connections that had to be rescaled to fit the new limit.
+func (t *Throttler) SetBandwidthLimit(limit int64) LimitResult {
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	res := t.setBandwidthLimit(limit)
+	t.snapshot()
+	return res
+}
+
+func (t *Throttler) setBandwidthLimit(limit int64) LimitResult {
+	res := LimitResult{Requested: limit, Applied: limit}
 
 	// If we increase global limit - just update and increase free pool
 	if limit >= t.totalLimit {
 		t.freeLimitPool += limit - t.totalLimit
 		t.totalLimit = limit
-		return
+		return res
 	}
 
 	// If we decrease global limit - make sure existing individual limits sum
@@ -137,29 +271,59 @@ func (t *Throttler) SetBandwidthLimit(limit int64) {
 	if individualLimitsSum <= limit {
 		t.freeLimitPool = limit - individualLimitsSum
 		t.totalLimit = limit
-		return
+		return res
 	}
 	// If so, just decrease existing individual limits by a proportional amount.
 	minAllowed := int64(math.Floor(float64(limit) / float64(t.db.CountConnectionsWithIndividualLimit())))
+	before := t.db.IndividualLimits()
 	t.db.UpdateIndividualLimits(minAllowed)
 	t.freeLimitPool = 0
 	t.totalLimit = limit
+
+	keys := []string{}
+	for k := range before {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if before[k] != minAllowed {
+			res.Rescaled = append(res.Rescaled, AllocationChange{Key: k, OldLimit: before[k], NewLimit: minAllowed})
+		}
+	}
+	if len(res.Rescaled) > 0 {
+		res.Reason = ReasonIndividualLimitsRescaled
+	}
+	return res
 }
 
 // SetBandwidthLimitForConnection set bandwidth limitting value for a connection.
-func (t *Throttler) SetBandwidthLimitForConnection(limit int64, connectionKey string) {
-	t.RegisterConnection(connectionKey)
-
+// Result reports the applied limit, which is less than requested if the server lacks free bandwidth.
+func (t *Throttler) SetBandwidthLimitForConnection(limit int64, connectionKey string) LimitResult {
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	res := t.setBandwidthLimitForConnection(limit, connectionKey)
+	t.snapshot()
+	return res
+}
+
+func (t *Throttler) setBandwidthLimitForConnection(limit int64, connectionKey string) LimitResult {
+	res := LimitResult{Requested: limit, Applied: limit}
+	// The current individual limit is replaced, so its bandwidth is free again
+	if c := t.db.Get(connectionKey); c != nil && c.Active && c.HasIndividualLimit {
+		t.freeLimitPool += c.Limit
+	}
+	t.db.Activate(connectionKey)
 
 	// We can't allow to use more than we have in free allowed bandwidth per pool
 	if limit > t.freeLimitPool {
 		limit = t.freeLimitPool
+		res.Applied = limit
+		res.Reason = ReasonFreePoolExhausted
 	}
 
 	t.freeLimitPool -= limit
 	t.db.SetLimit(limit, connectionKey)
+	return res
 }
 
 // GetLimitForConnection get bandwidth limitting value for a connection.
@@ -167,16 +331,30 @@ func (t *Throttler) GetBandwidthLimitForConnection(connectionKey string) int64 {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	c := t.db.Get(connectionKey)
-	if !c.Active {
-		return 0
-	}
-	if c.HasIndividualLimit {
-		return c.Limit
-	}
+	return t.connectionLimit(t.db.Get(connectionKey))
+}
 
-	countWithoutIndividualLimits := t.db.CountActiveConnections() - t.db.CountConnectionsWithIndividualLimit()
-	return int64(math.Floor(float64(t.freeLimitPool) / float64(countWithoutIndividualLimits)))
+// Connections get all active connections with their effective bandwidth limits.
+func (t *Throttler) Connections() []ConnectionInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.connections()
+}
+
+func (t *Throttler) connections() []ConnectionInfo {
+	res := []ConnectionInfo{}
+	for _, key := range t.db.Keys() {
+		c := t.db.Get(key)
+		if !c.Active {
+			continue
+		}
+		res = append(res, ConnectionInfo{
+			Key:                key,
+			Limit:              t.connectionLimit(c),
+			HasIndividualLimit: c.HasIndividualLimit,
+		})
+	}
+	return res
 }
 
 // RegisterConnection register a connection.
@@ -193,9 +371,100 @@ func (t *Throttler) UnregisterConnection(connectionKey string) {
 	defer t.mu.Unlock()
 
 	t.db.Deactivate(connectionKey)
+	delete(t.conns, connectionKey)
+	delete(t.cancels, connectionKey)
+	delete(t.turns, connectionKey)
 
 	c := t.db.Get(connectionKey)
-	if c.HasIndividualLimit {
+	if c != nil && c.HasIndividualLimit {
 		t.freeLimitPool += c.Limit
 	}
 }
+
+// KillConnection close a connection accepted by the Throttler.
+// The connection is addressed either by its address or by its host, which kills all connections of the host.
+// If the connection's handler can be cancelled, it's cancelled first, so the reason is reported to the client,
+// and the connection is closed if the handler didn't manage to do it within killGracePeriod.
+// Returns false if there is no such connection.
+func (t *Throttler) KillConnection(connectionKey string) bool {
+	t.mu.Lock()
+	conns := make(map[string]net.Conn)
+	cancels := make(map[string]func(reason error))
+	for address, c := range t.conns {
+		if matchesKey(address, connectionKey) {
+			conns[address] = c
+			delete(t.conns, address)
+		}
+	}
+	for address, cancel := range t.cancels {
+		if matchesKey(address, connectionKey) {
+			cancels[address] = cancel
+			delete(t.cancels, address)
+		}
+	}
+	t.mu.Unlock()
+
+	if len(conns) == 0 && len(cancels) == 0 {
+		return false
+	}
+	for address, cancel := range cancels {
+		cancel(ErrConnectionKilled)
+		if c, ok := conns[address]; ok {
+			time.AfterFunc(killGracePeriod, func() {
+				c.Close()
+			})
+			delete(conns, address)
+		}
+	}
+	for _, c := range conns {
+		c.Close()
+	}
+	return true
+}
+
+// matchesKey is a connection address addressed by a key? A key is either the address or its host.
+func matchesKey(address, key string) bool {
+	if address == key {
+		return true
+	}
+	host, _, err := net.SplitHostPort(address)
+	return err == nil && host == key
+}
+
+// setCanceler set a function that cancels a connection's transfers, it's used to kill the connection.
+func (t *Throttler) setCanceler(connectionKey string, cancel func(reason error)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cancels[connectionKey] = cancel
+}
+
+func (t *Throttler) connectionLimit(c *ConnectionRecord) int64 {
+	if c == nil || !c.Active {
+		return 0
+	}
+	if c.HasIndividualLimit {
+		return c.Limit
+	}
+
+	countWithoutIndividualLimits := t.db.CountActiveConnections() - t.db.CountConnectionsWithIndividualLimit()
+	return int64(math.Floor(float64(t.freeLimitPool) / float64(countWithoutIndividualLimits)))
+}
+
+// updateThrottlers run fn holding locks of all the given throttlers,
+// so changes made by fn with unlocked methods are applied atomically as a single configuration version.
+// Callers must pass throttlers in a consistent order to avoid deadlocks.
+func updateThrottlers(throttlers []*Throttler, fn func()) {
+	locked := make(map[*Throttler]bool)
+	for _, t := range throttlers {
+		if locked[t] {
+			continue
+		}
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		locked[t] = true
+	}
+	fn()
+	for t := range locked {
+		t.snapshot()
+	}
+}
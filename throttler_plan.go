@@ -0,0 +1,69 @@
+package qos
+
+import (
+	"sort"
+	"sync"
+)
+
+// AllocationChange a would-be change of a connection's effective bandwidth limit.
+type AllocationChange struct {
+	Key      string `json:"key"`
+	OldLimit int64  `json:"old_limit"`
+	NewLimit int64  `json:"new_limit"`
+}
+
+// PlanBandwidthLimit compute effective limits of connections that SetBandwidthLimit would result in,
+// without applying it. Every active connection is reported, even if its limit stays the same.
+func (t *Throttler) PlanBandwidthLimit(limit int64) []AllocationChange {
+	return t.plan(func(p *Throttler) {
+		p.setBandwidthLimit(limit)
+	})
+}
+
+// PlanBandwidthLimitForConnection compute effective limits of connections that SetBandwidthLimitForConnection
+// would result in, without applying it. Every active connection is reported, even if its limit stays the same.
+func (t *Throttler) PlanBandwidthLimitForConnection(limit int64, connectionKey string) []AllocationChange {
+	return t.plan(func(p *Throttler) {
+		p.setBandwidthLimitForConnection(limit, connectionKey)
+	})
+}
+
+// plan run fn against a copy of the Throttler's state and compare connection limits before and after it.
+func (t *Throttler) plan(fn func(p *Throttler)) []AllocationChange {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p := &Throttler{
+		enabled:       t.enabled,
+		totalLimit:    t.totalLimit,
+		freeLimitPool: t.freeLimitPool,
+		db:            t.db.Clone(),
+		mu:            new(sync.RWMutex),
+	}
+	fn(p)
+
+	before := make(map[string]int64)
+	for _, c := range t.connections() {
+		before[c.Key] = c.Limit
+	}
+	after := make(map[string]int64)
+	for _, c := range p.connections() {
+		after[c.Key] = c.Limit
+	}
+	keys := []string{}
+	for k := range after {
+		keys = append(keys, k)
+	}
+	for k := range before {
+		if _, ok := after[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	res := make([]AllocationChange, 0, len(keys))
+	for _, k := range keys {
+		res = append(res, AllocationChange{Key: k, OldLimit: before[k], NewLimit: after[k]})
+	}
+	return res
+}
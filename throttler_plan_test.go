@@ -0,0 +1,54 @@
+package qos_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/kolotaev/qos"
+)
+
+func TestThrottler_PlanBandwidthLimit(t *testing.T) {
+	th := qos.NewThrottler(30, true)
+	th.RegisterConnection("C")
+	th.SetBandwidthLimitForConnection(10, "A")
+	th.SetBandwidthLimitForConnection(10, "B")
+
+	assert.Equal(t, []qos.AllocationChange{
+		{Key: "A", OldLimit: 10, NewLimit: 6},
+		{Key: "B", OldLimit: 10, NewLimit: 6},
+		{Key: "C", OldLimit: 10, NewLimit: 0},
+	}, th.PlanBandwidthLimit(12))
+	assert.Equal(t, []qos.AllocationChange{
+		{Key: "A", OldLimit: 10, NewLimit: 10},
+		{Key: "B", OldLimit: 10, NewLimit: 10},
+		{Key: "C", OldLimit: 10, NewLimit: 30},
+	}, th.PlanBandwidthLimit(50))
+
+	// Nothing is applied
+	assert.Equal(t, int64(30), th.GetBandwidthLimit())
+	assert.Equal(t, int64(10), th.GetBandwidthLimitForConnection("A"))
+	assert.Len(t, th.Versions(), 3)
+}
+
+func TestThrottler_PlanBandwidthLimitForConnection(t *testing.T) {
+	th := qos.NewThrottler(30, true)
+	th.RegisterConnection("A")
+	th.SetBandwidthLimitForConnection(20, "B")
+
+	assert.Equal(t, []qos.AllocationChange{
+		{Key: "A", OldLimit: 10, NewLimit: 5},
+		{Key: "B", OldLimit: 20, NewLimit: 20},
+		{Key: "C", OldLimit: 0, NewLimit: 5},
+	}, th.PlanBandwidthLimitForConnection(5, "C"))
+	assert.Equal(t, []qos.AllocationChange{
+		{Key: "A", OldLimit: 10, NewLimit: 0},
+		{Key: "B", OldLimit: 20, NewLimit: 20},
+		{Key: "C", OldLimit: 0, NewLimit: 10},
+	}, th.PlanBandwidthLimitForConnection(50, "C"), "limit is clamped to the free pool")
+
+	assert.Equal(t, []qos.ConnectionInfo{
+		{Key: "A", Limit: 10, HasIndividualLimit: false},
+		{Key: "B", Limit: 20, HasIndividualLimit: true},
+	}, th.Connections())
+}
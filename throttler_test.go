@@ -3,6 +3,7 @@ package qos_test
 import (
 	"bytes"
 	"context"
+	"io/ioutil"
 	"net"
 	"strings"
 	"testing"
@@ -210,3 +211,100 @@ func TestThrottler_NetListenerInterfaceCompilation(t *testing.T) {
 	}(th)
 	assert.NotNil(t, th)
 }
+
+func TestThrottler_Connections(t *testing.T) {
+	th := qos.NewThrottler(50, true)
+	assert.Equal(t, []qos.ConnectionInfo{}, th.Connections())
+
+	th.RegisterConnection("B")
+	th.SetBandwidthLimitForConnection(20, "A")
+	th.RegisterConnection("C")
+	th.UnregisterConnection("C")
+	assert.Equal(t, []qos.ConnectionInfo{
+		{Key: "A", Limit: 20, HasIndividualLimit: true},
+		{Key: "B", Limit: 30, HasIndividualLimit: false},
+	}, th.Connections())
+}
+
+func TestThrottler_UnregisterUnknownConnection(t *testing.T) {
+	th := qos.NewThrottler(50, true)
+	th.UnregisterConnection("A")
+	assert.Equal(t, []qos.ConnectionInfo{}, th.Connections())
+}
+
+func TestThrottler_SetBandwidthLimitForConnectionResult(t *testing.T) {
+	th := qos.NewThrottler(50, true)
+	assert.Equal(t, qos.LimitResult{Requested: 40, Applied: 40}, th.SetBandwidthLimitForConnection(40, "A"))
+	assert.Equal(t, qos.LimitResult{Requested: 30, Applied: 10, Reason: qos.ReasonFreePoolExhausted},
+		th.SetBandwidthLimitForConnection(30, "B"))
+}
+
+func TestThrottler_SetBandwidthLimitForConnectionAgain(t *testing.T) {
+	th := qos.NewThrottler(50, true)
+	th.RegisterConnection("B")
+	assert.Equal(t, qos.LimitResult{Requested: 40, Applied: 40}, th.SetBandwidthLimitForConnection(40, "A"))
+	assert.Equal(t, qos.LimitResult{Requested: 40, Applied: 40}, th.SetBandwidthLimitForConnection(40, "A"))
+	assert.Equal(t, int64(40), th.GetBandwidthLimitForConnection("A"))
+	assert.Equal(t, int64(10), th.GetBandwidthLimitForConnection("B"))
+
+	assert.Equal(t, qos.LimitResult{Requested: 20, Applied: 20}, th.SetBandwidthLimitForConnection(20, "A"))
+	assert.Equal(t, int64(30), th.GetBandwidthLimitForConnection("B"))
+	assert.Equal(t, qos.LimitResult{Requested: 50, Applied: 50}, th.SetBandwidthLimitForConnection(50, "A"))
+	assert.Equal(t, int64(0), th.GetBandwidthLimitForConnection("B"))
+}
+
+func TestThrottler_SetBandwidthLimitResult(t *testing.T) {
+	th := qos.NewThrottler(60, true)
+	th.SetBandwidthLimitForConnection(30, "A")
+	th.SetBandwidthLimitForConnection(20, "B")
+
+	assert.Equal(t, qos.LimitResult{Requested: 50, Applied: 50}, th.SetBandwidthLimit(50))
+	assert.Equal(t, qos.LimitResult{
+		Requested: 20,
+		Applied:   20,
+		Reason:    qos.ReasonIndividualLimitsRescaled,
+		Rescaled:  []qos.AllocationChange{{Key: "A", OldLimit: 30, NewLimit: 10}, {Key: "B", OldLimit: 20, NewLimit: 10}},
+	}, th.SetBandwidthLimit(20))
+}
+
+func TestThrottler_WriteCanceled(t *testing.T) {
+	th := qos.NewThrottler(4, false)
+	out := bytes.NewBufferString("")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n, err := th.Write(ctx, out, "abc", strings.NewReader("Address tradeoff"))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int64(0), n)
+	assert.Equal(t, "", out.String())
+}
+
+func TestThrottler_WriteRange(t *testing.T) {
+	th := qos.NewThrottler(4, false)
+	out := bytes.NewBufferString("")
+	contents := strings.NewReader("Address tradeoff between development cycle time and server performance")
+	n, err := th.WriteRange(context.Background(), out, "abc", contents, 8, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8), n)
+	assert.Equal(t, "tradeoff", out.String())
+}
+
+func TestThrottler_WritesOfSameKeyTakeTurns(t *testing.T) {
+	th := qos.NewThrottler(4, true)
+	th.SetBandwidthLimitForConnection(2, "A")
+	th.SetBandwidthLimitForConnection(2, "B")
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(5500*time.Millisecond, cancel)
+	served := map[string]chan int64{"A": make(chan int64, 2), "B": make(chan int64, 1)}
+	write := func(key string) {
+		n, _ := th.Write(ctx, ioutil.Discard, key, strings.NewReader(strings.Repeat("x", 100)))
+		served[key] <- n
+	}
+	go write("A")
+	go write("A")
+	time.Sleep(100 * time.Millisecond)
+	go write("B")
+
+	// Once B waits, it takes every other chunk: A gets 4 of 6 chunks of 2 bytes instead of 5
+	assert.Equal(t, int64(8), <-served["A"]+<-served["A"])
+	assert.Equal(t, int64(4), <-served["B"])
+}
@@ -0,0 +1,165 @@
+package qos
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Max number of configuration versions kept by a Throttler
+const maxThrottlerVersions = 100
+
+// ErrUnknownVersion configuration version doesn't exist or was already evicted
+var ErrUnknownVersion = errors.New("unknown configuration version")
+
+// ThrottlerConfig versioned snapshot of a Throttler's configuration.
+type ThrottlerConfig struct {
+	Version          int              `json:"version"`
+	Time             time.Time        `json:"time"`
+	Limit            int64            `json:"limit"`
+	Enabled          bool             `json:"enabled"`
+	ConnectionLimits map[string]int64 `json:"connection_limits"`
+}
+
+// ConfigChange a difference of a single setting between two configuration versions.
+// Missing connection limit is reported as an empty value.
+// Server is set only when changes of several servers are reported together.
+type ConfigChange struct {
+	Server   string `json:"server,omitempty"`
+	Setting  string `json:"setting"`
+	OldValue string `json:"old_value"`
+	NewValue string `json:"new_value"`
+}
+
+// Versions get all kept configuration versions, the oldest first.
+func (t *Throttler) Versions() []ThrottlerConfig {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	res := make([]ThrottlerConfig, len(t.versions))
+	copy(res, t.versions)
+	return res
+}
+
+// DiffVersions get changes of configuration made between two versions.
+func (t *Throttler) DiffVersions(from, to int) ([]ConfigChange, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	old, err := t.version(from)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := t.version(to)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []ConfigChange{}
+	if old.Limit != cur.Limit {
+		changes = append(changes, ConfigChange{Setting: "limit", OldValue: fmt.Sprint(old.Limit), NewValue: fmt.Sprint(cur.Limit)})
+	}
+	if old.Enabled != cur.Enabled {
+		changes = append(changes, ConfigChange{Setting: "enabled", OldValue: fmt.Sprint(old.Enabled), NewValue: fmt.Sprint(cur.Enabled)})
+	}
+	keys := []string{}
+	for k := range old.ConnectionLimits {
+		keys = append(keys, k)
+	}
+	for k := range cur.ConnectionLimits {
+		if _, ok := old.ConnectionLimits[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		oldLimit, oldOk := old.ConnectionLimits[k]
+		curLimit, curOk := cur.ConnectionLimits[k]
+		if oldOk == curOk && oldLimit == curLimit {
+			continue
+		}
+		change := ConfigChange{Setting: "connection " + k}
+		if oldOk {
+			change.OldValue = fmt.Sprint(oldLimit)
+		}
+		if curOk {
+			change.NewValue = fmt.Sprint(curLimit)
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// Rollback restore configuration of a previous version.
+// Rollback itself is recorded as a new version.
+func (t *Throttler) Rollback(version int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rollback(version)
+}
+
+func (t *Throttler) rollback(version int) error {
+	cfg, err := t.version(version)
+	if err != nil {
+		return err
+	}
+
+	t.totalLimit = cfg.Limit
+	t.enabled = cfg.Enabled
+	t.freeLimitPool = t.totalLimit - t.db.ReplaceIndividualLimits(cfg.ConnectionLimits)
+	if t.freeLimitPool < 0 {
+		t.freeLimitPool = 0
+	}
+	t.snapshot()
+	return nil
+}
+
+// currentVersion get number of the latest configuration version.
+func (t *Throttler) currentVersion() int {
+	return t.versions[len(t.versions)-1].Version
+}
+
+func (t *Throttler) version(version int) (ThrottlerConfig, error) {
+	for _, v := range t.versions {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return ThrottlerConfig{}, fmt.Errorf("%w %d", ErrUnknownVersion, version)
+}
+
+// snapshot record a new configuration version if configuration changed since the latest one.
+func (t *Throttler) snapshot() {
+	cfg := ThrottlerConfig{
+		Version:          1,
+		Time:             time.Now().UTC(),
+		Limit:            t.totalLimit,
+		Enabled:          t.enabled,
+		ConnectionLimits: t.db.IndividualLimits(),
+	}
+	if len(t.versions) > 0 {
+		latest := t.versions[len(t.versions)-1]
+		if sameConfig(latest, cfg) {
+			return
+		}
+		cfg.Version = latest.Version + 1
+	}
+
+	t.versions = append(t.versions, cfg)
+	if len(t.versions) > maxThrottlerVersions {
+		t.versions = t.versions[len(t.versions)-maxThrottlerVersions:]
+	}
+}
+
+func sameConfig(a, b ThrottlerConfig) bool {
+	if a.Limit != b.Limit || a.Enabled != b.Enabled || len(a.ConnectionLimits) != len(b.ConnectionLimits) {
+		return false
+	}
+	for k, v := range a.ConnectionLimits {
+		if limit, ok := b.ConnectionLimits[k]; !ok || limit != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+package qos_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kolotaev/qos"
+)
+
+func TestThrottler_Versions(t *testing.T) {
+	th := qos.NewThrottler(30, true)
+	th.SetBandwidthLimitForConnection(10, "A")
+	th.SetBandwidthLimitForConnection(10, "A")
+	th.Disable()
+
+	versions := th.Versions()
+	require.Len(t, versions, 3, "unchanged configuration is not recorded")
+	assert.Equal(t, 1, versions[0].Version)
+	assert.Equal(t, map[string]int64{}, versions[0].ConnectionLimits)
+	assert.Equal(t, 2, versions[1].Version)
+	assert.Equal(t, map[string]int64{"A": 10}, versions[1].ConnectionLimits)
+	assert.Equal(t, 3, versions[2].Version)
+	assert.False(t, versions[2].Enabled)
+}
+
+func TestThrottler_DiffVersions(t *testing.T) {
+	th := qos.NewThrottler(30, true)
+	th.SetBandwidthLimitForConnection(10, "A")
+	th.SetBandwidthLimit(8)
+
+	changes, err := th.DiffVersions(1, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []qos.ConfigChange{
+		{Setting: "limit", OldValue: "30", NewValue: "8"},
+		{Setting: "connection A", OldValue: "", NewValue: "8"},
+	}, changes)
+
+	_, err = th.DiffVersions(1, 4)
+	assert.ErrorIs(t, err, qos.ErrUnknownVersion)
+}
+
+func TestThrottler_Rollback(t *testing.T) {
+	th := qos.NewThrottler(30, true)
+	th.RegisterConnection("A")
+	th.RegisterConnection("B")
+	th.SetBandwidthLimitForConnection(10, "A")
+	th.SetBandwidthLimit(8)
+	th.Disable()
+	assert.Equal(t, int64(8), th.GetBandwidthLimitForConnection("A"))
+
+	require.NoError(t, th.Rollback(2))
+	assert.Equal(t, int64(30), th.GetBandwidthLimit())
+	assert.True(t, th.IsEnabled())
+	assert.Equal(t, int64(10), th.GetBandwidthLimitForConnection("A"))
+	assert.Equal(t, int64(20), th.GetBandwidthLimitForConnection("B"))
+	assert.Equal(t, []qos.ConnectionInfo{
+		{Key: "A", Limit: 10, HasIndividualLimit: true},
+		{Key: "B", Limit: 20, HasIndividualLimit: false},
+	}, th.Connections())
+
+	versions := th.Versions()
+	assert.Equal(t, 5, versions[len(versions)-1].Version, "rollback is recorded as a new version")
+	assert.ErrorIs(t, th.Rollback(10), qos.ErrUnknownVersion)
+}
@@ -0,0 +1,167 @@
+package qos_test
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kolotaev/qos"
+)
+
+type testCertificates struct {
+	caFile, serverCertFile, serverKeyFile string
+	clientCert                            tls.Certificate
+	rootCAs                               *x509.CertPool
+}
+
+// generateTestCertificates create a self-signed CA and a server and a client certificates signed by it.
+func generateTestCertificates(t *testing.T) *testCertificates {
+	dir := t.TempDir()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "qos test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	issue := func(serial int64, usage x509.ExtKeyUsage) ([]byte, []byte) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "127.0.0.1"},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		require.NoError(t, err)
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		require.NoError(t, err)
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	}
+
+	certs := &testCertificates{
+		caFile:         filepath.Join(dir, "ca.pem"),
+		serverCertFile: filepath.Join(dir, "server.pem"),
+		serverKeyFile:  filepath.Join(dir, "server.key"),
+		rootCAs:        x509.NewCertPool(),
+	}
+	certs.rootCAs.AddCert(caCert)
+	require.NoError(t, ioutil.WriteFile(certs.caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0600))
+	serverCert, serverKey := issue(2, x509.ExtKeyUsageServerAuth)
+	require.NoError(t, ioutil.WriteFile(certs.serverCertFile, serverCert, 0600))
+	require.NoError(t, ioutil.WriteFile(certs.serverKeyFile, serverKey, 0600))
+	clientCert, clientKey := issue(3, x509.ExtKeyUsageClientAuth)
+	certs.clientCert, err = tls.X509KeyPair(clientCert, clientKey)
+	require.NoError(t, err)
+	return certs
+}
+
+func waitForListener(t *testing.T, address string) {
+	for i := 0; i < 50; i++ {
+		c, err := net.Dial("tcp4", address)
+		if err == nil {
+			c.Close()
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("server at %s didn't start listening", address)
+}
+
+func TestNewTLSConfig_Errors(t *testing.T) {
+	_, err := qos.NewTLSConfig("missing.pem", "missing.key", "")
+	assert.ErrorContains(t, err, "failed to load TLS certificate")
+
+	certs := generateTestCertificates(t)
+	_, err = qos.NewTLSConfig(certs.serverCertFile, certs.serverKeyFile, "missing-ca.pem")
+	assert.ErrorContains(t, err, "failed to read client CA file")
+
+	_, err = qos.NewTLSConfig(certs.serverCertFile, certs.serverKeyFile, certs.serverKeyFile)
+	assert.ErrorContains(t, err, "no certificates found in client CA file")
+}
+
+func TestTCPFileServer_ServeTLS(t *testing.T) {
+	certs := generateTestCertificates(t)
+	config, err := qos.NewTLSConfig(certs.serverCertFile, certs.serverKeyFile, "")
+	require.NoError(t, err)
+
+	address := "127.0.0.1:55881"
+	fileServer := qos.NewTCPFileServer(qos.NewThrottler(7, true), "./example/files", log.New(ioutil.Discard, "", 0))
+	go fileServer.ServeTLS("tcp4", address, config)
+	defer fileServer.Stop()
+	waitForListener(t, address)
+
+	client, err := tls.Dial("tcp4", address, &tls.Config{RootCAs: certs.rootCAs})
+	require.NoError(t, err)
+	defer client.Close()
+
+	start := time.Now()
+	client.Write([]byte("FILE small.txt\n"))
+	res, err := bufio.NewReader(client).ReadString('.')
+	assert.NoError(t, err)
+	assert.Equal(t, "Go is awesome.", res)
+	// 14 plaintext bytes at 7 b/s are throttled regardless of the TLS overhead
+	assert.WithinDuration(t, start.Add(2*time.Second), time.Now(), 1*time.Second)
+}
+
+func TestTCPAdminServer_ServeTLSWithClientCertificates(t *testing.T) {
+	certs := generateTestCertificates(t)
+	config, err := qos.NewTLSConfig(certs.serverCertFile, certs.serverKeyFile, certs.caFile)
+	require.NoError(t, err)
+
+	address := "127.0.0.1:55882"
+	adminServer := newTestAdminServer(map[string]*qos.Throttler{"srv1": qos.NewThrottler(7, true)})
+	go adminServer.ServeTLS("tcp4", address, config)
+	defer adminServer.Stop()
+	waitForListener(t, address)
+
+	// Without a client certificate
+	anonymous, err := tls.Dial("tcp4", address, &tls.Config{RootCAs: certs.rootCAs})
+	if err == nil {
+		defer anonymous.Close()
+		anonymous.Write([]byte("SLIMIT srv1 10\n"))
+		_, err = bufio.NewReader(anonymous).ReadString('\n')
+	}
+	assert.Error(t, err)
+
+	// With a client certificate
+	client, err := tls.Dial("tcp4", address, &tls.Config{
+		RootCAs:      certs.rootCAs,
+		Certificates: []tls.Certificate{certs.clientCert},
+	})
+	require.NoError(t, err)
+	defer client.Close()
+	client.Write([]byte("SLIMIT srv1 10\n"))
+	res, err := bufio.NewReader(client).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "OK\n", res)
+}
@@ -0,0 +1,159 @@
+package qos
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ErrInsufficientSpace an upload doesn't fit on the disk
+var ErrInsufficientSpace = errors.New("not enough disk space")
+
+// errIncompleteUpload an upload failed while its contents were being received,
+// so the rest of them can't be told from the following commands.
+var errIncompleteUpload = errors.New("upload is incomplete")
+
+var errFreeSpaceUnknown = errors.New("free disk space is unknown on this platform")
+
+// OverwritePolicy what an upload of an existing file does
+type OverwritePolicy string
+
+// Overwrite policies
+const (
+	OverwriteDeny    OverwritePolicy = "deny"    // refuse to upload existing files
+	OverwriteReplace OverwritePolicy = "replace" // replace existing files atomically
+)
+
+// Validate is the policy supported?
+func (p OverwritePolicy) Validate() error {
+	if p != OverwriteDeny && p != OverwriteReplace {
+		return fmt.Errorf("%w: unknown overwrite policy `%s`, want deny or replace", ErrBadArgument, p)
+	}
+	return nil
+}
+
+// UploadPolicy rules of PUT uploads to a TCPFileServer.
+type UploadPolicy struct {
+	MaxSize      int64           `json:"max_size,omitempty"`       // largest file that can be uploaded, no limit if zero
+	Overwrite    OverwritePolicy `json:"overwrite,omitempty"`      // existing files are never overwritten if it's empty
+	MinFreeSpace int64           `json:"min_free_space,omitempty"` // bytes that must be left free on the disk after an upload
+}
+
+// validate check the policy and fill in defaults.
+func (p UploadPolicy) validate() (UploadPolicy, error) {
+	if p.Overwrite == "" {
+		p.Overwrite = OverwriteDeny
+	}
+	if err := p.Overwrite.Validate(); err != nil {
+		return p, err
+	}
+	if p.MaxSize < 0 || p.MinFreeSpace < 0 {
+		return p, fmt.Errorf("%w: sizes must not be negative", ErrBadArgument)
+	}
+	return p, nil
+}
+
+// receiveFile get a file uploaded with `PUT file_name size` into a temporary file next to it,
+// and rename it once it's received completely. Contents are read only after the upload is accepted with `OK`,
+// and the reply is `OK <size> <checksum>`. An error after `OK` is wrapped with errIncompleteUpload.
+func (s *TCPFileServer) receiveFile(ctx *reasonContext, cmd *Command, conn net.Conn, reader *bufio.Reader,
+	connectionKey string) (string, error) {
+
+	policy := s.getUploadPolicy()
+	if policy == nil {
+		return "", fmt.Errorf("%w: uploads are disabled", ErrForbidden)
+	}
+	fileName := cmd.GetArg(0)
+	size, err := strconv.ParseInt(cmd.GetArg(1), 10, 64)
+	if err != nil || size < 0 {
+		return "", fmt.Errorf("%w: size must be a non-negative number of bytes, got `%s`", ErrBadArgument, cmd.GetArg(1))
+	}
+	if policy.MaxSize > 0 && size > policy.MaxSize {
+		return "", fmt.Errorf("%w: `%s` of %d bytes exceeds the maximum upload size of %d bytes",
+			ErrForbidden, fileName, size, policy.MaxSize)
+	}
+	filePath, err := s.getSandbox().resolveNew(fileName)
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(filePath); err == nil {
+		if info.IsDir() {
+			return "", fmt.Errorf("%w: `%s` is a directory", ErrBadArgument, fileName)
+		}
+		if policy.Overwrite != OverwriteReplace {
+			return "", fmt.Errorf("%w: `%s` already exists", ErrForbidden, fileName)
+		}
+	}
+	dir := filepath.Dir(filePath)
+	if free, err := freeSpace(dir); err == nil && free-size < policy.MinFreeSpace {
+		return "", fmt.Errorf("%w: `%s` of %d bytes, %d bytes are free and at least %d must be left",
+			ErrInsufficientSpace, fileName, size, free, policy.MinFreeSpace)
+	} else if err != nil && err != errFreeSpaceUnknown {
+		return "", err
+	}
+
+	// Hidden, so it's not served while it's being uploaded
+	temp, err := ioutil.TempFile(dir, ".upload-*")
+	if err != nil {
+		return "", relativeError(err, fileName)
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	// Command deadline is replaced with the transfer timeout, so the transfer is interrupted
+	// even when the client stops sending
+	transferCtx, cancel := s.transferContext(ctx, conn)
+	defer cancel()
+	deadline, _ := transferCtx.Deadline()
+	conn.SetReadDeadline(deadline)
+	if reason := ctx.Reason(); reason != nil {
+		return "", reason
+	}
+	okRespond(conn)
+
+	algorithm := s.getChecksumAlgorithm()
+	hash, _ := algorithm.newHash()
+	contents := io.TeeReader(io.LimitReader(reader, size), hash)
+	n, err := s.throttler.Write(transferCtx, temp, connectionKey, contents)
+	if err == nil || err == io.EOF {
+		if n < size {
+			err = fmt.Errorf("client sent %d of %d bytes", n, size)
+		} else {
+			err = nil
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", errIncompleteUpload, transferError(ctx, "upload", n, err))
+	}
+
+	if err := temp.Chmod(0644); err != nil {
+		return "", relativeError(err, fileName)
+	}
+	if err := temp.Sync(); err != nil {
+		return "", relativeError(err, fileName)
+	}
+	if err := temp.Close(); err != nil {
+		return "", relativeError(err, fileName)
+	}
+	if policy.Overwrite == OverwriteReplace {
+		err = os.Rename(temp.Name(), filePath)
+	} else {
+		// Unlike renaming, linking fails if the file was created during the upload
+		err = os.Link(temp.Name(), filePath)
+		if os.IsExist(err) {
+			err = fmt.Errorf("%w: `%s` already exists", ErrForbidden, fileName)
+		}
+	}
+	if err != nil {
+		return "", relativeError(err, fileName)
+	}
+
+	s.logger.Printf("%d bytes of %s received", n, fileName)
+	return fmt.Sprintf("OK %d %s", n, formatChecksum(algorithm, hash)), nil
+}
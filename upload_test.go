@@ -0,0 +1,146 @@
+package qos_test
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kolotaev/qos"
+)
+
+// newUploadTestServer get a server of newFileServerTestDir with the upload policy and a client of it.
+func newUploadTestServer(t *testing.T, policy *qos.UploadPolicy) (*qos.TCPFileServer, *testClient, string) {
+	_, dir := newFileServerTestDir(t)
+	s := qos.NewTCPFileServer(qos.NewThrottler(100, true), dir, log.New(ioutil.Discard, "", 0))
+	require.NoError(t, s.SetUploadPolicy(policy))
+	conn, reader := newFileServerTestClient(s)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return s, &testClient{conn: conn, reader: reader}, dir
+}
+
+func readFile(t *testing.T, filePath string) string {
+	contents, err := ioutil.ReadFile(filePath)
+	require.NoError(t, err)
+	return string(contents)
+}
+
+func TestTCPFileServer_UploadsDisabled(t *testing.T) {
+	_, c, _ := newUploadTestServer(t, nil)
+	assert.Equal(t, "Error: forbidden: uploads are disabled\n", c.send(t, "PUT new.txt 14"))
+}
+
+func TestTCPFileServer_UploadToReadOnlyFS(t *testing.T) {
+	s := qos.NewTCPFileServerFS(qos.NewThrottler(100, true), newFileServerTestFS(), log.New(ioutil.Discard, "", 0))
+	require.NoError(t, s.SetUploadPolicy(&qos.UploadPolicy{}))
+	client, reader := newFileServerTestClient(s)
+	defer client.Close()
+	c := &testClient{conn: client, reader: reader}
+	assert.Equal(t, "Error: forbidden: file system is read-only\n", c.send(t, "PUT new.txt 14"))
+}
+
+func TestTCPFileServer_Upload(t *testing.T) {
+	_, c, dir := newUploadTestServer(t, &qos.UploadPolicy{})
+
+	assert.Equal(t, "OK\n", c.send(t, "PUT docs.txt 14"))
+	assert.Equal(t, "OK 14 "+smallChecksum+"\n", c.sendRaw(t, "Go is awesome."))
+	assert.Equal(t, "Go is awesome.", readFile(t, filepath.Join(dir, "docs.txt")))
+
+	// Contents buffered along with commands are not lost
+	assert.Equal(t, "OK\n", c.sendRaw(t, "PUT empty.txt 0\nPUT next.txt 2\nok"))
+	assert.Contains(t, c.read(t), "OK 0 sha256:")
+	assert.Equal(t, "OK\n", c.read(t))
+	assert.Contains(t, c.read(t), "OK 2 sha256:")
+	assert.Equal(t, "ok", readFile(t, filepath.Join(dir, "next.txt")))
+
+	// The file is served once it's uploaded
+	assert.Equal(t, "OK 14 "+smallChecksum+"\n", c.send(t, "SUM docs.txt"))
+
+	// No temporary files are left
+	temps, err := filepath.Glob(filepath.Join(dir, ".upload-*"))
+	require.NoError(t, err)
+	assert.Empty(t, temps)
+}
+
+func TestTCPFileServer_UploadRejected(t *testing.T) {
+	_, c, dir := newUploadTestServer(t, &qos.UploadPolicy{MaxSize: 10})
+
+	cases := map[string]string{
+		"PUT small.txt 5\n":        "Error: forbidden: `small.txt` already exists\n",
+		"PUT big.txt 11\n":         "Error: forbidden: `big.txt` of 11 bytes exceeds the maximum upload size of 10 bytes\n",
+		"PUT big.txt -1\n":         "Error: bad argument: size must be a non-negative number of bytes, got `-1`\n",
+		"PUT .profile 5\n":         "Error: forbidden: `.profile` is a hidden file\n",
+		"PUT ../new.txt 5\n":       "Error: forbidden: `../new.txt` escapes the base directory\n",
+		"PUT missing/new.txt 5\n":  "Error: lstat missing/new.txt: no such file or directory\n",
+		"PUT . 5\n":                "Error: bad argument: `.` is a directory\n",
+		"PUT new.txt\n":            "Error: command arguments count mismatch. Got: 1. Want: 2\n",
+		"PUT new.txt 5 extra\n":    "Error: command arguments count mismatch. Got: 3. Want: 2\n",
+		"PUT new.txt five bytes\n": "Error: command arguments count mismatch. Got: 3. Want: 2\n",
+	}
+	for request, res := range cases {
+		assert.Equal(t, res, c.sendRaw(t, request), request)
+	}
+	assert.Equal(t, "Go is awesome.", readFile(t, filepath.Join(dir, "small.txt")))
+
+	_, c, _ = newUploadTestServer(t, &qos.UploadPolicy{MinFreeSpace: 1 << 62})
+	assert.Contains(t, c.send(t, "PUT new.txt 5"), "Error: not enough disk space: `new.txt` of 5 bytes, ")
+}
+
+func TestTCPFileServer_UploadThroughLinks(t *testing.T) {
+	s, c, dir := newUploadTestServer(t, &qos.UploadPolicy{})
+	sandbox := qos.NewSandbox(dir)
+	require.NoError(t, sandbox.SetSymlinkPolicy(qos.SymlinksFollow))
+	s.SetSandbox(sandbox)
+
+	// Links are followed to read files anywhere, but files are only written in the base directory
+	assert.Equal(t, "Error: forbidden: `link_up/evil.txt` is in a directory outside of the base directory\n",
+		c.send(t, "PUT link_up/evil.txt 3"))
+	_, err := os.Stat(filepath.Join(dir, "..", "evil.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Equal(t, "OK\n", c.send(t, "PUT link_dir/new.txt 2"))
+	assert.Contains(t, c.sendRaw(t, "ok"), "OK 2 sha256:")
+	assert.Equal(t, "ok", readFile(t, filepath.Join(dir, "docs", "new.txt")))
+}
+
+func TestTCPFileServer_UploadReplace(t *testing.T) {
+	s, c, dir := newUploadTestServer(t, &qos.UploadPolicy{Overwrite: qos.OverwriteReplace})
+	assert.Equal(t, "OK\n", c.send(t, "PUT small.txt 5"))
+	res := c.sendRaw(t, "Go!!!")
+	assert.Contains(t, res, "OK 5 sha256:")
+	assert.Equal(t, "Go!!!", readFile(t, filepath.Join(dir, "small.txt")))
+
+	assert.EqualError(t, s.SetUploadPolicy(&qos.UploadPolicy{Overwrite: "append"}),
+		"bad argument: unknown overwrite policy `append`, want deny or replace")
+}
+
+func TestTCPFileServer_UploadIncomplete(t *testing.T) {
+	_, c, dir := newUploadTestServer(t, &qos.UploadPolicy{})
+	assert.Equal(t, "OK\n", c.send(t, "PUT new.txt 14"))
+	c.conn.Write([]byte("Go is"))
+	c.conn.Close()
+
+	// Neither the file nor its temporary file are left
+	assert.Eventually(t, func() bool {
+		temps, err := filepath.Glob(filepath.Join(dir, ".upload-*"))
+		return err == nil && len(temps) == 0
+	}, time.Second, 10*time.Millisecond)
+	_, err := os.Stat(filepath.Join(dir, "new.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestTCPFileServer_UploadTimeout(t *testing.T) {
+	s, c, _ := newUploadTestServer(t, &qos.UploadPolicy{})
+	s.SetTransferTimeout(100 * time.Millisecond)
+	assert.Equal(t, "OK\n", c.send(t, "PUT new.txt 14"))
+	c.conn.Write([]byte("Go is"))
+	res := c.read(t)
+	assert.Equal(t, "Error: upload is incomplete: upload was cancelled after 5 bytes: transfer took too long\n", res)
+}
@@ -1,8 +1,12 @@
 package qos
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
+	"sort"
+	"strings"
+	"time"
 )
 
 func textRespond(conn io.Writer, txt string) {
@@ -16,3 +20,158 @@ func okRespond(conn io.Writer) {
 func errorRespond(conn io.Writer, err error) {
 	conn.Write([]byte(fmt.Sprintf("Error: %s\n", err)))
 }
+
+// adminResponder writes admin command results in a protocol specific format
+type adminResponder interface {
+	respondText(conn io.Writer, txt string)
+	respondResult(conn io.Writer, res interface{})
+	respondError(conn io.Writer, err error)
+}
+
+// textResponder responds with plain text lines
+type textResponder struct{}
+
+func (textResponder) respondText(conn io.Writer, txt string) {
+	textRespond(conn, txt)
+}
+
+func (textResponder) respondResult(conn io.Writer, res interface{}) {
+	resultRespond(conn, res)
+}
+
+func (textResponder) respondError(conn io.Writer, err error) {
+	errorRespond(conn, err)
+}
+
+// jsonResponder responds with single line JSON objects
+type jsonResponder struct{}
+
+type jsonResponse struct {
+	Status  string      `json:"status"`
+	Data    interface{} `json:"data,omitempty"`
+	Code    string      `json:"code,omitempty"`
+	Message string      `json:"message,omitempty"`
+}
+
+func (r jsonResponder) respondText(conn io.Writer, txt string) {
+	r.encode(conn, &jsonResponse{Status: "ok", Data: txt})
+}
+
+func (r jsonResponder) respondResult(conn io.Writer, res interface{}) {
+	r.encode(conn, &jsonResponse{Status: "ok", Data: res})
+}
+
+func (r jsonResponder) respondError(conn io.Writer, err error) {
+	r.encode(conn, &jsonResponse{Status: "error", Code: errorCode(err), Message: err.Error()})
+}
+
+func (jsonResponder) encode(conn io.Writer, resp *jsonResponse) {
+	// Responses are not meant for HTML, so keep messages like `A -> B` readable
+	encoder := json.NewEncoder(conn)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(resp)
+}
+
+// newAdminResponder get a responder by its protocol name.
+func newAdminResponder(protocol string) (adminResponder, error) {
+	switch protocol {
+	case "text":
+		return textResponder{}, nil
+	case "resp":
+		return respResponder{}, nil
+	case "json":
+		return jsonResponder{}, nil
+	}
+	return nil, fmt.Errorf("%w: unknown protocol `%s`", ErrBadArgument, protocol)
+}
+
+// resultRespond write an admin command result as text lines followed by OK.
+func resultRespond(conn io.Writer, res interface{}) {
+	switch r := res.(type) {
+	case statusResult:
+		textRespond(conn, string(r))
+		return
+	case *ChangeResult:
+		for _, warning := range r.Warnings {
+			textRespond(conn, "Warning: "+warning)
+		}
+	case []TransactionReport:
+		for _, report := range r {
+			textRespond(conn, report.Command+": "+withWarnings(report.Status, report.Warnings))
+		}
+	case []AuditRecord:
+		for _, record := range r {
+			line, _ := json.Marshal(record)
+			textRespond(conn, string(line))
+		}
+	case []ServerAllocationChange:
+		for _, change := range r {
+			textRespond(conn, fmt.Sprintf("%s %s limit=%d -> %d", change.Server, change.Key, change.OldLimit, change.NewLimit))
+		}
+	case []ThrottlerConfig:
+		for _, cfg := range r {
+			textRespond(conn, formatConfig(cfg))
+		}
+	case []ConfigChange:
+		for _, change := range r {
+			setting := change.Setting
+			if change.Server != "" {
+				setting = change.Server + " " + setting
+			}
+			textRespond(conn, fmt.Sprintf("%s: %s -> %s", setting, OrDash(change.OldValue), OrDash(change.NewValue)))
+		}
+	case []ServerInfo:
+		for _, srv := range r {
+			textRespond(conn, formatServer(srv))
+		}
+	case *ServerInfo:
+		textRespond(conn, formatServer(*r))
+	case []ConnectionInfo:
+		for _, c := range r {
+			textRespond(conn, formatConnection(c))
+		}
+	case []ServerConnectionInfo:
+		for _, c := range r {
+			textRespond(conn, c.Server+" "+formatConnection(c.ConnectionInfo))
+		}
+	}
+	okRespond(conn)
+}
+
+// withWarnings append warnings to a status, e.g. `OK (warning: ...)`.
+func withWarnings(status string, warnings []string) string {
+	if len(warnings) == 0 {
+		return status
+	}
+	return status + " (warning: " + strings.Join(warnings, "; ") + ")"
+}
+
+func formatServer(srv ServerInfo) string {
+	return fmt.Sprintf("%s limit=%d enabled=%t connections=%d", srv.Name, srv.Limit, srv.Enabled, srv.Connections)
+}
+
+func formatConnection(c ConnectionInfo) string {
+	return fmt.Sprintf("%s limit=%d individual=%t", c.Key, c.Limit, c.HasIndividualLimit)
+}
+
+func formatConfig(cfg ThrottlerConfig) string {
+	keys := []string{}
+	for k := range cfg.ConnectionLimits {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	limits := []string{}
+	for _, k := range keys {
+		limits = append(limits, fmt.Sprintf("%s=%d", k, cfg.ConnectionLimits[k]))
+	}
+	return fmt.Sprintf("v%d %s limit=%d enabled=%t connections=[%s]",
+		cfg.Version, cfg.Time.Format(time.RFC3339), cfg.Limit, cfg.Enabled, strings.Join(limits, " "))
+}
+
+// OrDash get a value or `-` if it's empty, so blank values are visible in replies and tables.
+func OrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}